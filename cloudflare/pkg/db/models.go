@@ -34,3 +34,17 @@ type SecretKey struct {
 	Created      int64  `json:"created"`
 	Updated      int64  `json:"updated"`
 }
+
+type Usage struct {
+	ID        int64  `json:"id"`
+	Hour      int64  `json:"hour"`
+	AccessKey string `json:"access_key"`
+	Service   string `json:"service"`
+	Group     string `json:"group"`
+	ChainID   string `json:"chain_id"`
+	Method    string `json:"method"`
+	Status    string `json:"status"`
+	Count     int64  `json:"count"`
+	Created   int64  `json:"created"`
+	Updated   int64  `json:"updated"`
+}
@@ -240,6 +240,52 @@ func (q *Queries) ListSecretKeys(ctx context.Context) ([]SecretKey, error) {
 	return items, nil
 }
 
+const listUsagesByHourRange = `-- name: ListUsagesByHourRange :many
+SELECT id, hour, access_key, service, ` + "`" + `group` + "`" + `, chain_id, method, status, count, created, updated FROM ` + "`" + `usage` + "`" + `
+WHERE ` + "`" + `hour` + "`" + ` >= ? AND ` + "`" + `hour` + "`" + ` < ?
+ORDER BY ` + "`" + `hour` + "`" + `
+`
+
+type ListUsagesByHourRangeParams struct {
+	Hour   int64 `json:"hour"`
+	Hour_2 int64 `json:"hour_2"`
+}
+
+func (q *Queries) ListUsagesByHourRange(ctx context.Context, arg ListUsagesByHourRangeParams) ([]Usage, error) {
+	rows, err := q.db.QueryContext(ctx, listUsagesByHourRange, arg.Hour, arg.Hour_2)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Usage
+	for rows.Next() {
+		var i Usage
+		if err := rows.Scan(
+			&i.ID,
+			&i.Hour,
+			&i.AccessKey,
+			&i.Service,
+			&i.Group,
+			&i.ChainID,
+			&i.Method,
+			&i.Status,
+			&i.Count,
+			&i.Created,
+			&i.Updated,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const updateConfigValue = `-- name: UpdateConfigValue :execresult
 UPDATE config SET ` + "`" + `value` + "`" + ` = ?, updated = ? 
 WHERE ` + "`" + `key` + "`" + ` = ? AND module = ?
@@ -308,3 +354,42 @@ func (q *Queries) UpdateSecretKey(ctx context.Context, arg UpdateSecretKeyParams
 		arg.AccessKey,
 	)
 }
+
+const upsertUsage = `-- name: UpsertUsage :exec
+INSERT INTO ` + "`" + `usage` + "`" + ` (
+  ` + "`" + `hour` + "`" + `, access_key, ` + "`" + `service` + "`" + `, ` + "`" + `group` + "`" + `, chain_id, method, ` + "`" + `status` + "`" + `, ` + "`" + `count` + "`" + `, created, updated
+) VALUES (
+  ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+)
+ON CONFLICT (` + "`" + `hour` + "`" + `, access_key, ` + "`" + `service` + "`" + `, ` + "`" + `group` + "`" + `, chain_id, method, ` + "`" + `status` + "`" + `)
+DO UPDATE SET ` + "`" + `count` + "`" + ` = ` + "`" + `count` + "`" + ` + excluded.` + "`" + `count` + "`" + `, updated = excluded.updated
+`
+
+type UpsertUsageParams struct {
+	Hour      int64  `json:"hour"`
+	AccessKey string `json:"access_key"`
+	Service   string `json:"service"`
+	Group     string `json:"group"`
+	ChainID   string `json:"chain_id"`
+	Method    string `json:"method"`
+	Status    string `json:"status"`
+	Count     int64  `json:"count"`
+	Created   int64  `json:"created"`
+	Updated   int64  `json:"updated"`
+}
+
+func (q *Queries) UpsertUsage(ctx context.Context, arg UpsertUsageParams) error {
+	_, err := q.db.ExecContext(ctx, upsertUsage,
+		arg.Hour,
+		arg.AccessKey,
+		arg.Service,
+		arg.Group,
+		arg.ChainID,
+		arg.Method,
+		arg.Status,
+		arg.Count,
+		arg.Created,
+		arg.Updated,
+	)
+	return err
+}
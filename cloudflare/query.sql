@@ -49,3 +49,18 @@ INSERT INTO config (
 -- name: UpdateConfigValue :execresult
 UPDATE config SET `value` = ?, updated = ? 
 WHERE `key` = ? AND module = ?;
+
+
+-- name: UpsertUsage :exec
+INSERT INTO `usage` (
+  `hour`, access_key, `service`, `group`, chain_id, method, `status`, `count`, created, updated
+) VALUES (
+  ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+)
+ON CONFLICT (`hour`, access_key, `service`, `group`, chain_id, method, `status`)
+DO UPDATE SET `count` = `count` + excluded.`count`, updated = excluded.updated;
+
+-- name: ListUsagesByHourRange :many
+SELECT * FROM `usage`
+WHERE `hour` >= ? AND `hour` < ?
+ORDER BY `hour`;
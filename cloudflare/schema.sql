@@ -30,4 +30,19 @@ CREATE TABLE IF NOT EXISTS config (
     created BIGINT NOT NULL,
     updated BIGINT NOT NULL
 );
-CREATE INDEX IF NOT EXISTS idx_config_on_key ON config (`key`);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_config_on_key ON config (`key`);
+
+CREATE TABLE IF NOT EXISTS `usage` (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    `hour` BIGINT NOT NULL,
+    access_key TEXT NOT NULL,
+    `service` TEXT NOT NULL,
+    `group` TEXT NOT NULL,
+    chain_id TEXT NOT NULL,
+    method TEXT NOT NULL,
+    `status` TEXT NOT NULL,
+    `count` BIGINT NOT NULL,
+    created BIGINT NOT NULL,
+    updated BIGINT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_usage_on_bucket ON `usage` (`hour`, access_key, `service`, `group`, chain_id, method, `status`);
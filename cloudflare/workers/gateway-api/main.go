@@ -32,6 +32,7 @@ func main() {
 	http.HandleFunc("/admin/v1/secret", handler.postSecretKey)
 	http.HandleFunc("/admin/v1/upstream/ready", handler.postReadyUpstream)
 	http.HandleFunc("/admin/v1/config", handler.postConfig)
+	http.HandleFunc("/admin/v1/usage", handler.getUsage)
 	workers.Serve(nil) // use http.DefaultServeMux
 }
 
@@ -274,3 +275,75 @@ func (h *adminHandler) postConfig(w http.ResponseWriter, req *http.Request) {
 	}
 	w.Write([]byte("OK"))
 }
+
+// getUsage lists the hourly usage buckets in [from, to), from and to are dates (2006-01-02) or RFC3339 times
+// and default to the last 24 hours, group and service narrow the result
+func (h *adminHandler) getUsage(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ok, err := h.verifyBasicAuth(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := req.URL.Query()
+	to := time.Now().UTC()
+	if v := query.Get("to"); v != "" {
+		if to, err = parseUsageTime(v); err != nil {
+			http.Error(w, "invalid to", http.StatusBadRequest)
+			return
+		}
+	}
+	from := to.Add(-24 * time.Hour)
+	if v := query.Get("from"); v != "" {
+		if from, err = parseUsageTime(v); err != nil {
+			http.Error(w, "invalid from", http.StatusBadRequest)
+			return
+		}
+	}
+	if !from.Before(to) || to.Sub(from) > maxUsageRange {
+		http.Error(w, "from must be before to and at most 31 days apart", http.StatusBadRequest)
+		return
+	}
+
+	usages, err := h.queries.ListUsagesByHourRange(req.Context(), pkg_db.ListUsagesByHourRangeParams{
+		Hour:   from.UnixMilli(),
+		Hour_2: to.UnixMilli(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	group, service := query.Get("group"), query.Get("service")
+	items := make([]pkg_db.Usage, 0, len(usages))
+	for _, usage := range usages {
+		if (group != "" && usage.Group != group) || (service != "" && usage.Service != service) {
+			continue
+		}
+		items = append(items, usage)
+	}
+
+	jsonBytes, err := json.Marshal(items)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+const maxUsageRange = 31 * 24 * time.Hour
+
+func parseUsageTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	pkg_db "github.com/pundix/chain-gateway/cloudflare/pkg/db"
+)
+
+// breakers live in the isolate, every isolate trips its own
+const breakerPolicyTTL = time.Minute
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breakerPolicy is read from the circuit_breaker config of the upstream module, rates of 0 disable a check
+type breakerPolicy struct {
+	ErrorRate     float64 `json:"errorRate"`
+	SlowCallRate  float64 `json:"slowCallRate"`
+	SlowCallMs    int64   `json:"slowCallMs"`
+	Window        int     `json:"window"`
+	MinCalls      int     `json:"minCalls"`
+	OpenSeconds   int64   `json:"openSeconds"`
+	HalfOpenCalls int     `json:"halfOpenCalls"`
+}
+
+func defaultBreakerPolicy() breakerPolicy {
+	return breakerPolicy{
+		ErrorRate:     0.5,
+		SlowCallMs:    5000,
+		Window:        20,
+		MinCalls:      10,
+		OpenSeconds:   30,
+		HalfOpenCalls: 3,
+	}
+}
+
+func (p breakerPolicy) enabled() bool {
+	return p.Window > 0 && (p.ErrorRate > 0 || p.SlowCallRate > 0)
+}
+
+type breakerOutcome struct {
+	failed bool
+	slow   bool
+}
+
+type circuitBreaker struct {
+	state     breakerState
+	outcomes  []breakerOutcome
+	next      int
+	count     int
+	openedAt  time.Time
+	successes int
+}
+
+type breakerSet struct {
+	policy   breakerPolicy
+	loadedAt time.Time
+	breakers map[string]*circuitBreaker
+}
+
+func newBreakerSet() *breakerSet {
+	return &breakerSet{
+		policy:   defaultBreakerPolicy(),
+		breakers: make(map[string]*circuitBreaker),
+	}
+}
+
+// loadPolicy refreshes the policy from D1 at most once per breakerPolicyTTL
+func (s *breakerSet) loadPolicy(ctx context.Context, queries *pkg_db.Queries) {
+	if time.Since(s.loadedAt) < breakerPolicyTTL {
+		return
+	}
+	s.loadedAt = time.Now()
+	config, err := queries.GetConfigByKey(ctx, pkg_db.GetConfigByKeyParams{
+		Key:    "circuit_breaker",
+		Module: "upstream",
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("load circuit breaker config failed: %s\n", err.Error())
+		}
+		return
+	}
+	policy := defaultBreakerPolicy()
+	if err = json.Unmarshal([]byte(config.Value), &policy); err != nil {
+		log.Printf("invalid circuit breaker config: %s\n", err.Error())
+		return
+	}
+	s.policy = policy
+}
+
+// filter drops the urls behind an open breaker, open breakers past their open duration turn half-open
+func (s *breakerSet) filter(urls []string) []string {
+	if !s.policy.enabled() {
+		return urls
+	}
+	ret := make([]string, 0, len(urls))
+	for _, url := range urls {
+		b, ok := s.breakers[url]
+		if ok && b.state == breakerOpen {
+			if time.Since(b.openedAt) < time.Duration(s.policy.OpenSeconds)*time.Second {
+				continue
+			}
+			s.transition(url, b, breakerHalfOpen)
+		}
+		ret = append(ret, url)
+	}
+	return ret
+}
+
+func (s *breakerSet) record(url string, failed bool, latency time.Duration) {
+	policy := s.policy
+	if !policy.enabled() {
+		return
+	}
+	o := breakerOutcome{
+		failed: failed,
+		slow:   policy.SlowCallRate > 0 && latency >= time.Duration(policy.SlowCallMs)*time.Millisecond,
+	}
+	b, ok := s.breakers[url]
+	if !ok {
+		b = &circuitBreaker{}
+		s.breakers[url] = b
+	}
+	switch b.state {
+	case breakerClosed:
+		if len(b.outcomes) != policy.Window {
+			b.outcomes = make([]breakerOutcome, policy.Window)
+			b.next, b.count = 0, 0
+		}
+		b.outcomes[b.next] = o
+		b.next = (b.next + 1) % len(b.outcomes)
+		if b.count < len(b.outcomes) {
+			b.count++
+		}
+		if b.tripped(policy) {
+			s.transition(url, b, breakerOpen)
+		}
+	case breakerHalfOpen:
+		if o.failed || o.slow {
+			s.transition(url, b, breakerOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= max(policy.HalfOpenCalls, 1) {
+			s.transition(url, b, breakerClosed)
+		}
+	}
+}
+
+func (b *circuitBreaker) tripped(policy breakerPolicy) bool {
+	if b.count < max(policy.MinCalls, 1) {
+		return false
+	}
+	var failed, slow int
+	for _, o := range b.outcomes[:b.count] {
+		if o.failed {
+			failed++
+		}
+		if o.slow {
+			slow++
+		}
+	}
+	n := float64(b.count)
+	return (policy.ErrorRate > 0 && float64(failed)/n >= policy.ErrorRate) ||
+		(policy.SlowCallRate > 0 && float64(slow)/n >= policy.SlowCallRate)
+}
+
+// transition logs the state change as a json line so it can be counted like the request traces
+func (s *breakerSet) transition(url string, b *circuitBreaker, to breakerState) {
+	from := b.state
+	b.state = to
+	switch to {
+	case breakerOpen:
+		b.openedAt = time.Now()
+	case breakerHalfOpen:
+		b.successes = 0
+	case breakerClosed:
+		b.next, b.count = 0, 0
+	}
+	bytes, _ := json.Marshal(map[string]any{
+		"metric": "breaker_state",
+		"url":    url,
+		"from":   from.String(),
+		"to":     to.String(),
+		"state":  int(to),
+	})
+	fmt.Println(string(bytes))
+}
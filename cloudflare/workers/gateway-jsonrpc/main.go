@@ -33,7 +33,9 @@ func main() {
 	defer db.Close()
 
 	handler := &proxyHandler{
-		queries: pkg_db.New(db),
+		queries:  pkg_db.New(db),
+		usage:    newUsageRecorder(),
+		breakers: newBreakerSet(),
 	}
 	http.HandleFunc("/v2/", handler.handleV2)
 	http.HandleFunc("/v1/", handler.handleV1)
@@ -41,7 +43,9 @@ func main() {
 }
 
 type proxyHandler struct {
-	queries *pkg_db.Queries
+	queries  *pkg_db.Queries
+	usage    *usageRecorder
+	breakers *breakerSet
 }
 
 func (h *proxyHandler) handleV1(w http.ResponseWriter, req *http.Request) {
@@ -140,6 +144,8 @@ func (h *proxyHandler) handle(reqParams *requestParams, w http.ResponseWriter, r
 			return
 		}
 		h.handlePostMethod(req.Context(), requestTraceBuilder, reqParams, w)
+		h.usage.record(reqParams.accessKey, requestTraceBuilder.Build())
+		h.usage.flush()
 	}
 }
 
@@ -199,6 +205,7 @@ func (h *proxyHandler) applyRouteRules(ctx context.Context, reqParams *requestPa
 
 func (h *proxyHandler) handlePostMethod(ctx context.Context, requestTraceBuilder *requestTraceBuilder, reqParams *requestParams, w http.ResponseWriter) {
 	requestTraceBuilder.withChainIdAndSource(reqParams.chainId, reqParams.source)
+	h.breakers.loadPolicy(ctx, h.queries)
 	endpointMap, err := h.getChainEndpoins(ctx, reqParams)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -206,6 +213,10 @@ func (h *proxyHandler) handlePostMethod(ctx context.Context, requestTraceBuilder
 		requestTraceBuilder.Build().Println()
 		return
 	}
+	// nodes behind an open circuit breaker are left out of the selection
+	for source, urls := range endpointMap {
+		endpointMap[source] = h.breakers.filter(urls)
+	}
 	if len(endpointMap) == 0 {
 		http.Error(w, "chainId not support, no available nodes", http.StatusBadRequest)
 		requestTraceBuilder.withError(http.StatusBadRequest, "chainId not support, no available nodes")
@@ -299,6 +310,11 @@ func (h *proxyHandler) handlePostMethod(ctx context.Context, requestTraceBuilder
 		}
 		targetUrl := targetUrls[i]
 		requestTraceBuilder.withUpstreamNode(targetUrl)
+		attemptStart := time.Now()
+		failed := true
+		defer func() {
+			h.breakers.record(targetUrl, failed, time.Since(attemptStart))
+		}()
 
 		r, err := fetch.NewRequest(ctx, reqParams.httpMethod, targetUrl, bytes.NewReader(reqParams.body))
 		if err != nil {
@@ -316,6 +332,7 @@ func (h *proxyHandler) handlePostMethod(ctx context.Context, requestTraceBuilder
 
 		// resp body bytes > 5MB
 		if resp.ContentLength > 5*1024*1024 {
+			failed = false
 			requestTraceBuilder.withLargeResponse(time.Since(reqParams.startTime).Milliseconds())
 			return resp, nil
 		}
@@ -331,6 +348,7 @@ func (h *proxyHandler) handlePostMethod(ctx context.Context, requestTraceBuilder
 		if requestTraceBuilder.rt.Status == "200" ||
 			requestTraceBuilder.rt.Status == "3" ||
 			requestTraceBuilder.rt.Status == "200&200" {
+			failed = false
 			return resp, nil
 		}
 		return resp, &RetryableError{
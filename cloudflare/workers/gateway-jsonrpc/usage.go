@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+
+	pkg_db "github.com/pundix/chain-gateway/cloudflare/pkg/db"
+	"github.com/syumai/workers/cloudflare"
+)
+
+// usage of a request is written to D1 after its response, nothing is left in the isolate to lose on eviction
+const maxUsageLabelLen = 128
+
+type usageKey struct {
+	hour      int64
+	accessKey string
+	service   string
+	group     string
+	chainId   string
+	method    string
+	status    string
+}
+
+type usageRecorder struct {
+	buckets map[usageKey]int64
+}
+
+func newUsageRecorder() *usageRecorder {
+	return &usageRecorder{
+		buckets: make(map[usageKey]int64),
+	}
+}
+
+// record counts one request under the hash of its access key, batched calls ("a&b" methods and statuses) are counted per call
+func (u *usageRecorder) record(accessKey string, rt *requestTrace) {
+	methods := strings.Split(rt.Method, "&")
+	statuses := strings.Split(rt.Status, "&")
+	if len(methods) != len(statuses) {
+		methods, statuses = []string{rt.Method}, []string{rt.Status}
+	}
+	hour := time.Now().UTC().Truncate(time.Hour).UnixMilli()
+	for i := range methods {
+		key := usageKey{
+			hour:      hour,
+			accessKey: accessKeyHash(accessKey),
+			service:   usageLabel(rt.Service),
+			group:     usageLabel(rt.Group),
+			chainId:   usageLabel(rt.ChainId),
+			method:    usageLabel(methods[i]),
+			status:    usageLabel(statuses[i]),
+		}
+		u.buckets[key]++
+	}
+}
+
+// flush hands the buckets to WaitUntil, it must be called while serving a request
+func (u *usageRecorder) flush() {
+	if len(u.buckets) == 0 {
+		return
+	}
+	buckets := u.buckets
+	u.buckets = make(map[usageKey]int64)
+
+	cloudflare.WaitUntil(func() {
+		db, err := sql.Open("d1", "DB")
+		if err != nil {
+			log.Printf("error opening DB: %s\n", err.Error())
+			return
+		}
+		defer db.Close()
+		queries := pkg_db.New(db)
+
+		ctx := context.Background()
+		for key, count := range buckets {
+			if err := saveUsage(ctx, queries, key, count); err != nil {
+				log.Printf("save usage failed: %s\n", err.Error())
+			}
+		}
+	})
+}
+
+// saveUsage adds count to the bucket in a single upsert
+func saveUsage(ctx context.Context, queries *pkg_db.Queries, key usageKey, count int64) error {
+	now := time.Now().UnixMilli()
+	return queries.UpsertUsage(ctx, pkg_db.UpsertUsageParams{
+		Hour:      key.hour,
+		AccessKey: key.accessKey,
+		Service:   key.service,
+		Group:     key.group,
+		ChainID:   key.chainId,
+		Method:    key.method,
+		Status:    key.status,
+		Count:     count,
+		Created:   now,
+		Updated:   now,
+	})
+}
+
+// accessKeyHash is the hex SHA-256 of an access key, the same as client.AccessKeyHash on the PocketBase side
+func accessKeyHash(accessKey string) string {
+	sum := sha256.Sum256([]byte(accessKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// usageLabel keeps client supplied labels such as methods from growing the table without bound
+func usageLabel(v string) string {
+	if len(v) > maxUsageLabelLen {
+		return "invalid"
+	}
+	return v
+}
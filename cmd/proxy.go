@@ -1,8 +1,10 @@
 package cmd
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/pundix/chain-gateway/internal/client"
 	"github.com/pundix/chain-gateway/internal/proxy"
 	"github.com/pundix/chain-gateway/pkg/pocketbase"
 	"github.com/spf13/cobra"
@@ -12,25 +14,173 @@ func NewProxyCommand() *cobra.Command {
 	p := &Proxier{}
 	m := &cobra.Command{
 		Use:   "proxy",
-		Short: "proxy grpc endpoint",
+		Short: "proxy grpc or jsonrpc endpoint",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return p.Proxy()
 		},
 	}
 	m.Flags().DurationVar(&p.UpstreamCacheDuration, "duration", 5*time.Minute, "upstream cache duration")
 	m.Flags().StringVar(&p.PocketbaseBaseApi, "api", "http://localhost:8090", "pocketbase api")
+	m.Flags().StringVar(&p.Protocol, "protocol", "grpc", "proxy protocol, grpc, jsonrpc, rest or cometbft")
+	m.Flags().StringVar(&p.Addr, "addr", "", "listen address, defaults to 0.0.0.0:50051 for grpc, 0.0.0.0:8545 for jsonrpc, 0.0.0.0:1317 for rest and 0.0.0.0:26657 for cometbft")
+	m.Flags().DurationVar(&p.Timeout, "timeout", 30*time.Second, "default upstream timeout when the client sets no deadline, 0 disables it")
+	m.Flags().DurationVar(&p.MaxTimeout, "max-timeout", 0, "cap on client supplied deadlines, 0 disables it")
+	m.Flags().StringToStringVar(&p.ChainTimeouts, "chain-timeout", nil, "per chain timeout overrides, e.g. 3448148188=10s")
+	m.Flags().StringToStringVar(&p.MethodTimeouts, "method-timeout", nil, "per method timeout overrides, e.g. /protocol.Wallet/GetNowBlock=5s")
+	m.Flags().Float64Var(&p.HedgePercentile, "hedge-percentile", 0, "hedge reads slower than this latency percentile of their chain, e.g. 0.95, 0 disables hedging")
+	m.Flags().DurationVar(&p.HedgeMinDelay, "hedge-min-delay", 20*time.Millisecond, "lower bound of the hedge delay")
+	m.Flags().Float64Var(&p.HedgeBudget, "hedge-budget", 10, "extra upstream load allowed for hedges, in percent of reads")
+	m.Flags().Float64Var(&p.BreakerErrorRate, "breaker-error-rate", 0.5, "open a node's circuit breaker when this share of its recent calls fail, 0 disables the check")
+	m.Flags().Float64Var(&p.BreakerSlowCallRate, "breaker-slow-rate", 0, "open a node's circuit breaker when this share of its recent calls are slow, 0 disables the check")
+	m.Flags().DurationVar(&p.BreakerSlowCall, "breaker-slow-call", 5*time.Second, "calls slower than this count as slow")
+	m.Flags().IntVar(&p.BreakerWindow, "breaker-window", 20, "recent calls per node the breaker rates are computed over")
+	m.Flags().IntVar(&p.BreakerMinCalls, "breaker-min-calls", 10, "calls needed in the window before a breaker can open")
+	m.Flags().DurationVar(&p.BreakerOpenDuration, "breaker-open", 30*time.Second, "how long an open breaker keeps a node out before probing it")
+	m.Flags().IntVar(&p.BreakerHalfOpenCalls, "breaker-half-open-calls", 3, "successful probes needed to close a half-open breaker, also the most probes a half-open breaker lets run at once")
+	m.Flags().StringVar(&p.Affinity, "affinity", "off", "sticky routing of reads to one node: off, session (access key and session header) or ip")
+	m.Flags().StringVar(&p.AffinityHeader, "affinity-header", "X-Session-Id", "client header carrying the session id for session affinity")
+	m.Flags().DurationVar(&p.HeightInterval, "height-interval", 15*time.Second, "how often jsonrpc node heights are read from upstream_node for block aware routing, 0 disables it")
+	m.Flags().DurationVar(&p.UsageInterval, "usage-interval", time.Minute, "how often usage counts are written to the usage collection, 0 disables usage accounting")
+	m.Flags().StringVar(&p.AdminAddr, "admin-addr", "", "admin api listen address, e.g. 127.0.0.1:9090, empty disables it")
+	m.Flags().StringVar(&p.AdminToken, "admin-token", "", "bearer token required by the admin api, required unless --admin-addr is a loopback address")
+	m.Flags().IntVar(&p.WsMaxConnections, "ws-max-conns", 10, "websocket connections allowed per access key, 0 means unlimited")
+	m.Flags().IntVar(&p.WsMaxSubscriptions, "ws-max-subs", 100, "websocket subscriptions allowed per access key, 0 means unlimited")
 	return m
 }
 
 type Proxier struct {
 	PocketbaseBaseApi     string
+	Protocol              string
+	Addr                  string
 	UpstreamCacheDuration time.Duration
+	Timeout               time.Duration
+	MaxTimeout            time.Duration
+	ChainTimeouts         map[string]string
+	MethodTimeouts        map[string]string
+	HedgePercentile       float64
+	HedgeMinDelay         time.Duration
+	HedgeBudget           float64
+	BreakerErrorRate      float64
+	BreakerSlowCallRate   float64
+	BreakerSlowCall       time.Duration
+	BreakerWindow         int
+	BreakerMinCalls       int
+	BreakerOpenDuration   time.Duration
+	BreakerHalfOpenCalls  int
+	Affinity              string
+	AffinityHeader        string
+	HeightInterval        time.Duration
+	UsageInterval         time.Duration
+	AdminAddr             string
+	AdminToken            string
+	WsMaxConnections      int
+	WsMaxSubscriptions    int
 }
 
 func (p *Proxier) Proxy() error {
+	timeoutPolicy, err := p.timeoutPolicy()
+	if err != nil {
+		return err
+	}
+	if p.HedgePercentile < 0 || p.HedgePercentile > 1 {
+		return fmt.Errorf("hedge percentile must be between 0 and 1")
+	}
+	if p.BreakerErrorRate < 0 || p.BreakerErrorRate > 1 || p.BreakerSlowCallRate < 0 || p.BreakerSlowCallRate > 1 {
+		return fmt.Errorf("breaker rates must be between 0 and 1")
+	}
+	if p.BreakerWindow <= 0 {
+		return fmt.Errorf("breaker window must be positive")
+	}
+	affinityMode, err := proxy.ParseAffinityMode(p.Affinity)
+	if err != nil {
+		return err
+	}
 	cli := pocketbase.New(p.PocketbaseBaseApi)
-	grpc := proxy.NewGrpc(cli)
-	grpc.Duration = p.UpstreamCacheDuration
-	grpc.Fetch()
-	return grpc.Proxy()
+	switch p.Protocol {
+	case "grpc":
+		grpc := proxy.NewGrpc(cli)
+		grpc.Duration = p.UpstreamCacheDuration
+		grpc.Timeout = timeoutPolicy
+		grpc.Hedge = p.hedgePolicy()
+		grpc.Usage.Interval = p.UsageInterval
+		p.applyBreakerPolicy(grpc.Breaker)
+		grpc.Affinity.Mode = affinityMode
+		grpc.Affinity.Header = p.AffinityHeader
+		grpc.AdminAddr = p.AdminAddr
+		grpc.AdminToken = p.AdminToken
+		if p.Addr != "" {
+			grpc.Addr = p.Addr
+		}
+		grpc.Fetch()
+		return grpc.Proxy()
+	case "jsonrpc":
+		jsonrpc := proxy.NewJsonRpc(cli)
+		jsonrpc.Duration = p.UpstreamCacheDuration
+		jsonrpc.Timeout = timeoutPolicy
+		jsonrpc.Hedge = p.hedgePolicy()
+		jsonrpc.Usage.Interval = p.UsageInterval
+		p.applyBreakerPolicy(jsonrpc.Breaker)
+		jsonrpc.Affinity.Mode = affinityMode
+		jsonrpc.Affinity.Header = p.AffinityHeader
+		jsonrpc.Heights.Interval = p.HeightInterval
+		jsonrpc.AdminAddr = p.AdminAddr
+		jsonrpc.AdminToken = p.AdminToken
+		jsonrpc.WsMaxConnections = p.WsMaxConnections
+		jsonrpc.WsMaxSubscriptions = p.WsMaxSubscriptions
+		if p.Addr != "" {
+			jsonrpc.Addr = p.Addr
+		}
+		jsonrpc.Fetch()
+		return jsonrpc.Proxy()
+	case "rest", "cometbft":
+		httpProxier := proxy.NewHttp(cli, client.Protocol(p.Protocol))
+		httpProxier.Duration = p.UpstreamCacheDuration
+		httpProxier.Timeout = timeoutPolicy
+		httpProxier.Usage.Interval = p.UsageInterval
+		p.applyBreakerPolicy(httpProxier.Breaker)
+		httpProxier.Affinity.Mode = affinityMode
+		httpProxier.Affinity.Header = p.AffinityHeader
+		httpProxier.AdminAddr = p.AdminAddr
+		httpProxier.AdminToken = p.AdminToken
+		if p.Addr != "" {
+			httpProxier.Addr = p.Addr
+		}
+		httpProxier.Fetch()
+		return httpProxier.Proxy()
+	default:
+		return fmt.Errorf("protocol %s not supported", p.Protocol)
+	}
+}
+
+func (p *Proxier) timeoutPolicy() (*proxy.TimeoutPolicy, error) {
+	var err error
+	tp := proxy.NewTimeoutPolicy()
+	tp.Default = p.Timeout
+	tp.Max = p.MaxTimeout
+	if tp.Chains, err = proxy.ParseTimeouts(p.ChainTimeouts); err != nil {
+		return nil, err
+	}
+	if tp.Methods, err = proxy.ParseTimeouts(p.MethodTimeouts); err != nil {
+		return nil, err
+	}
+	return tp, nil
+}
+
+func (p *Proxier) hedgePolicy() *proxy.HedgePolicy {
+	hp := proxy.NewHedgePolicy()
+	hp.Percentile = p.HedgePercentile
+	hp.MinDelay = p.HedgeMinDelay
+	hp.Budget = p.HedgeBudget
+	return hp
+}
+
+// applyBreakerPolicy sets the flags on the proxier's policy, which its node monitor already shares
+func (p *Proxier) applyBreakerPolicy(bp *proxy.BreakerPolicy) {
+	bp.ErrorRate = p.BreakerErrorRate
+	bp.SlowCallRate = p.BreakerSlowCallRate
+	bp.SlowCall = p.BreakerSlowCall
+	bp.Window = p.BreakerWindow
+	bp.MinCalls = p.BreakerMinCalls
+	bp.OpenDuration = p.BreakerOpenDuration
+	bp.HalfOpenCalls = p.BreakerHalfOpenCalls
 }
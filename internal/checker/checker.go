@@ -2,12 +2,17 @@ package checker
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,7 +26,9 @@ type CommonChecker struct {
 	Cli      *http.Client
 	// caches      checkCaches
 	CacheExpire time.Duration
-	mu          sync.RWMutex
+	// LoadProtoset reads a descriptor set uploaded to the protoset collection, used by gRPC checks
+	LoadProtoset func(name string) ([]byte, error)
+	mu           sync.RWMutex
 }
 
 func New(cli *http.Client, cacheExpire time.Duration) HealthChecker {
@@ -39,7 +46,32 @@ func (c *CommonChecker) ValidCondition(condition *HealthCheckCondition) error {
 	return nil
 }
 
-func (c *CommonChecker) Check(chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
+// Reports merges the measurements of every strategy used so far
+func (c *CommonChecker) Reports() map[string]NodeReport {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make(map[string]NodeReport)
+	for _, checker := range c.checkers {
+		reporter, ok := checker.(Reporter)
+		if !ok {
+			continue
+		}
+		for url, report := range reporter.Reports() {
+			merged := ret[url]
+			if report.LatencyP50 > 0 {
+				merged.LatencyP50 = report.LatencyP50
+				merged.LatencyP95 = report.LatencyP95
+			}
+			if report.Tags != nil {
+				merged.Tags = report.Tags
+			}
+			ret[url] = merged
+		}
+	}
+	return ret
+}
+
+func (c *CommonChecker) Check(ctx context.Context, chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
 	if len(urls) == 0 {
 		return make(map[string]bool, len(urls)), nil
 	}
@@ -69,10 +101,59 @@ func (c *CommonChecker) Check(chainId string, urls []string, condition *HealthCh
 			}
 		case CHECK_STRATEGY_MANUAL:
 			checker = &manualChecker{}
+		case CHECK_STRATEGY_CHAIN_IDENTITY:
+			checker = &chainIdentityChecker{
+				JsonRpcCaller: JsonRpcCaller{},
+				cli:           c.Cli,
+				cacheExpire:   c.CacheExpire,
+				grpcCall:      (&GrpcCaller{LoadProtoset: c.LoadProtoset}).Call,
+			}
+		case CHECK_STRATEGY_SYNC_STATE:
+			checker = &syncStateChecker{
+				JsonRpcCaller: JsonRpcCaller{},
+				cli:           c.Cli,
+			}
+		case CHECK_STRATEGY_PEER_COUNT:
+			checker = &peerCountChecker{
+				JsonRpcCaller: JsonRpcCaller{},
+				cli:           c.Cli,
+			}
+		case CHECK_STRATEGY_CAPABILITY:
+			checker = &capabilityChecker{
+				JsonRpcCaller: JsonRpcCaller{},
+				cli:           c.Cli,
+				reports:       make(map[string]NodeReport),
+			}
+		case CHECK_STRATEGY_LATENCY:
+			checker = &latencyChecker{
+				JsonRpcCaller: JsonRpcCaller{},
+				cli:           c.Cli,
+				reports:       make(map[string]NodeReport),
+			}
+		case CHECK_STRATEGY_CONSENSUS:
+			checker = &consensusChecker{
+				blockReader: blockReader{
+					JsonRpcCaller: JsonRpcCaller{},
+					cli:           c.Cli,
+					grpcCall:      (&GrpcCaller{LoadProtoset: c.LoadProtoset}).Call,
+				},
+			}
+		case CHECK_STRATEGY_FRESHNESS:
+			checker = &freshnessChecker{
+				blockReader: blockReader{
+					JsonRpcCaller: JsonRpcCaller{},
+					cli:           c.Cli,
+					grpcCall:      (&GrpcCaller{LoadProtoset: c.LoadProtoset}).Call,
+				},
+			}
+		case CHECK_STRATEGY_GRPC_VALUE_MATCH:
+			checker = &grpcValueMatchChecker{
+				grpcCall: (&GrpcCaller{LoadProtoset: c.LoadProtoset}).Call,
+			}
 		case CHECK_STRATEGY_GRPC_BLOCK_HEIGHT:
 			grpcChecker := &grpcBlockHeightChecker{
 				lastBlocks: make(map[string]int64),
-				GrpcCaller: GrpcCaller{},
+				GrpcCaller: GrpcCaller{LoadProtoset: c.LoadProtoset},
 			}
 			grpcChecker.blockHeightChecker = blockHeightChecker{
 				cacheExpire:   c.CacheExpire,
@@ -93,7 +174,7 @@ func (c *CommonChecker) Check(chainId string, urls []string, condition *HealthCh
 	if err := checker.ValidCondition(condition); err != nil {
 		return nil, err
 	}
-	return checker.Check(chainId, urls, condition, caches)
+	return checker.Check(ctx, chainId, urls, condition, caches)
 }
 
 type valueMatchChecker struct {
@@ -102,7 +183,7 @@ type valueMatchChecker struct {
 	cli *http.Client
 }
 
-func (c *valueMatchChecker) Check(_ string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
+func (c *valueMatchChecker) Check(ctx context.Context, _ string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
 	resultCh := make(chan checkResult, len(urls))
 	// defer close(resultCh)
 	for _, url := range urls {
@@ -111,7 +192,7 @@ func (c *valueMatchChecker) Check(_ string, urls []string, condition *HealthChec
 			continue
 		}
 		go func(u string) {
-			valid, err := c.check(u, condition, caches)
+			valid, err := c.check(ctx, u, condition, caches)
 			resultCh <- checkResult{url: u, valid: valid, err: err}
 		}(url)
 	}
@@ -128,9 +209,9 @@ func (c *valueMatchChecker) Check(_ string, urls []string, condition *HealthChec
 	return ret, nil
 }
 
-func (c *valueMatchChecker) check(url string, condition *HealthCheckCondition, caches CheckCaches) (bool, error) {
+func (c *valueMatchChecker) check(ctx context.Context, url string, condition *HealthCheckCondition, caches CheckCaches) (bool, error) {
 	checkResult := false
-	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(condition.Payload))
+	req, err := condition.newRequest(ctx, url)
 	if err != nil {
 		return checkResult, err
 	}
@@ -140,16 +221,16 @@ func (c *valueMatchChecker) check(url string, condition *HealthCheckCondition, c
 	}
 	value, ok := cache.Get()
 	if !ok {
-		value, err = c.Call(c.cli, req)
+		value, err = c.Call(c.cli, req, condition.StatusCodes...)
 		if err != nil {
-			// log.Printf("check url %s error: %s\n", url, err.Error())
+			condition.fail(url, "check url %s error: %s", url, err.Error())
 			return checkResult, nil
 		}
 		if value == nil || value["error"] != nil {
 			if value["error"] != nil {
 				errorInfo, ok := value["error"].(map[string]interface{})
 				if ok {
-					log.Printf("checkStrategy: %s, check url %s , code: %.0f, error: %s\n", condition.CheckStrategy, url, errorInfo["code"].(float64), errorInfo["message"].(string))
+					condition.fail(url, "check url %s , code: %v, error: %v", url, errorInfo["code"], errorInfo["message"])
 				}
 			}
 			return checkResult, nil
@@ -161,31 +242,29 @@ func (c *valueMatchChecker) check(url string, condition *HealthCheckCondition, c
 		log.Printf("hit cache for %s, checkStrategy: %s\n", req.URL.String(), condition.CheckStrategy)
 	}
 
-	for _, matcher := range condition.Matchers {
-		val, err := TextTemplate(fmt.Sprintf("{{.%s}}", matcher.Key)).Parse(value)
-		if err != nil {
-			return checkResult, err
-		}
-		checkResult = val == matcher.Value
-		if matcher.MatchType == "!=" {
-			checkResult = !checkResult
-		}
-		if !checkResult {
-			log.Printf("checkStrategy: %s, %s %s %s, result: %t for %s\n", condition.CheckStrategy, val, matcher.MatchType, matcher.Value, checkResult, url)
-			break
-		}
+	checkResult, details, err := matchValues(condition.Matchers, condition.Expr, value)
+	if err != nil {
+		return checkResult, err
+	}
+	if !checkResult {
+		condition.fail(url, "%s %s, result: %t for %s", strings.Join(details, ", "), condition.Expr, checkResult, url)
 	}
 	return checkResult, nil
 }
 
 func (c *valueMatchChecker) ValidCondition(condition *HealthCheckCondition) error {
-	condition.Matchers = lo.Filter(condition.Matchers, func(m Matcher, _ int) bool {
-		return m.MatchType == "=" || m.MatchType == "!="
+	matchers := lo.Filter(condition.Matchers, func(m Matcher, _ int) bool {
+		return lo.Contains(valueMatchTypes, m.MatchType) && m.valid() == nil
 	})
+	// an expression refers to matchers by index, so none can be dropped
+	if condition.Expr != "" && len(matchers) != len(condition.Matchers) {
+		return errors.New("invalid matchers")
+	}
+	condition.Matchers = matchers
 	if len(condition.Matchers) == 0 {
 		return errors.New("invalid or empty matchers")
 	}
-	return nil
+	return validExpr(condition.Expr, len(condition.Matchers))
 }
 
 type blockHeightChecker struct {
@@ -193,10 +272,10 @@ type blockHeightChecker struct {
 	cli         *http.Client
 	cacheExpire time.Duration
 	lastBlocks  map[string]int64
-	getHeightFn func(url string, condition *HealthCheckCondition, caches CheckCaches) (int64, error)
+	getHeightFn func(ctx context.Context, url string, condition *HealthCheckCondition, caches CheckCaches) (int64, error)
 }
 
-func (c *blockHeightChecker) Check(chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
+func (c *blockHeightChecker) Check(ctx context.Context, chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
 	ret := make(map[string]bool, len(urls))
 
 	heightMap := make(map[string]int64, len(urls))
@@ -214,7 +293,7 @@ func (c *blockHeightChecker) Check(chainId string, urls []string, condition *Hea
 			continue
 		}
 		go func(u string) {
-			h, e := c.getHeightFn(u, condition, caches)
+			h, e := c.getHeightFn(ctx, u, condition, caches)
 			heightCh <- heightResult{url: u, height: h, err: e}
 		}(url)
 	}
@@ -222,7 +301,7 @@ func (c *blockHeightChecker) Check(chainId string, urls []string, condition *Hea
 	for range urls {
 		r := <-heightCh
 		if r.err != nil {
-			log.Printf("checkStrategy: %s, check url %s error: %s\n", condition.CheckStrategy, r.url, r.err.Error())
+			condition.fail(r.url, "check url %s error: %s", r.url, r.err.Error())
 			return nil, r.err
 		}
 		switch r.height {
@@ -253,6 +332,7 @@ func (c *blockHeightChecker) Check(chainId string, urls []string, condition *Hea
 	}
 
 	for url, height := range heightMap {
+		condition.observe(url, height, 0)
 		checkResult := false
 		if matcher.MatchType == "<" && max-height < tolerance {
 			checkResult = true
@@ -262,14 +342,14 @@ func (c *blockHeightChecker) Check(chainId string, urls []string, condition *Hea
 		}
 		ret[url] = checkResult
 		if !checkResult {
-			log.Printf("checkStrategy: %s, %d - %d %s %d, result: %t for %s\n", condition.CheckStrategy, max, height, matcher.MatchType, tolerance, checkResult, url)
+			condition.fail(url, "%d - %d %s %d, result: %t for %s", max, height, matcher.MatchType, tolerance, checkResult, url)
 		}
 	}
 	return ret, nil
 }
 
 func (c *blockHeightChecker) ValidCondition(condition *HealthCheckCondition) error {
-	if condition.Payload == "" {
+	if condition.needsPayload() {
 		return errors.New("invalid or empty payload")
 	}
 
@@ -282,8 +362,8 @@ func (c *blockHeightChecker) ValidCondition(condition *HealthCheckCondition) err
 	return nil
 }
 
-func (c *blockHeightChecker) getHeight(url string, condition *HealthCheckCondition, caches CheckCaches) (int64, error) {
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader([]byte(condition.Payload)))
+func (c *blockHeightChecker) getHeight(ctx context.Context, url string, condition *HealthCheckCondition, caches CheckCaches) (int64, error) {
+	req, err := condition.newRequest(ctx, url)
 	if err != nil {
 		return -1, err
 	}
@@ -293,9 +373,9 @@ func (c *blockHeightChecker) getHeight(url string, condition *HealthCheckConditi
 	}
 	value, ok := cache.Get()
 	if !ok {
-		value, err = c.Call(c.cli, req)
+		value, err = c.Call(c.cli, req, condition.StatusCodes...)
 		if err != nil {
-			log.Printf("checkStrategy: %s, check url %s error: %s\n", condition.CheckStrategy, url, err.Error())
+			condition.fail(url, "check url %s error: %s", url, err.Error())
 			// ret[url] = false
 			// continue
 			return -1, nil
@@ -304,7 +384,7 @@ func (c *blockHeightChecker) getHeight(url string, condition *HealthCheckConditi
 			if value["error"] != nil {
 				errorInfo, ok := value["error"].(map[string]interface{})
 				if ok {
-					log.Printf("checkStrategy: %s, check url %s , code: %.0f, error: %s\n", condition.CheckStrategy, url, errorInfo["code"].(float64), errorInfo["message"].(string))
+					condition.fail(url, "check url %s , code: %v, error: %v", url, errorInfo["code"], errorInfo["message"])
 				}
 			}
 			return -1, nil
@@ -327,7 +407,7 @@ func (c *blockHeightChecker) getHeight(url string, condition *HealthCheckConditi
 	}
 	height, err := c.parseHeight(val)
 	if err != nil {
-		log.Printf("checkStrategy: %s, check url %s , error: %s\n", condition.CheckStrategy, url, err.Error())
+		condition.fail(url, "check url %s , error: %s", url, err.Error())
 		return -1, nil
 	}
 	return height, nil
@@ -348,12 +428,12 @@ type simpleChecker struct {
 	cacheExpire time.Duration
 }
 
-func (c *simpleChecker) check(url string, condition *HealthCheckCondition, caches CheckCaches) (bool, error) {
+func (c *simpleChecker) check(ctx context.Context, url string, condition *HealthCheckCondition, caches CheckCaches) (bool, error) {
 	if condition.ignore(url) {
 		return true, nil
 	}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader([]byte(condition.Payload)))
+	req, err := condition.newRequest(ctx, url)
 	if err != nil {
 		return false, err
 	}
@@ -363,16 +443,16 @@ func (c *simpleChecker) check(url string, condition *HealthCheckCondition, cache
 	}
 	_, ok := cache.Get()
 	if !ok {
-		value, err := c.Call(c.cli, req)
+		value, err := c.Call(c.cli, req, condition.StatusCodes...)
 		if err != nil {
-			log.Printf("checkStrategy: %s, check url %s error: %s\n", condition.CheckStrategy, url, err.Error())
+			condition.fail(url, "check url %s error: %s", url, err.Error())
 			return false, nil
 		}
 		if value == nil || value["error"] != nil {
 			if value["error"] != nil {
 				errorInfo, ok := value["error"].(map[string]interface{})
 				if ok {
-					log.Printf("checkStrategy: %s, check url %s , code: %.0f, error: %s\n", condition.CheckStrategy, url, errorInfo["code"].(float64), errorInfo["message"].(string))
+					condition.fail(url, "check url %s , code: %v, error: %v", url, errorInfo["code"], errorInfo["message"])
 				}
 			}
 			return false, nil
@@ -384,13 +464,13 @@ func (c *simpleChecker) check(url string, condition *HealthCheckCondition, cache
 	return true, nil
 }
 
-func (c *simpleChecker) Check(chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
+func (c *simpleChecker) Check(ctx context.Context, chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
 	ret := make(map[string]bool, len(urls))
 	resultCh := make(chan checkResult, len(urls))
 	// defer close(resultCh)
 	for _, url := range urls {
 		go func(u string) {
-			valid, err := c.check(u, condition, caches)
+			valid, err := c.check(ctx, u, condition, caches)
 			resultCh <- checkResult{url: u, valid: valid, err: err}
 		}(url)
 	}
@@ -405,7 +485,7 @@ func (c *simpleChecker) Check(chainId string, urls []string, condition *HealthCh
 }
 
 func (c *simpleChecker) ValidCondition(condition *HealthCheckCondition) error {
-	if condition.Payload == "" {
+	if condition.needsPayload() {
 		return errors.New("invalid or empty payload")
 	}
 	return nil
@@ -414,7 +494,7 @@ func (c *simpleChecker) ValidCondition(condition *HealthCheckCondition) error {
 type manualChecker struct {
 }
 
-func (c *manualChecker) Check(chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
+func (c *manualChecker) Check(ctx context.Context, chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
 	ret := make(map[string]bool, len(urls))
 	for _, url := range urls {
 		var checkResult bool
@@ -425,7 +505,7 @@ func (c *manualChecker) Check(chainId string, urls []string, condition *HealthCh
 				checkResult = !checkResult
 			}
 			if !checkResult {
-				log.Printf("checkStrategy: %s, url %s filter by manual, matchType: %s, value: %s\n", condition.CheckStrategy, url, matcher.MatchType, matcher.Value)
+				condition.fail(url, "url %s filter by manual, matchType: %s, value: %s", url, matcher.MatchType, matcher.Value)
 				break
 			}
 		}
@@ -451,30 +531,47 @@ type grpcBlockHeightChecker struct {
 }
 
 type ConditionGrpcPayload struct {
-	Protoset string `json:"protoset"`
-	Service  string `json:"service"`
-	Method   string `json:"method"`
+	// Protoset is the path of a descriptor set on the local filesystem
+	Protoset string `json:"protoset,omitempty"`
+	// ProtosetName refers to a descriptor set uploaded to the protoset collection
+	ProtosetName string `json:"protosetName,omitempty"`
+	// Reflection resolves the service by server reflection instead of a descriptor set
+	Reflection bool   `json:"reflection,omitempty"`
+	Service    string `json:"service"`
+	Method     string `json:"method"`
+	// Request is the JSON form of the request message, an empty message is sent without it
+	Request json.RawMessage `json:"request,omitempty"`
+	// Metadata is sent as gRPC metadata headers with the call
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (c *grpcBlockHeightChecker) ValidCondition(condition *HealthCheckCondition) error {
+	if err := c.blockHeightChecker.ValidCondition(condition); err != nil {
+		return err
+	}
+	var payload ConditionGrpcPayload
+	if err := json.Unmarshal([]byte(condition.Payload), &payload); err != nil {
+		return err
+	}
+	return payload.valid()
 }
 
-func (c *grpcBlockHeightChecker) getHeight(url string, condition *HealthCheckCondition, _ CheckCaches) (int64, error) {
+func (c *grpcBlockHeightChecker) getHeight(ctx context.Context, url string, condition *HealthCheckCondition, _ CheckCaches) (int64, error) {
 	var payload ConditionGrpcPayload
 	if err := json.Unmarshal([]byte(condition.Payload), &payload); err != nil {
 		return -1, err
 	}
-	values, err := c.Call(url, payload.Protoset, payload.Service, payload.Method)
+	values, err := c.Call(ctx, url, payload)
 	if err != nil {
-		log.Printf("checkStrategy: %s, check url %s error: %s\n", condition.CheckStrategy, url, err.Error())
+		condition.fail(url, "check url %s error: %s", url, err.Error())
 		// c.logger.Sugar().Infof("check url %s error: %s\n", url, err.Error())
 		// ret[url] = false
 		// continue
 		return -1, nil
 	}
 
-	matcher := condition.Matchers[0]
-	keys := strings.Split(matcher.Key, "|")
-	parse := fmt.Sprintf("{{.%s}}", keys[0])
-	var val string
-	if val, err = TextTemplate(parse).Parse(values); err != nil {
+	val, err := renderKey(condition.Matchers[0].Key, values)
+	if err != nil {
 		return -1, err
 	}
 	if val == "" || val == "<no value>" {
@@ -482,21 +579,1159 @@ func (c *grpcBlockHeightChecker) getHeight(url string, condition *HealthCheckCon
 		// continue
 		return -1, nil
 	}
-	if len(keys) > 1 {
-		re := regexp.MustCompile(keys[1])
-		match := re.FindStringSubmatch(val)
-		if len(match) == 0 {
-			// ret[url] = false
-			// continue
-			return -1, nil
-		}
-		val = match[1]
-	}
 
 	height, err := c.parseHeight(val)
 	if err != nil {
-		log.Printf("checkStrategy: %s, check url %s , error: %s\n", condition.CheckStrategy, url, err.Error())
+		condition.fail(url, "check url %s , error: %s", url, err.Error())
 		return -1, nil
 	}
 	return height, nil
 }
+
+type grpcValueMatchChecker struct {
+	grpcCall func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error)
+}
+
+// Check calls the payload's method on every node and evaluates the matchers against the jsonpb rendered response
+func (c *grpcValueMatchChecker) Check(ctx context.Context, _ string, urls []string, condition *HealthCheckCondition, _ CheckCaches) (map[string]bool, error) {
+	var payload ConditionGrpcPayload
+	if err := json.Unmarshal([]byte(condition.Payload), &payload); err != nil {
+		return nil, err
+	}
+	return checkUrls(urls, condition, func(url string) (bool, error) {
+		values, err := c.grpcCall(ctx, url, payload)
+		if err != nil {
+			return false, err
+		}
+		ok, details, err := matchValues(condition.Matchers, condition.Expr, values)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			condition.fail(url, "%s %s, result: %t for %s", strings.Join(details, ", "), condition.Expr, ok, url)
+		}
+		return ok, nil
+	}), nil
+}
+
+func (c *grpcValueMatchChecker) ValidCondition(condition *HealthCheckCondition) error {
+	if condition.Payload == "" {
+		return errors.New("invalid or empty payload")
+	}
+	var payload ConditionGrpcPayload
+	if err := json.Unmarshal([]byte(condition.Payload), &payload); err != nil {
+		return err
+	}
+	if err := payload.valid(); err != nil {
+		return err
+	}
+	return (&valueMatchChecker{}).ValidCondition(condition)
+}
+
+const defaultLatencySamples = 5
+
+type latencyChecker struct {
+	JsonRpcCaller
+	cli     *http.Client
+	mu      sync.Mutex
+	reports map[string]NodeReport
+}
+
+// Check sends the payload to every node several times and compares p50 or p95 of the response times,
+// either to an absolute duration such as 800ms or to a multiple of the pool median such as 3x
+func (c *latencyChecker) Check(ctx context.Context, chainId string, urls []string, condition *HealthCheckCondition, _ CheckCaches) (map[string]bool, error) {
+	ret := make(map[string]bool, len(urls))
+	samples := condition.Samples
+	if samples <= 0 {
+		samples = defaultLatencySamples
+	}
+
+	resultCh := make(chan latencyResult, len(urls))
+	for _, url := range urls {
+		if condition.ignore(url) {
+			resultCh <- latencyResult{url: url, ignored: true}
+			continue
+		}
+		go func(u string) {
+			resultCh <- c.measure(ctx, u, condition, samples)
+		}(url)
+	}
+
+	measured := make(map[string]latencyResult, len(urls))
+	for range urls {
+		r := <-resultCh
+		if r.err != nil {
+			condition.fail(r.url, "check url %s error: %s", r.url, r.err.Error())
+			return nil, r.err
+		}
+		switch {
+		case r.ignored:
+			ret[r.url] = true
+		case r.failed:
+			ret[r.url] = false
+		default:
+			measured[r.url] = r
+		}
+	}
+
+	c.mu.Lock()
+	for url, r := range measured {
+		c.reports[url] = NodeReport{LatencyP50: r.p50, LatencyP95: r.p95}
+		condition.observe(url, 0, r.p95)
+	}
+	c.mu.Unlock()
+
+	medians := map[string]time.Duration{
+		"p50": medianDuration(lo.MapToSlice(measured, func(_ string, r latencyResult) time.Duration { return r.p50 })),
+		"p95": medianDuration(lo.MapToSlice(measured, func(_ string, r latencyResult) time.Duration { return r.p95 })),
+	}
+	for url, r := range measured {
+		checkResult := true
+		for _, matcher := range condition.Matchers {
+			key := latencyKey(matcher)
+			observed := r.p95
+			if key == "p50" {
+				observed = r.p50
+			}
+			limit, err := latencyLimit(matcher.Value, medians[key])
+			if err != nil {
+				return nil, err
+			}
+			checkResult = observed < limit || (matcher.MatchType == "<=" && observed == limit)
+			if !checkResult {
+				condition.fail(url, "%s %s %s %s, result: %t for %s", key, observed, matcher.MatchType, limit, checkResult, url)
+				break
+			}
+		}
+		ret[url] = checkResult
+	}
+	return ret, nil
+}
+
+func (c *latencyChecker) measure(ctx context.Context, url string, condition *HealthCheckCondition, samples int) latencyResult {
+	durations := make([]time.Duration, 0, samples)
+	for range samples {
+		req, err := condition.newRequest(ctx, url)
+		if err != nil {
+			return latencyResult{url: url, err: err}
+		}
+		start := time.Now()
+		value, err := c.Call(c.cli, req, condition.StatusCodes...)
+		if err != nil {
+			condition.fail(url, "check url %s error: %s", url, err.Error())
+			return latencyResult{url: url, failed: true}
+		}
+		if value["error"] != nil {
+			condition.fail(url, "check url %s error: %v", url, value["error"])
+			return latencyResult{url: url, failed: true}
+		}
+		durations = append(durations, time.Since(start))
+	}
+	return latencyResult{url: url, p50: percentileDuration(durations, 0.5), p95: percentileDuration(durations, 0.95)}
+}
+
+func (c *latencyChecker) ValidCondition(condition *HealthCheckCondition) error {
+	if condition.needsPayload() {
+		return errors.New("invalid or empty payload")
+	}
+	condition.Matchers = lo.Filter(condition.Matchers, func(m Matcher, _ int) bool {
+		if m.MatchType != "<" && m.MatchType != "<=" {
+			return false
+		}
+		if key := latencyKey(m); key != "p50" && key != "p95" {
+			return false
+		}
+		_, err := latencyLimit(m.Value, time.Second)
+		return err == nil
+	})
+	if len(condition.Matchers) == 0 {
+		return errors.New("invalid or empty matchers")
+	}
+	return nil
+}
+
+func (c *latencyChecker) Reports() map[string]NodeReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make(map[string]NodeReport, len(c.reports))
+	for url, report := range c.reports {
+		ret[url] = report
+	}
+	return ret
+}
+
+// latencyKey is the percentile a matcher compares, p95 when the key is empty
+func latencyKey(m Matcher) string {
+	if m.Key == "" {
+		return "p95"
+	}
+	return m.Key
+}
+
+// latencyLimit parses a duration such as 800ms, or a multiple of the pool median such as 3x
+func latencyLimit(value string, median time.Duration) (time.Duration, error) {
+	if factor, ok := strings.CutSuffix(value, "x"); ok {
+		f, err := strconv.ParseFloat(factor, 64)
+		if err != nil || f <= 0 {
+			return 0, fmt.Errorf("invalid latency multiple: %s", value)
+		}
+		return time.Duration(f * float64(median)), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid latency: %s", value)
+	}
+	return d, nil
+}
+
+// percentileDuration returns the nearest-rank percentile of durations
+func percentileDuration(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(idx, 0)]
+}
+
+func medianDuration(durations []time.Duration) time.Duration {
+	return percentileDuration(durations, 0.5)
+}
+
+type chainIdentityChecker struct {
+	JsonRpcCaller
+	cli         *http.Client
+	cacheExpire time.Duration
+	grpcCall    func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error)
+}
+
+// Check excludes nodes that report another chain than the rule's chain id, nodes that can't be asked fail as well
+func (c *chainIdentityChecker) Check(ctx context.Context, chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
+	resultCh := make(chan checkResult, len(urls))
+	for _, url := range urls {
+		if condition.ignore(url) {
+			resultCh <- checkResult{url: url, valid: true}
+			continue
+		}
+		go func(u string) {
+			reported, err := c.identify(ctx, u, condition, caches)
+			if err != nil {
+				condition.fail(u, "check url %s error: %s", u, err.Error())
+				resultCh <- checkResult{url: u, valid: false}
+				return
+			}
+			valid := sameChain(condition.ChainType, reported, chainId)
+			if !valid {
+				condition.fail(u, "CHAIN MISMATCH: url %s reports chain %s, expected %s", u, reported, chainId)
+			}
+			resultCh <- checkResult{url: u, valid: valid}
+		}(url)
+	}
+
+	ret := make(map[string]bool, len(urls))
+	for range urls {
+		r := <-resultCh
+		ret[r.url] = r.valid
+	}
+	return ret, nil
+}
+
+// identify asks a node which chain it serves
+func (c *chainIdentityChecker) identify(ctx context.Context, url string, condition *HealthCheckCondition, caches CheckCaches) (string, error) {
+	switch condition.ChainType {
+	case CHAIN_TYPE_TRON:
+		return c.tronChainId(ctx, url, condition)
+	case CHAIN_TYPE_COMETBFT:
+		return c.network(ctx, url, "/status", "result.node_info.network", caches)
+	case CHAIN_TYPE_COSMOS:
+		return c.network(ctx, url, "/cosmos/base/tendermint/v1beta1/node_info", "default_node_info.network", caches)
+	}
+	chain, err := c.evmChainId(ctx, url, "eth_chainId", caches)
+	if err != nil || chain == "" {
+		return c.evmChainId(ctx, url, "net_version", caches)
+	}
+	return chain, nil
+}
+
+func (c *chainIdentityChecker) evmChainId(ctx context.Context, url, method string, caches CheckCaches) (string, error) {
+	payload := fmt.Sprintf(`{"jsonrpc":"2.0","method":"%s","params":[],"id":1}`, method)
+	value, err := c.fetch(ctx, url, &HealthCheckCondition{Payload: payload}, caches)
+	if err != nil {
+		return "", err
+	}
+	result, ok := value["result"].(string)
+	if !ok {
+		return "", fmt.Errorf("%s returned no chain id", method)
+	}
+	return result, nil
+}
+
+func (c *chainIdentityChecker) network(ctx context.Context, url, path, key string, caches CheckCaches) (string, error) {
+	value, err := c.fetch(ctx, url, &HealthCheckCondition{Method: http.MethodGet, Path: path}, caches)
+	if err != nil {
+		return "", err
+	}
+	network, err := TextTemplate(fmt.Sprintf("{{.%s}}", key)).Parse(value)
+	if err != nil {
+		return "", err
+	}
+	if network == "" || network == "<no value>" {
+		return "", fmt.Errorf("%s returned no network", path)
+	}
+	return network, nil
+}
+
+// fetch calls the node unless the same request is cached
+func (c *chainIdentityChecker) fetch(ctx context.Context, url string, condition *HealthCheckCondition, caches CheckCaches) (checkCacheValue, error) {
+	req, err := condition.newRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := caches.match(req)
+	if err != nil {
+		return nil, err
+	}
+	if value, ok := cache.Get(); ok {
+		return value, nil
+	}
+	value, err := c.Call(c.cli, req, condition.StatusCodes...)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil || value["error"] != nil {
+		return nil, fmt.Errorf("error response: %v", value["error"])
+	}
+	return value, caches.put(req, value, c.cacheExpire)
+}
+
+// tronChainId reads the genesis block, the chain id of a tron network is the last 4 bytes of its block id
+func (c *chainIdentityChecker) tronChainId(ctx context.Context, url string, condition *HealthCheckCondition) (string, error) {
+	var payload ConditionGrpcPayload
+	if err := json.Unmarshal([]byte(condition.Payload), &payload); err != nil {
+		return "", err
+	}
+	if payload.Service == "" {
+		payload.Service = "Wallet"
+	}
+	if payload.Method == "" {
+		// an empty NumberMessage asks for block 0
+		payload.Method = "GetBlockByNum2"
+	}
+	values, err := c.grpcCall(ctx, url, payload)
+	if err != nil {
+		return "", err
+	}
+	blockId, ok := values["blockid"].(string)
+	if !ok {
+		return "", errors.New("genesis block has no blockid")
+	}
+	id, err := base64.StdEncoding.DecodeString(blockId)
+	if err != nil {
+		return "", err
+	}
+	if len(id) < 4 {
+		return "", fmt.Errorf("invalid blockid %s", blockId)
+	}
+	return strconv.FormatUint(uint64(binary.BigEndian.Uint32(id[len(id)-4:])), 10), nil
+}
+
+func (c *chainIdentityChecker) ValidCondition(condition *HealthCheckCondition) error {
+	switch condition.ChainType {
+	case "", CHAIN_TYPE_EVM, CHAIN_TYPE_COMETBFT, CHAIN_TYPE_COSMOS:
+		return nil
+	case CHAIN_TYPE_TRON:
+		if condition.Payload == "" {
+			return errors.New("invalid or empty payload")
+		}
+		return nil
+	}
+	return fmt.Errorf("chain type %s not supported", condition.ChainType)
+}
+
+// sameChain compares a reported chain with the expected one, evm chain ids may be hex or decimal
+func sameChain(chainType, reported, expected string) bool {
+	if reported == expected {
+		return true
+	}
+	if chainType != "" && chainType != CHAIN_TYPE_EVM {
+		return false
+	}
+	r, err := parseNumber(reported)
+	if err != nil {
+		return false
+	}
+	e, err := parseNumber(expected)
+	if err != nil {
+		return false
+	}
+	return r.Cmp(e) == 0
+}
+
+// checkUrls runs check on every url that is not ignored, a node that can't be asked fails
+func checkUrls(urls []string, condition *HealthCheckCondition, check func(url string) (bool, error)) map[string]bool {
+	resultCh := make(chan checkResult, len(urls))
+	for _, url := range urls {
+		if condition.ignore(url) {
+			resultCh <- checkResult{url: url, valid: true}
+			continue
+		}
+		go func(u string) {
+			valid, err := check(u)
+			if err != nil {
+				condition.fail(u, "check url %s error: %s", u, err.Error())
+			}
+			resultCh <- checkResult{url: u, valid: valid && err == nil}
+		}(url)
+	}
+	ret := make(map[string]bool, len(urls))
+	for range urls {
+		r := <-resultCh
+		ret[r.url] = r.valid
+	}
+	return ret
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("code: %d, error: %s", e.Code, e.Message)
+}
+
+type syncStateChecker struct {
+	JsonRpcCaller
+	cli *http.Client
+}
+
+// Check fails nodes that are still syncing: eth_syncing not false, catching_up on cosmos or an unhealthy solana node
+func (c *syncStateChecker) Check(ctx context.Context, _ string, urls []string, condition *HealthCheckCondition, _ CheckCaches) (map[string]bool, error) {
+	return checkUrls(urls, condition, func(url string) (bool, error) {
+		switch condition.ChainType {
+		case CHAIN_TYPE_COMETBFT:
+			return c.cometbft(ctx, url, condition)
+		case CHAIN_TYPE_COSMOS:
+			return c.cosmos(ctx, url, condition)
+		case CHAIN_TYPE_SOLANA:
+			return c.solana(ctx, url, condition)
+		}
+		return c.evm(ctx, url, condition)
+	}), nil
+}
+
+func (c *syncStateChecker) evm(ctx context.Context, url string, condition *HealthCheckCondition) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(`{"jsonrpc":"2.0","method":"eth_syncing","params":[],"id":1}`))
+	if err != nil {
+		return false, err
+	}
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err = c.CallInto(c.cli, req, &resp); err != nil {
+		return false, err
+	}
+	if resp.Error != nil {
+		return false, resp.Error
+	}
+	var syncing bool
+	if err = json.Unmarshal(resp.Result, &syncing); err == nil && !syncing {
+		return true, nil
+	}
+	var progress struct {
+		CurrentBlock string `json:"currentBlock"`
+		HighestBlock string `json:"highestBlock"`
+	}
+	_ = json.Unmarshal(resp.Result, &progress)
+	condition.fail(url, "url %s is syncing, current block: %s, highest block: %s", url, progress.CurrentBlock, progress.HighestBlock)
+	return false, nil
+}
+
+func (c *syncStateChecker) cometbft(ctx context.Context, url string, condition *HealthCheckCondition) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(url, "/")+"/status", nil)
+	if err != nil {
+		return false, err
+	}
+	var resp struct {
+		Result *struct {
+			SyncInfo struct {
+				LatestBlockHeight string `json:"latest_block_height"`
+				CatchingUp        bool   `json:"catching_up"`
+			} `json:"sync_info"`
+		} `json:"result"`
+		Error *rpcError `json:"error"`
+	}
+	if err = c.CallInto(c.cli, req, &resp); err != nil {
+		return false, err
+	}
+	if resp.Error != nil {
+		return false, resp.Error
+	}
+	if resp.Result == nil {
+		return false, errors.New("status returned no result")
+	}
+	if resp.Result.SyncInfo.CatchingUp {
+		condition.fail(url, "url %s is catching up, latest block: %s", url, resp.Result.SyncInfo.LatestBlockHeight)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (c *syncStateChecker) cosmos(ctx context.Context, url string, condition *HealthCheckCondition) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(url, "/")+"/cosmos/base/tendermint/v1beta1/syncing", nil)
+	if err != nil {
+		return false, err
+	}
+	var resp struct {
+		Syncing *bool `json:"syncing"`
+	}
+	if err = c.CallInto(c.cli, req, &resp); err != nil {
+		return false, err
+	}
+	if resp.Syncing == nil {
+		return false, errors.New("syncing returned no state")
+	}
+	if *resp.Syncing {
+		condition.fail(url, "url %s is syncing", url)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (c *syncStateChecker) solana(ctx context.Context, url string, condition *HealthCheckCondition) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(`{"jsonrpc":"2.0","method":"getHealth","id":1}`))
+	if err != nil {
+		return false, err
+	}
+	var resp struct {
+		Result string    `json:"result"`
+		Error  *rpcError `json:"error"`
+	}
+	if err = c.CallInto(c.cli, req, &resp); err != nil {
+		return false, err
+	}
+	if resp.Error != nil {
+		condition.fail(url, "url %s is unhealthy, %s", url, resp.Error.Error())
+		return false, nil
+	}
+	if resp.Result != "ok" {
+		condition.fail(url, "url %s is unhealthy, result: %s", url, resp.Result)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (c *syncStateChecker) ValidCondition(condition *HealthCheckCondition) error {
+	switch condition.ChainType {
+	case "", CHAIN_TYPE_EVM, CHAIN_TYPE_COMETBFT, CHAIN_TYPE_COSMOS, CHAIN_TYPE_SOLANA:
+		return nil
+	}
+	return fmt.Errorf("chain type %s not supported", condition.ChainType)
+}
+
+type peerCountChecker struct {
+	JsonRpcCaller
+	cli *http.Client
+}
+
+// Check fails nodes with fewer peers than MinPeers, from net_peerCount on evm or net_info on cometbft.
+// The cosmos LCD has no peer count, cosmos nodes are asked net_info like cometbft ones
+func (c *peerCountChecker) Check(ctx context.Context, _ string, urls []string, condition *HealthCheckCondition, _ CheckCaches) (map[string]bool, error) {
+	return checkUrls(urls, condition, func(url string) (bool, error) {
+		var peers int64
+		var err error
+		if condition.ChainType == CHAIN_TYPE_COMETBFT || condition.ChainType == CHAIN_TYPE_COSMOS {
+			peers, err = c.cometbft(ctx, url)
+		} else {
+			peers, err = c.evm(ctx, url)
+		}
+		if err != nil {
+			return false, err
+		}
+		if peers < int64(condition.MinPeers) {
+			condition.fail(url, "url %s has %d peers, less than %d", url, peers, condition.MinPeers)
+			return false, nil
+		}
+		return true, nil
+	}), nil
+}
+
+func (c *peerCountChecker) evm(ctx context.Context, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(`{"jsonrpc":"2.0","method":"net_peerCount","params":[],"id":1}`))
+	if err != nil {
+		return 0, err
+	}
+	var resp struct {
+		Result string    `json:"result"`
+		Error  *rpcError `json:"error"`
+	}
+	if err = c.CallInto(c.cli, req, &resp); err != nil {
+		return 0, err
+	}
+	if resp.Error != nil {
+		return 0, resp.Error
+	}
+	peers, err := parseNumber(resp.Result)
+	if err != nil {
+		return 0, err
+	}
+	count, _ := peers.Int64()
+	return count, nil
+}
+
+func (c *peerCountChecker) cometbft(ctx context.Context, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(url, "/")+"/net_info", nil)
+	if err != nil {
+		return 0, err
+	}
+	var resp struct {
+		Result *struct {
+			NPeers string `json:"n_peers"`
+		} `json:"result"`
+		Error *rpcError `json:"error"`
+	}
+	if err = c.CallInto(c.cli, req, &resp); err != nil {
+		return 0, err
+	}
+	if resp.Error != nil {
+		return 0, resp.Error
+	}
+	if resp.Result == nil {
+		return 0, errors.New("net_info returned no result")
+	}
+	return strconv.ParseInt(resp.Result.NPeers, 10, 64)
+}
+
+func (c *peerCountChecker) ValidCondition(condition *HealthCheckCondition) error {
+	switch condition.ChainType {
+	case "", CHAIN_TYPE_EVM, CHAIN_TYPE_COMETBFT, CHAIN_TYPE_COSMOS:
+	default:
+		return fmt.Errorf("chain type %s not supported", condition.ChainType)
+	}
+	if condition.MinPeers <= 0 {
+		return errors.New("invalid or empty minPeers")
+	}
+	return nil
+}
+
+var (
+	// archiveDepths are the state depths probed on nodes that can't serve state at block 1
+	archiveDepths = []int64{100000, 10000, 1000, 128}
+	// logsRanges are the eth_getLogs block ranges probed, largest first
+	logsRanges  = []int64{10000, 2000, 500, 100}
+	zeroAddress = "0x0000000000000000000000000000000000000000"
+	zeroHash    = "0x0000000000000000000000000000000000000000000000000000000000000000"
+)
+
+type capabilityChecker struct {
+	JsonRpcCaller
+	cli     *http.Client
+	mu      sync.Mutex
+	reports map[string]NodeReport
+}
+
+// Check probes what every node can serve and reports it as tags, only nodes that can't tell their head fail
+func (c *capabilityChecker) Check(ctx context.Context, _ string, urls []string, condition *HealthCheckCondition, _ CheckCaches) (map[string]bool, error) {
+	return checkUrls(urls, condition, func(url string) (bool, error) {
+		tags, err := c.probe(ctx, url, condition)
+		if err != nil {
+			return false, err
+		}
+		c.mu.Lock()
+		c.reports[url] = NodeReport{Tags: tags}
+		c.mu.Unlock()
+		log.Printf("checkStrategy: %s, url %s capabilities: %s\n", condition.CheckStrategy, url, strings.Join(tags, ","))
+		return true, nil
+	}), nil
+}
+
+func (c *capabilityChecker) probe(ctx context.Context, url string, condition *HealthCheckCondition) ([]string, error) {
+	var head string
+	if err := c.call(ctx, url, "eth_blockNumber", []any{}, &head); err != nil {
+		return nil, err
+	}
+	headBlock, err := parseNumber(head)
+	if err != nil {
+		return nil, err
+	}
+	height, _ := headBlock.Int64()
+
+	tags := []string{}
+	probes := condition.Capabilities
+	if len(probes) == 0 {
+		probes = []string{CAPABILITY_ARCHIVE, CAPABILITY_TRACE, CAPABILITY_DEBUG, CAPABILITY_LOGS, CAPABILITY_BATCH}
+	}
+	for _, probe := range probes {
+		switch probe {
+		case CAPABILITY_ARCHIVE:
+			tags = append(tags, c.archive(ctx, url, height)...)
+		case CAPABILITY_TRACE:
+			if c.available(ctx, url, "trace_transaction", []any{zeroHash}) {
+				tags = append(tags, CAPABILITY_TRACE)
+			}
+		case CAPABILITY_DEBUG:
+			if c.available(ctx, url, "debug_traceTransaction", []any{zeroHash}) {
+				tags = append(tags, CAPABILITY_DEBUG)
+			}
+		case CAPABILITY_LOGS:
+			for _, r := range logsRanges {
+				filter := map[string]string{
+					"address":   zeroAddress,
+					"fromBlock": fmt.Sprintf("0x%x", max(height-r+1, 0)),
+					"toBlock":   fmt.Sprintf("0x%x", height),
+				}
+				var logs []json.RawMessage
+				if err := c.call(ctx, url, "eth_getLogs", []any{filter}, &logs); err == nil {
+					tags = append(tags, fmt.Sprintf("%s:%d", CAPABILITY_LOGS, r))
+					break
+				}
+			}
+		case CAPABILITY_BATCH:
+			if c.batch(ctx, url) {
+				tags = append(tags, CAPABILITY_BATCH)
+			}
+		}
+	}
+	return tags, nil
+}
+
+// archive reports archive when the state of block 1 is served, otherwise the deepest state depth that is
+func (c *capabilityChecker) archive(ctx context.Context, url string, height int64) []string {
+	var balance string
+	if err := c.call(ctx, url, "eth_getBalance", []any{zeroAddress, "0x1"}, &balance); err == nil {
+		return []string{CAPABILITY_ARCHIVE}
+	}
+	for _, depth := range archiveDepths {
+		if depth >= height {
+			continue
+		}
+		if err := c.call(ctx, url, "eth_getBalance", []any{zeroAddress, fmt.Sprintf("0x%x", height-depth)}, &balance); err == nil {
+			return []string{fmt.Sprintf("%s:%d", CAPABILITY_STATE, depth)}
+		}
+	}
+	return nil
+}
+
+// available reports whether a node knows a method, any answer but method not found counts
+func (c *capabilityChecker) available(ctx context.Context, url, method string, params []any) bool {
+	var result json.RawMessage
+	err := c.call(ctx, url, method, params, &result)
+	var rpcErr *rpcError
+	if errors.As(err, &rpcErr) {
+		return !methodNotFound(rpcErr)
+	}
+	return err == nil
+}
+
+func (c *capabilityChecker) batch(ctx context.Context, url string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(`[{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1},{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":2}]`))
+	if err != nil {
+		return false
+	}
+	var resp []struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err = c.CallInto(c.cli, req, &resp); err != nil || len(resp) != 2 {
+		return false
+	}
+	return resp[0].Error == nil && resp[1].Error == nil
+}
+
+// call sends a jsonrpc request and decodes its result into out
+func (c *capabilityChecker) call(ctx context.Context, url, method string, params []any, out any) error {
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": method, "params": params, "id": 1})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err = c.CallInto(c.cli, req, &resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	return json.Unmarshal(resp.Result, out)
+}
+
+func (c *capabilityChecker) ValidCondition(condition *HealthCheckCondition) error {
+	for _, probe := range condition.Capabilities {
+		switch probe {
+		case CAPABILITY_ARCHIVE, CAPABILITY_TRACE, CAPABILITY_DEBUG, CAPABILITY_LOGS, CAPABILITY_BATCH:
+		default:
+			return fmt.Errorf("capability %s not supported", probe)
+		}
+	}
+	return nil
+}
+
+func (c *capabilityChecker) Reports() map[string]NodeReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make(map[string]NodeReport, len(c.reports))
+	for url, report := range c.reports {
+		ret[url] = report
+	}
+	return ret
+}
+
+// methodNotFound tells a missing method from other errors such as an unknown transaction
+func methodNotFound(err *rpcError) bool {
+	if err.Code == -32601 {
+		return true
+	}
+	message := strings.ToLower(err.Message)
+	for _, s := range []string{"method not found", "does not exist", "not available", "not supported", "unsupported method"} {
+		if strings.Contains(message, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockInfo is what the Consensus and Freshness strategies read of a block
+type blockInfo struct {
+	height int64
+	hash   string
+	time   time.Time
+}
+
+type blockResult struct {
+	url   string
+	block blockInfo
+	err   error
+}
+
+// blockReader reads a block of any chain type, the latest one when the height is 0
+type blockReader struct {
+	JsonRpcCaller
+	cli      *http.Client
+	grpcCall func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error)
+}
+
+func (r *blockReader) block(ctx context.Context, url string, condition *HealthCheckCondition, height int64) (blockInfo, error) {
+	switch condition.ChainType {
+	case CHAIN_TYPE_COMETBFT:
+		return r.cometbft(ctx, url, height)
+	case CHAIN_TYPE_COSMOS:
+		return r.cosmos(ctx, url, height)
+	case CHAIN_TYPE_TRON:
+		return r.tron(ctx, url, condition, height)
+	}
+	return r.evm(ctx, url, height)
+}
+
+// blocks reads the block at height from every url at once
+func (r *blockReader) blocks(ctx context.Context, urls []string, condition *HealthCheckCondition, height int64) map[string]blockResult {
+	resultCh := make(chan blockResult, len(urls))
+	for _, url := range urls {
+		go func(u string) {
+			block, err := r.block(ctx, u, condition, height)
+			resultCh <- blockResult{url: u, block: block, err: err}
+		}(url)
+	}
+	ret := make(map[string]blockResult, len(urls))
+	for range urls {
+		res := <-resultCh
+		ret[res.url] = res
+	}
+	return ret
+}
+
+func (r *blockReader) evm(ctx context.Context, url string, height int64) (blockInfo, error) {
+	tag := "latest"
+	if height > 0 {
+		tag = fmt.Sprintf("0x%x", height)
+	}
+	payload := fmt.Sprintf(`{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["%s",false],"id":1}`, tag)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(payload))
+	if err != nil {
+		return blockInfo{}, err
+	}
+	var resp struct {
+		Result *struct {
+			Number    string `json:"number"`
+			Hash      string `json:"hash"`
+			Timestamp string `json:"timestamp"`
+		} `json:"result"`
+		Error *rpcError `json:"error"`
+	}
+	if err = r.CallInto(r.cli, req, &resp); err != nil {
+		return blockInfo{}, err
+	}
+	if resp.Error != nil {
+		return blockInfo{}, resp.Error
+	}
+	if resp.Result == nil {
+		return blockInfo{}, fmt.Errorf("block %s not found", tag)
+	}
+	number, err := strconv.ParseInt(strings.TrimPrefix(resp.Result.Number, "0x"), 16, 64)
+	if err != nil {
+		return blockInfo{}, err
+	}
+	timestamp, err := strconv.ParseInt(strings.TrimPrefix(resp.Result.Timestamp, "0x"), 16, 64)
+	if err != nil {
+		return blockInfo{}, err
+	}
+	return blockInfo{height: number, hash: resp.Result.Hash, time: time.Unix(timestamp, 0)}, nil
+}
+
+// cometBlock is a block as cometbft /block and the cosmos LCD blocks endpoints return it
+type cometBlock struct {
+	BlockId struct {
+		Hash string `json:"hash"`
+	} `json:"block_id"`
+	Block struct {
+		Header struct {
+			Height string    `json:"height"`
+			Time   time.Time `json:"time"`
+		} `json:"header"`
+	} `json:"block"`
+}
+
+func (b *cometBlock) info() (blockInfo, error) {
+	height, err := strconv.ParseInt(b.Block.Header.Height, 10, 64)
+	if err != nil {
+		return blockInfo{}, fmt.Errorf("invalid block height %q", b.Block.Header.Height)
+	}
+	return blockInfo{height: height, hash: b.BlockId.Hash, time: b.Block.Header.Time}, nil
+}
+
+func (r *blockReader) cometbft(ctx context.Context, url string, height int64) (blockInfo, error) {
+	path := "/block"
+	if height > 0 {
+		path = fmt.Sprintf("/block?height=%d", height)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(url, "/")+path, nil)
+	if err != nil {
+		return blockInfo{}, err
+	}
+	var resp struct {
+		Result *cometBlock `json:"result"`
+		Error  *rpcError   `json:"error"`
+	}
+	if err = r.CallInto(r.cli, req, &resp); err != nil {
+		return blockInfo{}, err
+	}
+	if resp.Error != nil {
+		return blockInfo{}, resp.Error
+	}
+	if resp.Result == nil {
+		return blockInfo{}, errors.New("block returned no result")
+	}
+	return resp.Result.info()
+}
+
+func (r *blockReader) cosmos(ctx context.Context, url string, height int64) (blockInfo, error) {
+	path := "/cosmos/base/tendermint/v1beta1/blocks/latest"
+	if height > 0 {
+		path = fmt.Sprintf("/cosmos/base/tendermint/v1beta1/blocks/%d", height)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(url, "/")+path, nil)
+	if err != nil {
+		return blockInfo{}, err
+	}
+	var resp cometBlock
+	if err = r.CallInto(r.cli, req, &resp); err != nil {
+		return blockInfo{}, err
+	}
+	return resp.info()
+}
+
+// tron reads blocks over gRPC, GetNowBlock2 for the latest block and GetBlockByNum2 for a height
+func (r *blockReader) tron(ctx context.Context, url string, condition *HealthCheckCondition, height int64) (blockInfo, error) {
+	var payload ConditionGrpcPayload
+	if err := json.Unmarshal([]byte(condition.Payload), &payload); err != nil {
+		return blockInfo{}, err
+	}
+	if payload.Service == "" {
+		payload.Service = "Wallet"
+	}
+	payload.Method, payload.Request = "GetNowBlock2", nil
+	if height > 0 {
+		payload.Method = "GetBlockByNum2"
+		payload.Request = json.RawMessage(fmt.Sprintf(`{"num":%d}`, height))
+	}
+	values, err := r.grpcCall(ctx, url, payload)
+	if err != nil {
+		return blockInfo{}, err
+	}
+	hash, _ := values["blockid"].(string)
+	if hash == "" {
+		return blockInfo{}, fmt.Errorf("%s returned no block", payload.Method)
+	}
+	var block blockInfo
+	block.hash = hash
+	number, _ := renderKey("blockHeader.rawData.number", values)
+	if block.height, err = strconv.ParseInt(number, 10, 64); err != nil {
+		return blockInfo{}, fmt.Errorf("invalid block number %q", number)
+	}
+	timestamp, _ := renderKey("blockHeader.rawData.timestamp", values)
+	ms, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return blockInfo{}, fmt.Errorf("invalid block timestamp %q", timestamp)
+	}
+	block.time = time.UnixMilli(ms)
+	return block, nil
+}
+
+func validBlockChainType(condition *HealthCheckCondition) error {
+	switch condition.ChainType {
+	case "", CHAIN_TYPE_EVM, CHAIN_TYPE_COMETBFT, CHAIN_TYPE_COSMOS:
+		return nil
+	case CHAIN_TYPE_TRON:
+		if condition.Payload == "" {
+			return errors.New("invalid or empty payload")
+		}
+		var payload ConditionGrpcPayload
+		if err := json.Unmarshal([]byte(condition.Payload), &payload); err != nil {
+			return err
+		}
+		return payload.valid()
+	}
+	return fmt.Errorf("chain type %s not supported", condition.ChainType)
+}
+
+// consensusDepth is how far below the median head nodes compare block hashes, clear of reorgs at the tip
+const consensusDepth = 3
+
+type consensusChecker struct {
+	blockReader
+}
+
+// Check reads the block hash at a common recent height from every node and fails nodes that disagree with the majority.
+// Without a hash reaching the quorum nobody can be told on a fork, so only nodes that can't be read fail.
+func (c *consensusChecker) Check(ctx context.Context, _ string, urls []string, condition *HealthCheckCondition, _ CheckCaches) (map[string]bool, error) {
+	ret := make(map[string]bool, len(urls))
+	checked := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if condition.ignore(url) {
+			ret[url] = true
+			continue
+		}
+		checked = append(checked, url)
+	}
+
+	heads := make([]int64, 0, len(checked))
+	for url, r := range c.blocks(ctx, checked, condition, 0) {
+		if r.err != nil {
+			condition.fail(url, "check url %s error: %s", url, r.err.Error())
+			continue
+		}
+		heads = append(heads, r.block.height)
+		condition.observe(url, r.block.height, 0)
+	}
+	if len(heads) == 0 {
+		for _, url := range checked {
+			ret[url] = false
+		}
+		return ret, nil
+	}
+	slices.Sort(heads)
+	height := max(heads[len(heads)/2]-consensusDepth, 1)
+
+	results := c.blocks(ctx, checked, condition, height)
+	votes := make(map[string]int)
+	answered := 0
+	for url, r := range results {
+		if r.err != nil {
+			condition.fail(url, "check url %s at height %d error: %s", url, height, r.err.Error())
+			continue
+		}
+		votes[r.block.hash]++
+		answered++
+	}
+	majority, ok := consensusHash(votes, condition.Quorum)
+	if !ok && len(votes) > 1 {
+		log.Printf("checkStrategy: %s, NO CONSENSUS at height %d: %v\n", condition.CheckStrategy, height, votes)
+	}
+	for url, r := range results {
+		switch {
+		case r.err != nil:
+			ret[url] = false
+		case !ok || r.block.hash == majority:
+			ret[url] = true
+		default:
+			ret[url] = false
+			condition.fail(url, "FORK DETECTED: url %s has block %s at height %d, %d of %d nodes have %s",
+				url, r.block.hash, height, votes[majority], answered, majority)
+		}
+	}
+	return ret, nil
+}
+
+// consensusHash returns the hash most nodes agree on once it reaches the quorum, the share of answering nodes.
+// Without a quorum more than half of the nodes must agree.
+func consensusHash(votes map[string]int, quorum float64) (string, bool) {
+	var (
+		hash  string
+		top   int
+		tie   bool
+		total int
+	)
+	for h, n := range votes {
+		total += n
+		switch {
+		case n > top:
+			hash, top, tie = h, n, false
+		case n == top:
+			tie = true
+		}
+	}
+	if total == 0 || tie {
+		return "", false
+	}
+	if quorum <= 0 {
+		return hash, top*2 > total
+	}
+	return hash, float64(top)/float64(total) >= quorum
+}
+
+func (c *consensusChecker) ValidCondition(condition *HealthCheckCondition) error {
+	if condition.Quorum < 0 || condition.Quorum > 1 {
+		return fmt.Errorf("invalid quorum %v", condition.Quorum)
+	}
+	return validBlockChainType(condition)
+}
+
+type freshnessChecker struct {
+	blockReader
+}
+
+// Check fails nodes whose latest block is older than maxAge, which catches a pool that is stuck as a whole
+func (c *freshnessChecker) Check(ctx context.Context, _ string, urls []string, condition *HealthCheckCondition, _ CheckCaches) (map[string]bool, error) {
+	maxAge, err := time.ParseDuration(condition.MaxAge)
+	if err != nil {
+		return nil, err
+	}
+	return checkUrls(urls, condition, func(url string) (bool, error) {
+		block, err := c.block(ctx, url, condition, 0)
+		if err != nil {
+			return false, err
+		}
+		condition.observe(url, block.height, 0)
+		if age := time.Since(block.time); age > maxAge {
+			condition.fail(url, "STALE HEAD: url %s is at block %d from %s, %s old, max age %s",
+				url, block.height, block.time.UTC().Format(time.RFC3339), age.Truncate(time.Second), maxAge)
+			return false, nil
+		}
+		return true, nil
+	}), nil
+}
+
+func (c *freshnessChecker) ValidCondition(condition *HealthCheckCondition) error {
+	maxAge, err := time.ParseDuration(condition.MaxAge)
+	if err != nil || maxAge <= 0 {
+		return fmt.Errorf("invalid max age %q", condition.MaxAge)
+	}
+	return validBlockChainType(condition)
+}
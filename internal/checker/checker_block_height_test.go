@@ -2,6 +2,7 @@ package checker
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -63,7 +64,7 @@ func TestBlockHeightChecker_Check_Ignore(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{url}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{url}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -91,7 +92,7 @@ func TestBlockHeightChecker_Check_CallError_ReturnsFalse(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{ts.URL}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{ts.URL}, cond, caches)
 	if err != nil {
 		t.Fatalf("did not expect error from Check, got %v", err)
 	}
@@ -126,7 +127,7 @@ func TestBlockHeightChecker_Check_Success_WithTolerance_LessEqual(t *testing.T)
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("chainA", []string{ts100.URL, ts98.URL}, cond, caches)
+	ret, err := c.Check(context.Background(), "chainA", []string{ts100.URL, ts98.URL}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -159,7 +160,7 @@ func TestBlockHeightChecker_Check_Success_WithTolerance_LessOnly(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("chainB", []string{ts100.URL, ts98.URL}, cond, caches)
+	ret, err := c.Check(context.Background(), "chainB", []string{ts100.URL, ts98.URL}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -197,7 +198,7 @@ func TestBlockHeightChecker_Check_UsesLastBlocks(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("chainC", []string{ts10.URL, ts8.URL}, cond, caches)
+	ret, err := c.Check(context.Background(), "chainC", []string{ts10.URL, ts8.URL}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -232,7 +233,7 @@ func TestBlockHeightChecker_getHeight_NoValue(t *testing.T) {
 	}
 	caches[key] = NewTimedCache(checkCacheValue{"foo": "bar"}, time.Second)
 
-	h, err := c.getHeight(url, cond, caches)
+	h, err := c.getHeight(context.Background(), url, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -240,3 +241,42 @@ func TestBlockHeightChecker_getHeight_NoValue(t *testing.T) {
 		t.Fatalf("expected -1 for '<no value>', got %d", h)
 	}
 }
+
+func TestBlockHeightChecker_Check_CosmosRest(t *testing.T) {
+	newLcd := func(height string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || r.URL.Path != "/cosmos/base/tendermint/v1beta1/blocks/latest" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(`{"block":{"header":{"height":"` + height + `"}}}`))
+		}))
+	}
+	head := newLcd("1000")
+	defer head.Close()
+	behind := newLcd("990")
+	defer behind.Close()
+
+	c := &blockHeightChecker{
+		JsonRpcCaller: JsonRpcCaller{},
+		cli:           &http.Client{},
+		cacheExpire:   100 * time.Millisecond,
+		lastBlocks:    make(map[string]int64),
+	}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_BLOCK_HEIGHT,
+		Method:        http.MethodGet,
+		Path:          "/cosmos/base/tendermint/v1beta1/blocks/latest",
+		Matchers:      []Matcher{{MatchType: "<=", Key: "block.header.height", Value: "5"}},
+	}
+	if err := c.ValidCondition(cond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ret, err := c.Check(context.Background(), "cosmoshub-4", []string{head.URL, behind.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[head.URL] || ret[behind.URL] {
+		t.Fatalf("expected only the node 10 blocks behind to fail, got %v", ret)
+	}
+}
@@ -0,0 +1,140 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newCapabilityServer fakes a node at height 1000000 serving state back to stateDepth blocks and logs over maxRange blocks
+func newCapabilityServer(stateDepth, maxRange int64, methods ...string) *httptest.Server {
+	const height = 1000000
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.HasPrefix(string(body), "[") {
+			if !contains(methods, "batch") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`[{"id":1,"result":"0xf4240"},{"id":2,"result":"0x1"}]`))
+			return
+		}
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		_ = json.Unmarshal(body, &req)
+		switch req.Method {
+		case "eth_blockNumber":
+			_, _ = w.Write([]byte(`{"result":"0xf4240"}`))
+		case "eth_getBalance":
+			var block string
+			_ = json.Unmarshal(req.Params[1], &block)
+			n, _ := strconv.ParseInt(strings.TrimPrefix(block, "0x"), 16, 64)
+			if height-n > stateDepth {
+				_, _ = w.Write([]byte(`{"error":{"code":-32000,"message":"missing trie node"}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"result":"0x0"}`))
+		case "eth_getLogs":
+			var filter struct {
+				FromBlock string `json:"fromBlock"`
+				ToBlock   string `json:"toBlock"`
+			}
+			_ = json.Unmarshal(req.Params[0], &filter)
+			from, _ := strconv.ParseInt(strings.TrimPrefix(filter.FromBlock, "0x"), 16, 64)
+			to, _ := strconv.ParseInt(strings.TrimPrefix(filter.ToBlock, "0x"), 16, 64)
+			if to-from+1 > maxRange {
+				_, _ = w.Write([]byte(`{"error":{"code":-32005,"message":"query exceeds max block range"}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"result":[]}`))
+		default:
+			if !contains(methods, req.Method) {
+				_, _ = w.Write([]byte(`{"error":{"code":-32601,"message":"the method ` + req.Method + ` does not exist/is not available"}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"error":{"code":-32000,"message":"transaction 0x00 not found"}}`))
+		}
+	}))
+}
+
+func contains(arr []string, s string) bool {
+	for _, v := range arr {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCapabilityChecker_ValidCondition(t *testing.T) {
+	c := &capabilityChecker{}
+	if err := c.ValidCondition(&HealthCheckCondition{Capabilities: []string{"archive", "mev"}}); err == nil {
+		t.Fatalf("expected error for unsupported capability")
+	}
+	if err := c.ValidCondition(&HealthCheckCondition{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCapabilityChecker_Check_Tags(t *testing.T) {
+	archive := newCapabilityServer(1000000, 10000, "debug_traceTransaction", "trace_transaction", "batch")
+	defer archive.Close()
+	full := newCapabilityServer(128, 500)
+	defer full.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	c := &capabilityChecker{cli: &http.Client{}, reports: make(map[string]NodeReport)}
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_CAPABILITY}
+	ret, err := c.Check(context.Background(), "1", []string{archive.URL, full.URL, down.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[archive.URL] || !ret[full.URL] || ret[down.URL] {
+		t.Fatalf("expected only the unreachable node to fail, got %v", ret)
+	}
+
+	reports := c.Reports()
+	if want := []string{"archive", "trace", "debug", "logs:10000", "batch"}; !reflect.DeepEqual(reports[archive.URL].Tags, want) {
+		t.Fatalf("expected %v for the archive node, got %v", want, reports[archive.URL].Tags)
+	}
+	if want := []string{"state:128", "logs:500"}; !reflect.DeepEqual(reports[full.URL].Tags, want) {
+		t.Fatalf("expected %v for the full node, got %v", want, reports[full.URL].Tags)
+	}
+	if _, ok := reports[down.URL]; ok {
+		t.Fatalf("expected no report for the unreachable node")
+	}
+}
+
+func TestCapabilityChecker_Check_SelectedProbes(t *testing.T) {
+	ts := newCapabilityServer(1000000, 100, "debug_traceTransaction")
+	defer ts.Close()
+
+	c := &capabilityChecker{cli: &http.Client{}, reports: make(map[string]NodeReport)}
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_CAPABILITY, Capabilities: []string{"debug", "trace"}}
+	if _, err := c.Check(context.Background(), "1", []string{ts.URL}, cond, CheckCaches{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"debug"}; !reflect.DeepEqual(c.Reports()[ts.URL].Tags, want) {
+		t.Fatalf("expected %v, got %v", want, c.Reports()[ts.URL].Tags)
+	}
+}
+
+func TestMethodNotFound(t *testing.T) {
+	if !methodNotFound(&rpcError{Code: -32601}) || !methodNotFound(&rpcError{Code: -32000, Message: "Unsupported method: trace_block"}) {
+		t.Fatalf("expected method not found")
+	}
+	if methodNotFound(&rpcError{Code: -32000, Message: "transaction not found"}) {
+		t.Fatalf("expected an unknown transaction to mean the method exists")
+	}
+}
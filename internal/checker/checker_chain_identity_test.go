@@ -0,0 +1,147 @@
+package checker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newChainIdentityChecker() *chainIdentityChecker {
+	return &chainIdentityChecker{
+		JsonRpcCaller: JsonRpcCaller{},
+		cli:           &http.Client{},
+		cacheExpire:   time.Second,
+	}
+}
+
+func newEvmServer(chainId, netVersion string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "eth_chainId") && chainId != "":
+			_, _ = w.Write([]byte(`{"result":"` + chainId + `"}`))
+		case strings.Contains(string(body), "net_version"):
+			_, _ = w.Write([]byte(`{"result":"` + netVersion + `"}`))
+		default:
+			_, _ = w.Write([]byte(`{"error":{"code":-32601,"message":"method not found"}}`))
+		}
+	}))
+}
+
+func TestChainIdentityChecker_ValidCondition(t *testing.T) {
+	c := newChainIdentityChecker()
+	if err := c.ValidCondition(&HealthCheckCondition{ChainType: "bitcoin"}); err == nil {
+		t.Fatalf("expected error for unsupported chain type")
+	}
+	if err := c.ValidCondition(&HealthCheckCondition{ChainType: CHAIN_TYPE_TRON}); err == nil {
+		t.Fatalf("expected error for tron without payload")
+	}
+	if err := c.ValidCondition(&HealthCheckCondition{}); err != nil {
+		t.Fatalf("unexpected error for evm: %v", err)
+	}
+}
+
+func TestChainIdentityChecker_Check_Evm(t *testing.T) {
+	mainnet := newEvmServer("0x1", "1")
+	defer mainnet.Close()
+	sepolia := newEvmServer("0xaa36a7", "11155111")
+	defer sepolia.Close()
+	legacy := newEvmServer("", "1")
+	defer legacy.Close()
+
+	c := newChainIdentityChecker()
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_CHAIN_IDENTITY}
+	ret, err := c.Check(context.Background(), "1", []string{mainnet.URL, sepolia.URL, legacy.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[mainnet.URL] || ret[sepolia.URL] || !ret[legacy.URL] {
+		t.Fatalf("expected only the sepolia node to fail, got %v", ret)
+	}
+}
+
+func TestChainIdentityChecker_Check_CallError_ReturnsFalse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	c := newChainIdentityChecker()
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_CHAIN_IDENTITY, Ignore: []string{"http://ignored"}}
+	ret, err := c.Check(context.Background(), "1", []string{ts.URL, "http://ignored"}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("did not expect error from Check, got %v", err)
+	}
+	if ret[ts.URL] || !ret["http://ignored"] {
+		t.Fatalf("expected failing node false and ignored node true, got %v", ret)
+	}
+}
+
+func TestChainIdentityChecker_Check_CometBFT(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":-1,"result":{"node_info":{"network":"chihuahua-1"}}}`))
+	}))
+	defer ts.Close()
+
+	c := newChainIdentityChecker()
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_CHAIN_IDENTITY, ChainType: CHAIN_TYPE_COMETBFT}
+	ret, err := c.Check(context.Background(), "chihuahua-1", []string{ts.URL}, cond, CheckCaches{})
+	if err != nil || !ret[ts.URL] {
+		t.Fatalf("expected matching network, got %v (err=%v)", ret, err)
+	}
+	ret, err = c.Check(context.Background(), "juno-1", []string{ts.URL}, cond, CheckCaches{})
+	if err != nil || ret[ts.URL] {
+		t.Fatalf("expected mismatching network, got %v (err=%v)", ret, err)
+	}
+}
+
+func TestChainIdentityChecker_Check_Tron(t *testing.T) {
+	// tron mainnet genesis block id, its last 4 bytes are the chain id 728126428
+	id, _ := hex.DecodeString("00000000000000001ebf88508a03865c71d452e25f4d51194196a1d22b6653dc")
+	c := newChainIdentityChecker()
+	c.grpcCall = func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error) {
+		if payload.Service != "Wallet" || payload.Method != "GetBlockByNum2" {
+			return nil, errors.New("unexpected method")
+		}
+		if url == "down:50051" {
+			return nil, errors.New("unavailable")
+		}
+		return map[string]interface{}{"blockid": base64.StdEncoding.EncodeToString(id)}, nil
+	}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_CHAIN_IDENTITY,
+		ChainType:     CHAIN_TYPE_TRON,
+		Payload:       `{"protoset":"unused"}`,
+	}
+	ret, err := c.Check(context.Background(), "728126428", []string{"grpc:50051", "down:50051"}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret["grpc:50051"] || ret["down:50051"] {
+		t.Fatalf("expected mainnet node true and unavailable node false, got %v", ret)
+	}
+	ret, err = c.Check(context.Background(), "3448148188", []string{"grpc:50051"}, cond, CheckCaches{})
+	if err != nil || ret["grpc:50051"] {
+		t.Fatalf("expected mainnet node to fail under the nile chain id, got %v (err=%v)", ret, err)
+	}
+}
+
+func TestSameChain(t *testing.T) {
+	if !sameChain("", "0x38", "56") || !sameChain(CHAIN_TYPE_EVM, "56", "0x38") {
+		t.Fatalf("expected hex and decimal evm chain ids to match")
+	}
+	if sameChain(CHAIN_TYPE_COMETBFT, "0x1", "1") {
+		t.Fatalf("expected cometbft networks to be compared as strings")
+	}
+}
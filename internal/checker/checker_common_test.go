@@ -2,6 +2,7 @@ package checker
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"testing"
 	"time"
@@ -15,7 +16,7 @@ type fakeHealthChecker struct {
 	checkCalled int
 }
 
-func (f *fakeHealthChecker) Check(chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
+func (f *fakeHealthChecker) Check(_ context.Context, chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error) {
 	f.checkCalled++
 	return f.ret, f.checkErr
 }
@@ -37,7 +38,7 @@ func TestCommonChecker_Check_EmptyURLs(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -60,7 +61,7 @@ func TestCommonChecker_Check_Create_ValueMatch(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{url}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{url}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -99,7 +100,7 @@ func TestCommonChecker_Check_Create_BlockHeight(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{url}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{url}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -150,7 +151,7 @@ func TestCommonChecker_Check_Create_Simple_WithCacheHit(t *testing.T) {
 	}
 	caches[key] = NewTimedCache(checkCacheValue{"result": "OK"}, time.Second)
 
-	ret, err := c.Check("1", []string{url}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{url}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -190,7 +191,7 @@ func TestCommonChecker_Check_Create_Manual(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{urlGood, urlBad}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{urlGood, urlBad}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -221,7 +222,7 @@ func TestCommonChecker_Check_UnsupportedStrategy(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	_, err := c.Check("1", []string{"http://rpc.example"}, cond, caches)
+	_, err := c.Check(context.Background(), "1", []string{"http://rpc.example"}, cond, caches)
 	if err == nil {
 		t.Fatalf("expected error for unsupported strategy, got nil")
 	}
@@ -235,11 +236,11 @@ func TestCommonChecker_Check_ValidConditionError_ValueMatch(t *testing.T) {
 	}
 	cond := &HealthCheckCondition{
 		CheckStrategy: CHECK_STRATEGY_VALUE_MATCH,
-		Matchers:      []Matcher{{MatchType: "<", Key: "result", Value: "0x1"}},
+		Matchers:      []Matcher{{MatchType: "~=", Key: "result", Value: "0x1"}},
 	}
 	caches := CheckCaches{}
 
-	_, err := c.Check("1", []string{"http://rpc.example"}, cond, caches)
+	_, err := c.Check(context.Background(), "1", []string{"http://rpc.example"}, cond, caches)
 	if err == nil {
 		t.Fatalf("expected error from ValidCondition, got nil")
 	}
@@ -265,7 +266,7 @@ func TestCommonChecker_Check_UseExistingChecker(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{"http://rpc.example"}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{"http://rpc.example"}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
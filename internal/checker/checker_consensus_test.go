@@ -0,0 +1,175 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newEvmChainServer serves eth_getBlockByNumber for a chain at head, hash names the block at each height
+func newEvmChainServer(head int64, blockTime time.Time, hash func(height int64) string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "eth_getBlockByNumber" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		height := head
+		if tag, _ := req.Params[0].(string); tag != "latest" {
+			height, _ = strconv.ParseInt(strings.TrimPrefix(tag, "0x"), 16, 64)
+		}
+		if height > head {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"number":"0x%x","hash":"%s","timestamp":"0x%x"}}`, height, hash(height), blockTime.Unix())
+	}))
+}
+
+func canonicalHash(height int64) string {
+	return fmt.Sprintf("0x%064x", height)
+}
+
+func newConsensusChecker() *consensusChecker {
+	return &consensusChecker{blockReader: blockReader{JsonRpcCaller: JsonRpcCaller{}, cli: &http.Client{}}}
+}
+
+func TestConsensusHash(t *testing.T) {
+	if hash, ok := consensusHash(map[string]int{"a": 2, "b": 1}, 0); !ok || hash != "a" {
+		t.Fatalf("expected a majority for a, got %s %t", hash, ok)
+	}
+	if _, ok := consensusHash(map[string]int{"a": 2, "b": 2}, 0); ok {
+		t.Fatalf("expected no consensus for a tie")
+	}
+	if _, ok := consensusHash(map[string]int{"a": 3, "b": 2}, 0.75); ok {
+		t.Fatalf("expected 3 of 5 to miss a 0.75 quorum")
+	}
+	if hash, ok := consensusHash(map[string]int{"a": 2, "b": 1, "c": 1}, 0.5); !ok || hash != "a" {
+		t.Fatalf("expected 2 of 4 to reach a 0.5 quorum, got %s %t", hash, ok)
+	}
+}
+
+func TestConsensusChecker_ValidCondition(t *testing.T) {
+	c := newConsensusChecker()
+	if err := c.ValidCondition(&HealthCheckCondition{Quorum: 1.5}); err == nil {
+		t.Fatalf("expected error for a quorum above 1")
+	}
+	if err := c.ValidCondition(&HealthCheckCondition{ChainType: CHAIN_TYPE_TRON, Payload: `{"service":"Wallet"}`}); err == nil {
+		t.Fatalf("expected error for tron without a descriptor source")
+	}
+	if err := c.ValidCondition(&HealthCheckCondition{Quorum: 0.66}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestConsensusChecker_Check_Fork(t *testing.T) {
+	now := time.Now()
+	a := newEvmChainServer(100, now, canonicalHash)
+	defer a.Close()
+	b := newEvmChainServer(101, now, canonicalHash)
+	defer b.Close()
+	// the fork left the canonical chain at block 90 but keeps up with the head
+	fork := newEvmChainServer(102, now, func(height int64) string {
+		if height >= 90 {
+			return fmt.Sprintf("0x%064x", height+1_000_000)
+		}
+		return canonicalHash(height)
+	})
+	defer fork.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	c := newConsensusChecker()
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_CONSENSUS, Ignore: []string{"http://ignored"}}
+	ret, err := c.Check(context.Background(), "1", []string{a.URL, b.URL, fork.URL, down.URL, "http://ignored"}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[a.URL] || !ret[b.URL] || ret[fork.URL] || ret[down.URL] || !ret["http://ignored"] {
+		t.Fatalf("expected the fork and the unreachable node to fail, got %v", ret)
+	}
+}
+
+func TestConsensusChecker_Check_NoQuorum(t *testing.T) {
+	now := time.Now()
+	a := newEvmChainServer(100, now, canonicalHash)
+	defer a.Close()
+	b := newEvmChainServer(100, now, func(height int64) string { return fmt.Sprintf("0x%064x", height+1) })
+	defer b.Close()
+
+	c := newConsensusChecker()
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_CONSENSUS}
+	ret, err := c.Check(context.Background(), "1", []string{a.URL, b.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[a.URL] || !ret[b.URL] {
+		t.Fatalf("expected both nodes to pass without a majority, got %v", ret)
+	}
+}
+
+func TestConsensusChecker_Check_CometBFT(t *testing.T) {
+	newNode := func(hash string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			height := r.URL.Query().Get("height")
+			if height == "" {
+				height = "50"
+			}
+			_, _ = fmt.Fprintf(w, `{"jsonrpc":"2.0","id":-1,"result":{"block_id":{"hash":"%s"},"block":{"header":{"height":"%s","time":"2025-11-20T08:00:00.123456789Z"}}}}`, hash+height, height)
+		}))
+	}
+	a := newNode("AA")
+	defer a.Close()
+	b := newNode("AA")
+	defer b.Close()
+	c2 := newNode("BB")
+	defer c2.Close()
+
+	c := newConsensusChecker()
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_CONSENSUS, ChainType: CHAIN_TYPE_COMETBFT}
+	ret, err := c.Check(context.Background(), "chihuahua-1", []string{a.URL, b.URL, c2.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[a.URL] || !ret[b.URL] || ret[c2.URL] {
+		t.Fatalf("expected only the divergent node to fail, got %v", ret)
+	}
+}
+
+func TestBlockReader_Tron(t *testing.T) {
+	r := &blockReader{grpcCall: func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error) {
+		block := map[string]interface{}{
+			"blockid":     "AAAAAAAAAGQ=",
+			"blockHeader": map[string]interface{}{"rawData": map[string]interface{}{"number": "100", "timestamp": "1763625600000"}},
+		}
+		switch {
+		case payload.Method == "GetNowBlock2" && payload.Request == nil:
+			return block, nil
+		case payload.Method == "GetBlockByNum2" && string(payload.Request) == `{"num":100}`:
+			return block, nil
+		}
+		return nil, errors.New("unexpected call")
+	}}
+	cond := &HealthCheckCondition{ChainType: CHAIN_TYPE_TRON, Payload: `{"reflection":true}`}
+	for _, height := range []int64{0, 100} {
+		block, err := r.block(context.Background(), "grpc:50051", cond, height)
+		if err != nil {
+			t.Fatalf("unexpected error at height %d: %v", height, err)
+		}
+		if block.height != 100 || block.hash != "AAAAAAAAAGQ=" || !block.time.Equal(time.UnixMilli(1763625600000)) {
+			t.Fatalf("unexpected block %+v", block)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newFreshnessChecker() *freshnessChecker {
+	return &freshnessChecker{blockReader: blockReader{JsonRpcCaller: JsonRpcCaller{}, cli: &http.Client{}}}
+}
+
+func TestFreshnessChecker_ValidCondition(t *testing.T) {
+	c := newFreshnessChecker()
+	for _, maxAge := range []string{"", "soon", "-1m", "0s"} {
+		if err := c.ValidCondition(&HealthCheckCondition{MaxAge: maxAge}); err == nil {
+			t.Fatalf("expected error for max age %q", maxAge)
+		}
+	}
+	if err := c.ValidCondition(&HealthCheckCondition{MaxAge: "1m", ChainType: CHAIN_TYPE_SOLANA}); err == nil {
+		t.Fatalf("expected error for an unsupported chain type")
+	}
+	if err := c.ValidCondition(&HealthCheckCondition{MaxAge: "1m", ChainType: CHAIN_TYPE_COSMOS}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestFreshnessChecker_Check_Evm(t *testing.T) {
+	fresh := newEvmChainServer(100, time.Now().Add(-5*time.Second), canonicalHash)
+	defer fresh.Close()
+	stale := newEvmChainServer(100, time.Now().Add(-10*time.Minute), canonicalHash)
+	defer stale.Close()
+
+	c := newFreshnessChecker()
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_FRESHNESS, MaxAge: "1m", Ignore: []string{"http://ignored"}}
+	if err := c.ValidCondition(cond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ret, err := c.Check(context.Background(), "1", []string{fresh.URL, stale.URL, "http://ignored"}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[fresh.URL] || ret[stale.URL] || !ret["http://ignored"] {
+		t.Fatalf("expected only the stale node to fail, got %v", ret)
+	}
+}
+
+func TestFreshnessChecker_Check_StuckPool(t *testing.T) {
+	// every node agrees on the same height, only the block time tells they are stuck
+	stuck := time.Now().Add(-time.Hour)
+	newLcd := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/cosmos/base/tendermint/v1beta1/blocks/latest" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"block_id":{"hash":"qg=="},"block":{"header":{"height":"1000","time":"%s"}}}`, stuck.UTC().Format(time.RFC3339Nano))
+		}))
+	}
+	a := newLcd()
+	defer a.Close()
+	b := newLcd()
+	defer b.Close()
+
+	c := newFreshnessChecker()
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_FRESHNESS, ChainType: CHAIN_TYPE_COSMOS, MaxAge: "2m"}
+	ret, err := c.Check(context.Background(), "cosmoshub-4", []string{a.URL, b.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret[a.URL] || ret[b.URL] {
+		t.Fatalf("expected every stuck node to fail, got %v", ret)
+	}
+}
@@ -1,17 +1,18 @@
 package checker
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
 	"time"
 )
 
-var grpcCallStub func(url, protoset, service, method string) (map[string]interface{}, error)
+var grpcCallStub func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error)
 
-func (c *grpcBlockHeightChecker) Call(url, protoset, service, method string) (map[string]interface{}, error) {
+func (c *grpcBlockHeightChecker) Call(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error) {
 	if grpcCallStub != nil {
-		return grpcCallStub(url, protoset, service, method)
+		return grpcCallStub(ctx, url, payload)
 	}
 	return nil, fmt.Errorf("grpcCallStub not set")
 }
@@ -29,7 +30,7 @@ func TestGrpcBlockHeightChecker_GetHeight_PayloadUnmarshalError(t *testing.T) {
 		Matchers:      []Matcher{{MatchType: "<=", Key: "result", Value: "1"}},
 	}
 
-	h, err := c.getHeight("localhost:9090", cond, CheckCaches{})
+	h, err := c.getHeight(context.Background(), "localhost:9090", cond, CheckCaches{})
 	if err == nil || h != -1 {
 		t.Fatalf("expected (-1, error), got (%d, %v)", h, err)
 	}
@@ -38,7 +39,7 @@ func TestGrpcBlockHeightChecker_GetHeight_PayloadUnmarshalError(t *testing.T) {
 func TestGrpcBlockHeightChecker_GetHeight_CallError(t *testing.T) {
 	defer func() { grpcCallStub = nil }()
 
-	grpcCallStub = func(url, protoset, service, method string) (map[string]interface{}, error) {
+	grpcCallStub = func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error) {
 		return nil, errors.New("boom")
 	}
 
@@ -52,7 +53,7 @@ func TestGrpcBlockHeightChecker_GetHeight_CallError(t *testing.T) {
 		Matchers:      []Matcher{{MatchType: "<=", Key: "result", Value: "1"}},
 	}
 
-	h, err := c.getHeight("localhost:9090", cond, CheckCaches{})
+	h, err := c.getHeight(context.Background(), "localhost:9090", cond, CheckCaches{})
 	if err != nil || h != -1 {
 		t.Fatalf("expected (-1, nil) on call error, got (%d, %v)", h, err)
 	}
@@ -61,7 +62,7 @@ func TestGrpcBlockHeightChecker_GetHeight_CallError(t *testing.T) {
 func TestGrpcBlockHeightChecker_GetHeight_NoValue(t *testing.T) {
 	defer func() { grpcCallStub = nil }()
 
-	grpcCallStub = func(url, protoset, service, method string) (map[string]interface{}, error) {
+	grpcCallStub = func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error) {
 		return map[string]interface{}{"foo": "bar"}, nil
 	}
 
@@ -72,7 +73,7 @@ func TestGrpcBlockHeightChecker_GetHeight_NoValue(t *testing.T) {
 		Matchers:      []Matcher{{MatchType: "<=", Key: "result", Value: "1"}},
 	}
 
-	h, err := c.getHeight("localhost:9090", cond, CheckCaches{})
+	h, err := c.getHeight(context.Background(), "localhost:9090", cond, CheckCaches{})
 	if err != nil || h != -1 {
 		t.Fatalf("expected (-1, nil) for <no value>, got (%d, %v)", h, err)
 	}
@@ -81,7 +82,7 @@ func TestGrpcBlockHeightChecker_GetHeight_NoValue(t *testing.T) {
 func TestGrpcBlockHeightChecker_GetHeight_RegexNoMatch(t *testing.T) {
 	defer func() { grpcCallStub = nil }()
 
-	grpcCallStub = func(url, protoset, service, method string) (map[string]interface{}, error) {
+	grpcCallStub = func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error) {
 		return map[string]interface{}{"result": "abc"}, nil
 	}
 
@@ -92,7 +93,7 @@ func TestGrpcBlockHeightChecker_GetHeight_RegexNoMatch(t *testing.T) {
 		Matchers:      []Matcher{{MatchType: "<=", Key: "result|^(0x[0-9a-fA-F]+)$", Value: "2"}},
 	}
 
-	h, err := c.getHeight("localhost:9090", cond, CheckCaches{})
+	h, err := c.getHeight(context.Background(), "localhost:9090", cond, CheckCaches{})
 	if err != nil || h != -1 {
 		t.Fatalf("expected (-1, nil) for regex no match, got (%d, %v)", h, err)
 	}
@@ -101,7 +102,7 @@ func TestGrpcBlockHeightChecker_GetHeight_RegexNoMatch(t *testing.T) {
 func TestGrpcBlockHeightChecker_GetHeight_ParseHexSuccess(t *testing.T) {
 	defer func() { grpcCallStub = nil }()
 
-	grpcCallStub = func(url, protoset, service, method string) (map[string]interface{}, error) {
+	grpcCallStub = func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error) {
 		return map[string]interface{}{"result": "0x2a"}, nil
 	}
 
@@ -112,7 +113,7 @@ func TestGrpcBlockHeightChecker_GetHeight_ParseHexSuccess(t *testing.T) {
 		Matchers:      []Matcher{{MatchType: "<=", Key: "result|^(0x[0-9a-fA-F]+)$", Value: "0x5"}},
 	}
 
-	h, err := c.getHeight("localhost:9090", cond, CheckCaches{})
+	h, err := c.getHeight(context.Background(), "localhost:9090", cond, CheckCaches{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -124,7 +125,7 @@ func TestGrpcBlockHeightChecker_GetHeight_ParseHexSuccess(t *testing.T) {
 func TestGrpcBlockHeightChecker_GetHeight_ParseDecimalSuccess(t *testing.T) {
 	defer func() { grpcCallStub = nil }()
 
-	grpcCallStub = func(url, protoset, service, method string) (map[string]interface{}, error) {
+	grpcCallStub = func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error) {
 		return map[string]interface{}{"height": "42"}, nil
 	}
 
@@ -135,7 +136,7 @@ func TestGrpcBlockHeightChecker_GetHeight_ParseDecimalSuccess(t *testing.T) {
 		Matchers:      []Matcher{{MatchType: "<=", Key: "height|([0-9]+)", Value: "2"}},
 	}
 
-	h, err := c.getHeight("localhost:9090", cond, CheckCaches{})
+	h, err := c.getHeight(context.Background(), "localhost:9090", cond, CheckCaches{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
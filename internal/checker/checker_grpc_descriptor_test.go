@@ -0,0 +1,178 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/apipb"
+)
+
+func newHealthServer(t *testing.T, withReflection bool, opts ...grpc.ServerOption) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	if withReflection {
+		reflection.Register(srv)
+	}
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+// newProtoset builds a descriptor set of the files with their imports, imports first
+func newProtoset(t *testing.T, files ...protoreflect.FileDescriptor) []byte {
+	var fs descriptorpb.FileDescriptorSet
+	seen := make(map[string]bool)
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+		for i := 0; i < fd.Imports().Len(); i++ {
+			add(fd.Imports().Get(i).FileDescriptor)
+		}
+		fs.File = append(fs.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	for _, fd := range files {
+		add(fd)
+	}
+	data, err := proto.Marshal(&fs)
+	if err != nil {
+		t.Fatalf("marshal protoset: %v", err)
+	}
+	return data
+}
+
+func TestConditionGrpcPayload_valid(t *testing.T) {
+	if err := (ConditionGrpcPayload{Service: "Wallet", Method: "GetNowBlock"}).valid(); err == nil {
+		t.Fatalf("expected error without a descriptor source")
+	}
+	if err := (ConditionGrpcPayload{Reflection: true, Service: "Wallet"}).valid(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := (ConditionGrpcPayload{Reflection: true, Request: json.RawMessage(`[1]`)}).valid(); err == nil {
+		t.Fatalf("expected error for a request that is not an object")
+	}
+}
+
+func TestProtosetServices_MultipleFiles(t *testing.T) {
+	// api.proto imports type.proto and source_context.proto, the health service is in the last file
+	data := newProtoset(t, apipb.File_google_protobuf_api_proto, healthpb.File_grpc_health_v1_health_proto)
+	services, err := protosetServices(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sd := findService(services, "Health"); sd == nil || sd.GetFullyQualifiedName() != "grpc.health.v1.Health" {
+		t.Fatalf("expected the health service by its short name, got %v", sd)
+	}
+	if sd := findService(services, "grpc.health.v1.Health"); sd == nil {
+		t.Fatalf("expected the health service by its full name")
+	}
+	if sd := findService(services, "Wallet"); sd != nil {
+		t.Fatalf("expected no service, got %s", sd.GetFullyQualifiedName())
+	}
+}
+
+func TestGrpcCaller_Call_Reflection(t *testing.T) {
+	addr := newHealthServer(t, true)
+	c := &GrpcCaller{}
+	values, err := c.Call(context.Background(), addr, ConditionGrpcPayload{Reflection: true, Service: "Health", Method: "Check"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if values["status"] != "SERVING" {
+		t.Fatalf("expected SERVING, got %v", values)
+	}
+	if _, ok := cachedServices("reflection:" + addr + "/Health"); !ok {
+		t.Fatalf("expected the reflected services to be cached")
+	}
+}
+
+func TestGrpcCaller_Call_UploadedProtoset(t *testing.T) {
+	addr := newHealthServer(t, false)
+	data := newProtoset(t, healthpb.File_grpc_health_v1_health_proto)
+	loads := 0
+	c := &GrpcCaller{LoadProtoset: func(name string) ([]byte, error) {
+		loads++
+		if name != "health-upload" {
+			return nil, errors.New("protoset not found")
+		}
+		return data, nil
+	}}
+	payload := ConditionGrpcPayload{ProtosetName: "health-upload", Service: "grpc.health.v1.Health", Method: "Check"}
+	for i := 0; i < 2; i++ {
+		values, err := c.Call(context.Background(), addr, payload)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if values["status"] != "SERVING" {
+			t.Fatalf("expected SERVING, got %v", values)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expected the protoset to be loaded once, got %d", loads)
+	}
+
+	payload.Method = "Missing"
+	if _, err := c.Call(context.Background(), addr, payload); err == nil {
+		t.Fatalf("expected error for an unknown method")
+	}
+}
+
+func TestGrpcCaller_Call_RequestAndMetadata(t *testing.T) {
+	var chainIds []string
+	interceptor := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			chainIds = append(chainIds, md.Get("chainid")...)
+		}
+		return handler(ctx, req)
+	}
+	addr := newHealthServer(t, true, grpc.UnaryInterceptor(interceptor))
+	c := &GrpcCaller{}
+	payload := ConditionGrpcPayload{
+		Reflection: true,
+		Service:    "grpc.health.v1.Health",
+		Method:     "Check",
+		Request:    json.RawMessage(`{"service":""}`),
+		Metadata:   map[string]string{"chainId": "728126428"},
+	}
+	values, err := c.Call(context.Background(), addr, payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if values["status"] != "SERVING" {
+		t.Fatalf("expected SERVING, got %v", values)
+	}
+	if len(chainIds) == 0 || chainIds[0] != "728126428" {
+		t.Fatalf("expected the chainId metadata header, got %v", chainIds)
+	}
+
+	// the health server fails checks of services it doesn't know
+	payload.Request = json.RawMessage(`{"service":"unknown"}`)
+	if _, err = c.Call(context.Background(), addr, payload); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound for an unknown service, got %v", err)
+	}
+
+	payload.Request = json.RawMessage(`{"height":1}`)
+	if _, err = c.Call(context.Background(), addr, payload); err == nil {
+		t.Fatalf("expected error for a field the request message doesn't have")
+	}
+}
@@ -0,0 +1,96 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestGrpcValueMatchChecker_ValidCondition(t *testing.T) {
+	c := &grpcValueMatchChecker{}
+	matchers := []Matcher{{MatchType: "=", Key: "status", Value: "SERVING"}}
+	if err := c.ValidCondition(&HealthCheckCondition{Matchers: matchers}); err == nil {
+		t.Fatalf("expected error for empty payload")
+	}
+	if err := c.ValidCondition(&HealthCheckCondition{Payload: `{"service":"Health","method":"Check"}`, Matchers: matchers}); err == nil {
+		t.Fatalf("expected error without a descriptor source")
+	}
+	cond := &HealthCheckCondition{
+		Payload:  `{"reflection":true,"service":"Health","method":"Check"}`,
+		Matchers: []Matcher{{MatchType: "~=", Key: "status", Value: "SERVING"}},
+	}
+	if err := c.ValidCondition(cond); err == nil {
+		t.Fatalf("expected error for invalid matchers")
+	}
+	cond.Matchers = matchers
+	if err := c.ValidCondition(cond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestGrpcValueMatchChecker_Check(t *testing.T) {
+	c := &grpcValueMatchChecker{grpcCall: func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error) {
+		if payload.Service != "Wallet" || payload.Method != "GetNodeInfo" {
+			return nil, errors.New("unexpected method")
+		}
+		switch url {
+		case "down:50051":
+			return nil, errors.New("unavailable")
+		case "nile:50051":
+			return map[string]interface{}{"configNodeInfo": map[string]interface{}{"p2pVersion": 201910292}}, nil
+		}
+		return map[string]interface{}{"configNodeInfo": map[string]interface{}{"p2pVersion": 11111}}, nil
+	}}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_GRPC_VALUE_MATCH,
+		Payload:       `{"protosetName":"tron","service":"Wallet","method":"GetNodeInfo"}`,
+		Matchers:      []Matcher{{MatchType: "=", Key: "configNodeInfo.p2pVersion", Value: "11111"}},
+		Ignore:        []string{"ignored:50051"},
+	}
+	if err := c.ValidCondition(cond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ret, err := c.Check(context.Background(), "728126428", []string{"main:50051", "nile:50051", "down:50051", "ignored:50051"}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret["main:50051"] || ret["nile:50051"] || ret["down:50051"] || !ret["ignored:50051"] {
+		t.Fatalf("expected only the mainnet and ignored nodes to pass, got %v", ret)
+	}
+}
+
+func TestGrpcValueMatchChecker_Check_RegexKey(t *testing.T) {
+	c := &grpcValueMatchChecker{grpcCall: func(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error) {
+		version := "v0.50.3"
+		if url == "old:9090" {
+			version = "v0.46.15"
+		}
+		return map[string]interface{}{"applicationVersion": map[string]interface{}{"cosmosSdkVersion": version}}, nil
+	}}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_GRPC_VALUE_MATCH,
+		Payload:       `{"reflection":true,"service":"cosmos.base.tendermint.v1beta1.Service","method":"GetNodeInfo"}`,
+		Matchers:      []Matcher{{MatchType: ">=", Key: `applicationVersion.cosmosSdkVersion|^v0\.(\d+)`, Value: "47"}},
+	}
+	ret, err := c.Check(context.Background(), "cosmoshub-4", []string{"new:9090", "old:9090"}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret["new:9090"] || ret["old:9090"] {
+		t.Fatalf("expected only the v0.46 node to fail, got %v", ret)
+	}
+}
+
+func TestGrpcValueMatchChecker_Check_Reflection(t *testing.T) {
+	addr := newHealthServer(t, true)
+	c := &grpcValueMatchChecker{grpcCall: (&GrpcCaller{}).Call}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_GRPC_VALUE_MATCH,
+		Payload:       `{"reflection":true,"service":"Health","method":"Check","request":{"service":""}}`,
+		Matchers:      []Matcher{{MatchType: "=", Key: "status", Value: "SERVING"}},
+	}
+	ret, err := c.Check(context.Background(), "", []string{addr}, cond, CheckCaches{})
+	if err != nil || !ret[addr] {
+		t.Fatalf("expected the serving node to pass, got %v (err=%v)", ret, err)
+	}
+}
@@ -0,0 +1,145 @@
+package checker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newDelayedServer(delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		_, _ = w.Write([]byte(`{"result":"0x1"}`))
+	}))
+}
+
+func TestLatencyChecker_ValidCondition_EmptyPayload(t *testing.T) {
+	c := &latencyChecker{}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_LATENCY,
+		Matchers:      []Matcher{{MatchType: "<=", Key: "p95", Value: "800ms"}},
+	}
+	if err := c.ValidCondition(cond); err == nil {
+		t.Fatalf("expected error for empty payload, got nil")
+	}
+}
+
+func TestLatencyChecker_ValidCondition_InvalidMatchers(t *testing.T) {
+	c := &latencyChecker{}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_LATENCY,
+		Payload:       `{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`,
+		Matchers: []Matcher{
+			{MatchType: "=", Key: "p95", Value: "800ms"},
+			{MatchType: "<", Key: "p99", Value: "800ms"},
+			{MatchType: "<", Key: "p50", Value: "fast"},
+			{MatchType: "<", Key: "p50", Value: "-2x"},
+		},
+	}
+	if err := c.ValidCondition(cond); err == nil {
+		t.Fatalf("expected error for invalid matchers, got nil")
+	}
+}
+
+func TestLatencyChecker_latencyLimit(t *testing.T) {
+	d, err := latencyLimit("800ms", time.Second)
+	if err != nil || d != 800*time.Millisecond {
+		t.Fatalf("expected 800ms, got %s (err=%v)", d, err)
+	}
+	d, err = latencyLimit("2.5x", 100*time.Millisecond)
+	if err != nil || d != 250*time.Millisecond {
+		t.Fatalf("expected 250ms, got %s (err=%v)", d, err)
+	}
+}
+
+func TestLatencyChecker_percentileDuration(t *testing.T) {
+	durations := []time.Duration{5, 1, 4, 2, 3, 10, 6, 7, 8, 9}
+	if p50 := percentileDuration(durations, 0.5); p50 != 5 {
+		t.Fatalf("expected p50 5, got %d", p50)
+	}
+	if p95 := percentileDuration(durations, 0.95); p95 != 10 {
+		t.Fatalf("expected p95 10, got %d", p95)
+	}
+	if p := percentileDuration(nil, 0.5); p != 0 {
+		t.Fatalf("expected 0 for no samples, got %d", p)
+	}
+}
+
+func TestLatencyChecker_Check_AbsoluteThreshold(t *testing.T) {
+	fast := newDelayedServer(0)
+	defer fast.Close()
+	slow := newDelayedServer(150 * time.Millisecond)
+	defer slow.Close()
+
+	c := &latencyChecker{cli: &http.Client{}, reports: make(map[string]NodeReport)}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_LATENCY,
+		Payload:       `{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`,
+		Samples:       2,
+		Matchers:      []Matcher{{MatchType: "<", Key: "p95", Value: "100ms"}},
+	}
+	if err := c.ValidCondition(cond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ret, err := c.Check(context.Background(), "1", []string{fast.URL, slow.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[fast.URL] || ret[slow.URL] {
+		t.Fatalf("expected fast true and slow false, got %v", ret)
+	}
+	reports := c.Reports()
+	if reports[slow.URL].LatencyP95 < 150*time.Millisecond || reports[fast.URL].LatencyP50 == 0 {
+		t.Fatalf("expected latencies to be reported, got %v", reports)
+	}
+}
+
+func TestLatencyChecker_Check_MedianMultiple(t *testing.T) {
+	a := newDelayedServer(10 * time.Millisecond)
+	defer a.Close()
+	b := newDelayedServer(10 * time.Millisecond)
+	defer b.Close()
+	slow := newDelayedServer(200 * time.Millisecond)
+	defer slow.Close()
+
+	c := &latencyChecker{cli: &http.Client{}, reports: make(map[string]NodeReport)}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_LATENCY,
+		Payload:       `{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`,
+		Samples:       1,
+		Matchers:      []Matcher{{MatchType: "<=", Key: "p50", Value: "5x"}},
+	}
+	ret, err := c.Check(context.Background(), "1", []string{a.URL, b.URL, slow.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[a.URL] || !ret[b.URL] || ret[slow.URL] {
+		t.Fatalf("expected only the slow node to fail, got %v", ret)
+	}
+}
+
+func TestLatencyChecker_Check_CallError_ReturnsFalse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	c := &latencyChecker{cli: &http.Client{}, reports: make(map[string]NodeReport)}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_LATENCY,
+		Payload:       `{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`,
+		Matchers:      []Matcher{{MatchType: "<", Value: "1s"}},
+	}
+	ret, err := c.Check(context.Background(), "1", []string{ts.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("did not expect error from Check, got %v", err)
+	}
+	if ret[ts.URL] {
+		t.Fatalf("expected false due to call error, got %v", ret[ts.URL])
+	}
+	if _, ok := c.Reports()[ts.URL]; ok {
+		t.Fatalf("expected no report for a failed node")
+	}
+}
@@ -1,6 +1,7 @@
 package checker
 
 import (
+	"context"
 	"testing"
 )
 
@@ -44,7 +45,7 @@ func TestManualChecker_Check_EqualMatch(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{urlGood, urlBad}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{urlGood, urlBad}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -66,7 +67,7 @@ func TestManualChecker_Check_NotEqualMatch(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{urlHasBar, urlNoBar}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{urlHasBar, urlNoBar}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -93,7 +94,7 @@ func TestManualChecker_Check_MultipleMatchers_AllMustPass(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{urlPass, urlFailFoo, urlFailNotEqual}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{urlPass, urlFailFoo, urlFailNotEqual}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -121,5 +122,5 @@ func TestManualChecker_Check_InvalidRegex_Panics(t *testing.T) {
 			t.Fatalf("expected panic for invalid regex, got none")
 		}
 	}()
-	_, _ = c.Check("1", []string{"http://foo:8545"}, cond, caches)
+	_, _ = c.Check(context.Background(), "1", []string{"http://foo:8545"}, cond, caches)
 }
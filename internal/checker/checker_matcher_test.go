@@ -0,0 +1,186 @@
+package checker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMatcher_eval(t *testing.T) {
+	values := map[string]interface{}{
+		"result": map[string]interface{}{
+			"peerCount": "0x10",
+			"height":    "120",
+			"version":   "Geth/v1.14.8-stable",
+			"network":   "mainnet",
+		},
+	}
+	cases := []struct {
+		matcher Matcher
+		want    bool
+	}{
+		{Matcher{MatchType: ">", Key: "result.peerCount", Value: "5"}, true},
+		{Matcher{MatchType: "<=", Key: "result.peerCount", Value: "0xf"}, false},
+		{Matcher{MatchType: ">=", Key: "result.height", Value: "120"}, true},
+		{Matcher{MatchType: "<", Key: "result.height", Value: "0x78"}, false},
+		{Matcher{MatchType: "<", Key: "result.version", Value: "1"}, false},
+		{Matcher{MatchType: "regex", Key: "result.version", Value: "^Geth/v1.14"}, true},
+		{Matcher{MatchType: "regex", Key: "result.version", Value: "^Erigon"}, false},
+		{Matcher{MatchType: "contains", Key: "result.version", Value: "stable"}, true},
+		{Matcher{MatchType: "in", Key: "result.network", Value: "testnet, mainnet"}, true},
+		{Matcher{MatchType: "in", Key: "result.network", Value: "testnet,devnet"}, false},
+		{Matcher{MatchType: "exists", Key: "result.network"}, true},
+		{Matcher{MatchType: "exists", Key: "result.missing"}, false},
+		{Matcher{MatchType: "notExists", Key: "result.missing"}, true},
+		{Matcher{MatchType: "notExists", Key: "missing.nested"}, true},
+		{Matcher{MatchType: "=", Key: "result.network", Value: "mainnet"}, true},
+		{Matcher{MatchType: "!=", Key: "result.network", Value: "mainnet"}, false},
+	}
+	for _, tc := range cases {
+		got, val, err := tc.matcher.eval(values)
+		if err != nil {
+			t.Fatalf("unexpected error for %+v: %v", tc.matcher, err)
+		}
+		if got != tc.want {
+			t.Fatalf("expected %t for %+v, got %t (value %q)", tc.want, tc.matcher, got, val)
+		}
+	}
+}
+
+func TestMatcher_valid(t *testing.T) {
+	if err := (Matcher{MatchType: ">", Value: "abc"}).valid(); err == nil {
+		t.Fatalf("expected error for non numeric value")
+	}
+	if err := (Matcher{MatchType: "regex", Value: "("}).valid(); err == nil {
+		t.Fatalf("expected error for invalid regex")
+	}
+	if err := (Matcher{MatchType: "=", Key: "result|(", Value: "1"}).valid(); err == nil {
+		t.Fatalf("expected error for an invalid key regex")
+	}
+	if err := (Matcher{MatchType: ">=", Value: "0x1f"}).valid(); err != nil {
+		t.Fatalf("unexpected error for hex value: %v", err)
+	}
+}
+
+func TestMatchValues_Expr(t *testing.T) {
+	values := map[string]interface{}{"result": "0x2"}
+	matchers := []Matcher{
+		{MatchType: "=", Key: "result", Value: "0x1"},
+		{MatchType: ">", Key: "result", Value: "1"},
+		{MatchType: "notExists", Key: "error"},
+	}
+	cases := map[string]bool{
+		"":                  false,
+		"0 || 1":            true,
+		"0 || (1 && !2)":    false,
+		"!0 && 1 && 2":      true,
+		"(0 || 1) && 2":     true,
+		"!(0 || 1)":         false,
+		"0 || 1 && 2":       true,
+		"!0&&!!1":           true,
+		"0 && 1 || !0 && 2": true,
+	}
+	for expr, want := range cases {
+		got, _, err := matchValues(matchers, expr, values)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", expr, err)
+		}
+		if got != want {
+			t.Fatalf("expected %t for %q, got %t", want, expr, got)
+		}
+	}
+}
+
+func TestValidExpr(t *testing.T) {
+	for _, expr := range []string{"0 &&", "(0 || 1", "3", "0 1", "a"} {
+		if err := validExpr(expr, 2); err == nil {
+			t.Fatalf("expected error for %q", expr)
+		}
+	}
+	if err := validExpr("!(0 || 1)", 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValueMatchChecker_ValidCondition_Expr(t *testing.T) {
+	c := &valueMatchChecker{}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_VALUE_MATCH,
+		Matchers: []Matcher{
+			{MatchType: "=", Key: "result", Value: "0x1"},
+			{MatchType: "unknown", Key: "result", Value: "0x1"},
+		},
+		Expr: "0 || 1",
+	}
+	if err := c.ValidCondition(cond); err == nil {
+		t.Fatalf("expected error when an expression refers to an invalid matcher")
+	}
+
+	cond.Matchers[1].MatchType = "regex"
+	cond.Expr = "0 || 2"
+	if err := c.ValidCondition(cond); err == nil {
+		t.Fatalf("expected error for an out of range matcher index")
+	}
+
+	cond.Expr = "0 || 1"
+	if err := c.ValidCondition(cond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValueMatchChecker_Check_CacheHit_Numeric(t *testing.T) {
+	c := &valueMatchChecker{
+		cacheExpire:   100 * time.Millisecond,
+		JsonRpcCaller: JsonRpcCaller{},
+		cli:           &http.Client{},
+	}
+	url := "http://rpc.example/peers"
+	payload := `{"jsonrpc":"2.0","method":"net_peerCount","params":[],"id":1}`
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_VALUE_MATCH,
+		Payload:       payload,
+		Matchers:      []Matcher{{MatchType: ">", Key: "result", Value: "5"}},
+	}
+	if err := c.ValidCondition(cond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	caches := CheckCaches{}
+	req, err := cond.newRequest(context.Background(), url)
+	if err != nil {
+		t.Fatalf("unexpected error building request: %v", err)
+	}
+	if err = caches.put(req, checkCacheValue{"result": "0x3"}, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ret, err := c.Check(context.Background(), "1", []string{url}, cond, caches)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret[url] {
+		t.Fatalf("expected false for 3 peers, got %v", ret[url])
+	}
+}
+
+func TestRenderKey_Regex(t *testing.T) {
+	values := map[string]interface{}{"version": "Geth/v1.14.8-stable"}
+	cases := map[string]string{
+		"version":                "Geth/v1.14.8-stable",
+		`version|v(\d+\.\d+)`:    "1.14",
+		`version|stable`:         "stable",
+		`version|^Erigon/v(\d+)`: "",
+	}
+	for key, want := range cases {
+		got, err := renderKey(key, values)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", key, err)
+		}
+		if got != want {
+			t.Fatalf("expected %q for %q, got %q", want, key, got)
+		}
+	}
+	if _, err := renderKey("version|(", values); err == nil {
+		t.Fatalf("expected error for an invalid regex")
+	}
+}
@@ -0,0 +1,77 @@
+package checker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckConditionList_CheckResults(t *testing.T) {
+	head := newJsonServer(map[string]string{"eth_blockNumber": `{"jsonrpc":"2.0","id":1,"result":"0x64"}`})
+	defer head.Close()
+	behind := newJsonServer(map[string]string{"eth_blockNumber": `{"jsonrpc":"2.0","id":1,"result":"0x5a"}`})
+	defer behind.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	payload := `{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`
+	rules := HealthCheckConditionList{
+		{CheckStrategy: CHECK_STRATEGY_SIMPLE, Payload: payload},
+		{CheckStrategy: CHECK_STRATEGY_BLOCK_HEIGHT, Payload: payload, Matchers: []Matcher{{MatchType: "<=", Value: "5", Key: "result"}}},
+	}
+	c := New(&http.Client{}, time.Second)
+	results, err := rules.CheckResults(context.Background(), c, "1", []string{head.URL, behind.URL, down.URL}, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if r := results[head.URL]; !r.Passed || r.Strategy != CHECK_STRATEGY_BLOCK_HEIGHT || r.Height != 100 || r.Reason != "" {
+		t.Fatalf("unexpected result for the head node: %+v", r)
+	}
+	if r := results[behind.URL]; r.Passed || r.Strategy != CHECK_STRATEGY_BLOCK_HEIGHT || r.Height != 90 || !strings.Contains(r.Reason, "100 - 90 <= 5") {
+		t.Fatalf("unexpected result for the node behind: %+v", r)
+	}
+	if r := results[down.URL]; r.Passed || r.Strategy != CHECK_STRATEGY_SIMPLE || !strings.Contains(r.Reason, "code: 502") {
+		t.Fatalf("unexpected result for the unreachable node: %+v", r)
+	}
+
+	ret, err := rules.Check(context.Background(), c, "1", []string{head.URL, behind.URL, down.URL}, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[head.URL] || ret[behind.URL] || ret[down.URL] {
+		t.Fatalf("expected Check to agree with CheckResults, got %v", ret)
+	}
+}
+
+func TestHealthCheckConditionList_CheckResults_Deadline(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the request context is only canceled on disconnect once the body is read
+		_, _ = io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	rules := HealthCheckConditionList{
+		{CheckStrategy: CHECK_STRATEGY_SIMPLE, Payload: `{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results, err := rules.CheckResults(ctx, New(&http.Client{}, time.Second), "1", []string{slow.URL}, CheckCaches{})
+	if err != context.DeadlineExceeded || results != nil {
+		t.Fatalf("expected the deadline error and no results, got %v, %v", results, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the request to be canceled at the deadline, took %s", elapsed)
+	}
+}
@@ -2,8 +2,10 @@ package checker
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -54,7 +56,7 @@ func TestSimpleChecker_Check_CacheHit(t *testing.T) {
 	}
 	caches[key] = NewTimedCache(checkCacheValue{"result": "OK"}, time.Second)
 
-	ret, err := c.Check("1", []string{url}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{url}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -83,7 +85,7 @@ func TestSimpleChecker_Check_CallError_ReturnsFalse(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{ts.URL}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{ts.URL}, cond, caches)
 	if err != nil {
 		t.Fatalf("did not expect error from Check, got %v", err)
 	}
@@ -113,7 +115,7 @@ func TestSimpleChecker_Check_ValueHasError_ReturnsFalse(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{ts.URL}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{ts.URL}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -122,6 +124,27 @@ func TestSimpleChecker_Check_ValueHasError_ReturnsFalse(t *testing.T) {
 	}
 }
 
+func TestSimpleChecker_Check_RestErrorObject(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"error":{"code":"NOT_FOUND","details":[]}}`))
+	}))
+	defer ts.Close()
+
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_SIMPLE,
+		Method:        http.MethodGet,
+		Path:          "/cosmos/base/tendermint/v1beta1/blocks/latest",
+	}
+	results, err := HealthCheckConditionList{cond}.CheckResults(context.Background(), New(&http.Client{}, time.Second), "1", []string{ts.URL}, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r := results[ts.URL]; r.Passed || !strings.Contains(r.Reason, "code: NOT_FOUND") {
+		t.Fatalf("expected a rest error object to fail the node with its code, got %+v", r)
+	}
+}
+
 func TestSimpleChecker_Check_SuccessAndCachesPut(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -144,7 +167,7 @@ func TestSimpleChecker_Check_SuccessAndCachesPut(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{ts.URL}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{ts.URL}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -206,7 +229,7 @@ func TestSimpleChecker_Check_MultipleURLs_Mixed(t *testing.T) {
 		Payload:       payload,
 	}
 
-	ret, err := c.Check("1", []string{tsOK.URL, tsErr.URL, urlCache}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{tsOK.URL, tsErr.URL, urlCache}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -220,3 +243,88 @@ func TestSimpleChecker_Check_MultipleURLs_Mixed(t *testing.T) {
 		t.Fatalf("expected true for cache hit url, got %v", ret[urlCache])
 	}
 }
+
+func TestSimpleChecker_Check_GetPath(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("expected GET method, got %s", r.Method)
+		}
+		if r.URL.Path != "/status" {
+			t.Errorf("expected /status path, got %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result":{"sync_info":{"latest_block_height":"100"}}}`))
+	}))
+	defer ts.Close()
+
+	c := &simpleChecker{
+		JsonRpcCaller: JsonRpcCaller{},
+		cli:           &http.Client{},
+		cacheExpire:   100 * time.Millisecond,
+	}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_SIMPLE,
+		Method:        http.MethodGet,
+		Path:          "/status",
+	}
+	if err := c.ValidCondition(cond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ret, err := c.Check(context.Background(), "1", []string{ts.URL + "/"}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[ts.URL+"/"] {
+		t.Fatalf("expected true for GET response, got %v", ret[ts.URL+"/"])
+	}
+}
+
+func TestSimpleChecker_Check_RestHeadersAndStatusCodes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/wallet/getnowblock" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("TRON-PRO-API-KEY") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c := &simpleChecker{
+		JsonRpcCaller: JsonRpcCaller{},
+		cli:           &http.Client{},
+		cacheExpire:   100 * time.Millisecond,
+	}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_SIMPLE,
+		Method:        http.MethodPost,
+		Path:          "/wallet/getnowblock",
+		Headers:       map[string]string{"TRON-PRO-API-KEY": "secret"},
+	}
+	if err := c.ValidCondition(cond); err != nil {
+		t.Fatalf("unexpected error for a rest condition without payload: %v", err)
+	}
+	ret, err := c.Check(context.Background(), "728126428", []string{ts.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret[ts.URL] {
+		t.Fatalf("expected 204 to fail when only 200 is expected")
+	}
+
+	cond.StatusCodes = []int{http.StatusOK, http.StatusNoContent}
+	ret, err = c.Check(context.Background(), "728126428", []string{ts.URL}, cond, CheckCaches{})
+	if err != nil || !ret[ts.URL] {
+		t.Fatalf("expected 204 to pass, got %v (err=%v)", ret, err)
+	}
+
+	cond.Headers = nil
+	ret, err = c.Check(context.Background(), "728126428", []string{ts.URL}, cond, CheckCaches{})
+	if err != nil || ret[ts.URL] {
+		t.Fatalf("expected the request without api key to fail, got %v (err=%v)", ret, err)
+	}
+}
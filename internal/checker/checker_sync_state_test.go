@@ -0,0 +1,184 @@
+package checker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newJsonServer(routes map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		for key, resp := range routes {
+			if r.URL.Path == key || (key != "" && strings.Contains(string(body), key)) {
+				_, _ = w.Write([]byte(resp))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+}
+
+func TestSyncStateChecker_ValidCondition(t *testing.T) {
+	c := &syncStateChecker{}
+	if err := c.ValidCondition(&HealthCheckCondition{ChainType: CHAIN_TYPE_TRON}); err == nil {
+		t.Fatalf("expected error for unsupported chain type")
+	}
+	if err := c.ValidCondition(&HealthCheckCondition{ChainType: CHAIN_TYPE_SOLANA}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSyncStateChecker_Check_Evm(t *testing.T) {
+	synced := newJsonServer(map[string]string{"eth_syncing": `{"jsonrpc":"2.0","id":1,"result":false}`})
+	defer synced.Close()
+	syncing := newJsonServer(map[string]string{"eth_syncing": `{"jsonrpc":"2.0","id":1,"result":{"currentBlock":"0x10","highestBlock":"0x20"}}`})
+	defer syncing.Close()
+	failing := newJsonServer(map[string]string{"eth_syncing": `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"boom"}}`})
+	defer failing.Close()
+
+	c := &syncStateChecker{cli: &http.Client{}}
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_SYNC_STATE}
+	ret, err := c.Check(context.Background(), "1", []string{synced.URL, syncing.URL, failing.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[synced.URL] || ret[syncing.URL] || ret[failing.URL] {
+		t.Fatalf("expected only the synced node to pass, got %v", ret)
+	}
+}
+
+func TestSyncStateChecker_Check_CometBFT(t *testing.T) {
+	synced := newJsonServer(map[string]string{"/status": `{"result":{"sync_info":{"latest_block_height":"100","catching_up":false}}}`})
+	defer synced.Close()
+	catchingUp := newJsonServer(map[string]string{"/status": `{"result":{"sync_info":{"latest_block_height":"90","catching_up":true}}}`})
+	defer catchingUp.Close()
+
+	c := &syncStateChecker{cli: &http.Client{}}
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_SYNC_STATE, ChainType: CHAIN_TYPE_COMETBFT}
+	ret, err := c.Check(context.Background(), "chihuahua-1", []string{synced.URL, catchingUp.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[synced.URL] || ret[catchingUp.URL] {
+		t.Fatalf("expected the catching up node to fail, got %v", ret)
+	}
+}
+
+func TestSyncStateChecker_Check_Cosmos(t *testing.T) {
+	synced := newJsonServer(map[string]string{"/cosmos/base/tendermint/v1beta1/syncing": `{"syncing":false}`})
+	defer synced.Close()
+	empty := newJsonServer(map[string]string{"/cosmos/base/tendermint/v1beta1/syncing": `{}`})
+	defer empty.Close()
+
+	c := &syncStateChecker{cli: &http.Client{}}
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_SYNC_STATE, ChainType: CHAIN_TYPE_COSMOS}
+	ret, err := c.Check(context.Background(), "chihuahua-1", []string{synced.URL, empty.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[synced.URL] || ret[empty.URL] {
+		t.Fatalf("expected the node without a state to fail, got %v", ret)
+	}
+}
+
+func TestSyncStateChecker_Check_Solana(t *testing.T) {
+	healthy := newJsonServer(map[string]string{"getHealth": `{"jsonrpc":"2.0","result":"ok","id":1}`})
+	defer healthy.Close()
+	behind := newJsonServer(map[string]string{"getHealth": `{"jsonrpc":"2.0","error":{"code":-32005,"message":"Node is behind by 42 slots"},"id":1}`})
+	defer behind.Close()
+
+	c := &syncStateChecker{cli: &http.Client{}}
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_SYNC_STATE, ChainType: CHAIN_TYPE_SOLANA, Ignore: []string{"http://ignored"}}
+	ret, err := c.Check(context.Background(), "solana", []string{healthy.URL, behind.URL, "http://ignored"}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[healthy.URL] || ret[behind.URL] || !ret["http://ignored"] {
+		t.Fatalf("expected the node behind to fail, got %v", ret)
+	}
+}
+
+func TestSyncStateChecker_Check_SolanaUnexpectedResult(t *testing.T) {
+	unknown := newJsonServer(map[string]string{"getHealth": `{"jsonrpc":"2.0","result":"unknown","id":1}`})
+	defer unknown.Close()
+
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_SYNC_STATE, ChainType: CHAIN_TYPE_SOLANA}
+	results, err := HealthCheckConditionList{cond}.CheckResults(context.Background(), &syncStateChecker{cli: &http.Client{}}, "solana", []string{unknown.URL}, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r := results[unknown.URL]; r.Passed || !strings.Contains(r.Reason, "result: unknown") {
+		t.Fatalf("expected the node to fail with its result as the reason, got %+v", r)
+	}
+}
+
+func TestPeerCountChecker_ValidCondition(t *testing.T) {
+	c := &peerCountChecker{}
+	if err := c.ValidCondition(&HealthCheckCondition{}); err == nil {
+		t.Fatalf("expected error for empty minPeers")
+	}
+	if err := c.ValidCondition(&HealthCheckCondition{MinPeers: 3, ChainType: CHAIN_TYPE_SOLANA}); err == nil {
+		t.Fatalf("expected error for unsupported chain type")
+	}
+	if err := c.ValidCondition(&HealthCheckCondition{MinPeers: 3, ChainType: CHAIN_TYPE_COMETBFT}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.ValidCondition(&HealthCheckCondition{MinPeers: 3, ChainType: CHAIN_TYPE_COSMOS}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestPeerCountChecker_Check_Evm(t *testing.T) {
+	many := newJsonServer(map[string]string{"net_peerCount": `{"jsonrpc":"2.0","id":1,"result":"0x19"}`})
+	defer many.Close()
+	few := newJsonServer(map[string]string{"net_peerCount": `{"jsonrpc":"2.0","id":1,"result":"0x2"}`})
+	defer few.Close()
+
+	c := &peerCountChecker{cli: &http.Client{}}
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_PEER_COUNT, MinPeers: 5}
+	ret, err := c.Check(context.Background(), "1", []string{many.URL, few.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[many.URL] || ret[few.URL] {
+		t.Fatalf("expected the node with 2 peers to fail, got %v", ret)
+	}
+}
+
+func TestPeerCountChecker_Check_CometBFT(t *testing.T) {
+	many := newJsonServer(map[string]string{"/net_info": `{"result":{"listening":true,"n_peers":"40"}}`})
+	defer many.Close()
+	isolated := newJsonServer(map[string]string{"/net_info": `{"result":{"listening":true,"n_peers":"0"}}`})
+	defer isolated.Close()
+
+	c := &peerCountChecker{cli: &http.Client{}}
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_PEER_COUNT, ChainType: CHAIN_TYPE_COMETBFT, MinPeers: 5}
+	ret, err := c.Check(context.Background(), "chihuahua-1", []string{many.URL, isolated.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[many.URL] || ret[isolated.URL] {
+		t.Fatalf("expected the isolated node to fail, got %v", ret)
+	}
+}
+
+func TestPeerCountChecker_Check_Cosmos(t *testing.T) {
+	many := newJsonServer(map[string]string{"/net_info": `{"result":{"listening":true,"n_peers":"12"}}`})
+	defer many.Close()
+	few := newJsonServer(map[string]string{"/net_info": `{"result":{"listening":true,"n_peers":"1"}}`})
+	defer few.Close()
+
+	c := &peerCountChecker{cli: &http.Client{}}
+	cond := &HealthCheckCondition{CheckStrategy: CHECK_STRATEGY_PEER_COUNT, ChainType: CHAIN_TYPE_COSMOS, MinPeers: 5}
+	ret, err := c.Check(context.Background(), "cosmoshub-4", []string{many.URL, few.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[many.URL] || ret[few.URL] {
+		t.Fatalf("expected the node with 1 peer to fail, got %v", ret)
+	}
+}
@@ -2,6 +2,7 @@ package checker
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -24,7 +25,7 @@ func TestValueMatchChecker_Check_Ignore(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{url}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{url}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -60,7 +61,7 @@ func TestValueMatchChecker_Check_CacheHit_Success(t *testing.T) {
 	}
 	caches[key] = NewTimedCache(checkCacheValue{"result": "OK"}, time.Second)
 
-	ret, err := c.Check("1", []string{url}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{url}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -96,7 +97,7 @@ func TestValueMatchChecker_Check_CacheHit_Mismatch(t *testing.T) {
 	}
 	caches[key] = NewTimedCache(checkCacheValue{"result": "ACTUAL"}, time.Second)
 
-	ret, err := c.Check("1", []string{url}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{url}, cond, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -125,7 +126,7 @@ func TestValueMatchChecker_Check_CallError_ReturnsFalseNoError(t *testing.T) {
 	}
 	caches := CheckCaches{}
 
-	ret, err := c.Check("1", []string{ts.URL}, cond, caches)
+	ret, err := c.Check(context.Background(), "1", []string{ts.URL}, cond, caches)
 	if err != nil {
 		t.Fatalf("expected no error from Check, got: %v", err)
 	}
@@ -154,7 +155,7 @@ func TestValueMatchChecker_Check_EqualAndNotEqual(t *testing.T) {
 		Payload:       `{"jsonrpc":"2.0","method":"any","params":[],"id":1}`,
 		Matchers:      []Matcher{{MatchType: "=", Key: "result", Value: "xyz"}},
 	}
-	retEq, err := c.Check("1", []string{ts.URL}, condEq, caches)
+	retEq, err := c.Check(context.Background(), "1", []string{ts.URL}, condEq, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -167,7 +168,7 @@ func TestValueMatchChecker_Check_EqualAndNotEqual(t *testing.T) {
 		Payload:       `{"jsonrpc":"2.0","method":"any","params":[],"id":1}`,
 		Matchers:      []Matcher{{MatchType: "!=", Key: "result", Value: "xyz"}},
 	}
-	retNe, err := c.Check("1", []string{ts.URL}, condNe, caches)
+	retNe, err := c.Check(context.Background(), "1", []string{ts.URL}, condNe, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -180,7 +181,7 @@ func TestValueMatchChecker_Check_EqualAndNotEqual(t *testing.T) {
 		Payload:       `{"jsonrpc":"2.0","method":"any","params":[],"id":1}`,
 		Matchers:      []Matcher{{MatchType: "!=", Key: "result", Value: "abc"}},
 	}
-	retNeFail, err := c.Check("1", []string{ts.URL}, condNeFail, caches)
+	retNeFail, err := c.Check(context.Background(), "1", []string{ts.URL}, condNeFail, caches)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -188,3 +189,36 @@ func TestValueMatchChecker_Check_EqualAndNotEqual(t *testing.T) {
 		t.Fatalf("expected false for '!=' when value equals, got %v", retNeFail[ts.URL])
 	}
 }
+
+func TestValueMatchChecker_Check_NonObjectResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		_, _ = w.Write([]byte(`["node-a","node-b"]`))
+	}))
+	defer ts.Close()
+
+	c := &valueMatchChecker{
+		cacheExpire:   100 * time.Millisecond,
+		JsonRpcCaller: JsonRpcCaller{},
+		cli:           &http.Client{},
+	}
+	cond := &HealthCheckCondition{
+		CheckStrategy: CHECK_STRATEGY_VALUE_MATCH,
+		Method:        http.MethodGet,
+		Path:          "/peers",
+		Matchers:      []Matcher{{MatchType: "contains", Key: "result", Value: "node-b"}},
+	}
+	if err := c.ValidCondition(cond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ret, err := c.Check(context.Background(), "1", []string{ts.URL}, cond, CheckCaches{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ret[ts.URL] {
+		t.Fatalf("expected the array response to match under result, got %v", ret)
+	}
+}
@@ -0,0 +1,168 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/grpcreflect"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// descriptorCacheExpire is how long resolved services are kept before they are loaded again
+const descriptorCacheExpire = 10 * time.Minute
+
+// descriptorCaches keeps resolved services across check runs, keyed by protoset file, uploaded protoset or reflected url
+var (
+	descriptorCaches   = make(map[string]*TimedCache[[]*desc.ServiceDescriptor])
+	descriptorCachesMu sync.RWMutex
+)
+
+func cachedServices(key string) ([]*desc.ServiceDescriptor, bool) {
+	descriptorCachesMu.RLock()
+	defer descriptorCachesMu.RUnlock()
+	cache, ok := descriptorCaches[key]
+	if !ok {
+		return nil, false
+	}
+	return cache.Get()
+}
+
+func cacheServices(key string, services []*desc.ServiceDescriptor) {
+	descriptorCachesMu.Lock()
+	defer descriptorCachesMu.Unlock()
+	descriptorCaches[key] = NewTimedCache(services, descriptorCacheExpire)
+}
+
+// descriptorKey tells where the descriptors of a payload come from
+func (p ConditionGrpcPayload) descriptorKey(url string) string {
+	switch {
+	case p.Reflection:
+		return "reflection:" + url + "/" + p.Service
+	case p.ProtosetName != "":
+		return "protoset:" + p.ProtosetName
+	}
+	return "file:" + p.Protoset
+}
+
+func (p ConditionGrpcPayload) valid() error {
+	if !p.Reflection && p.Protoset == "" && p.ProtosetName == "" {
+		return errors.New("one of protoset, protosetName or reflection is required")
+	}
+	if len(p.Request) > 0 && !bytes.HasPrefix(bytes.TrimSpace(p.Request), []byte("{")) {
+		return errors.New("request must be a JSON object")
+	}
+	return nil
+}
+
+// resolveMethod finds the method of the payload, descriptors are loaded once per source and cached
+func (c *GrpcCaller) resolveMethod(ctx context.Context, cc grpc.ClientConnInterface, url string, payload ConditionGrpcPayload) (*desc.MethodDescriptor, error) {
+	key := payload.descriptorKey(url)
+	services, ok := cachedServices(key)
+	if !ok {
+		var err error
+		if services, err = c.loadServices(ctx, cc, payload); err != nil {
+			return nil, err
+		}
+		cacheServices(key, services)
+	}
+	sd := findService(services, payload.Service)
+	if sd == nil {
+		return nil, fmt.Errorf("service %s not found", payload.Service)
+	}
+	md := sd.FindMethodByName(payload.Method)
+	if md == nil {
+		return nil, fmt.Errorf("method %s not found in service %s", payload.Method, sd.GetFullyQualifiedName())
+	}
+	return md, nil
+}
+
+func (c *GrpcCaller) loadServices(ctx context.Context, cc grpc.ClientConnInterface, payload ConditionGrpcPayload) ([]*desc.ServiceDescriptor, error) {
+	if payload.Reflection {
+		return reflectServices(ctx, cc, payload.Service)
+	}
+	var (
+		data []byte
+		err  error
+	)
+	if payload.ProtosetName != "" {
+		if c.LoadProtoset == nil {
+			return nil, fmt.Errorf("protoset %s can't be loaded", payload.ProtosetName)
+		}
+		data, err = c.LoadProtoset(payload.ProtosetName)
+	} else {
+		data, err = os.ReadFile(payload.Protoset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return protosetServices(data)
+}
+
+// protosetServices returns the services of every file in a descriptor set, imports are resolved within the set
+func protosetServices(data []byte) ([]*desc.ServiceDescriptor, error) {
+	var fs descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &fs); err != nil {
+		return nil, err
+	}
+	files, err := desc.CreateFileDescriptorsFromSet(&fs)
+	if err != nil {
+		return nil, err
+	}
+	var services []*desc.ServiceDescriptor
+	for _, fd := range files {
+		services = append(services, fd.GetServices()...)
+	}
+	return services, nil
+}
+
+// reflectServices asks the server for the services matching name, their files and imports come with them
+func reflectServices(ctx context.Context, cc grpc.ClientConnInterface, name string) ([]*desc.ServiceDescriptor, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	rc := grpcreflect.NewClientAuto(ctx, cc)
+	defer rc.Reset()
+	names, err := rc.ListServices()
+	if err != nil {
+		return nil, err
+	}
+	var services []*desc.ServiceDescriptor
+	for _, n := range names {
+		if !serviceNameMatch(n, name) {
+			continue
+		}
+		sd, err := rc.ResolveService(n)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, sd)
+	}
+	return services, nil
+}
+
+// findService looks a service up by its fully qualified name, or by its short name in any package
+func findService(services []*desc.ServiceDescriptor, name string) *desc.ServiceDescriptor {
+	for _, sd := range services {
+		if sd.GetFullyQualifiedName() == name {
+			return sd
+		}
+	}
+	for _, sd := range services {
+		if serviceNameMatch(sd.GetFullyQualifiedName(), name) {
+			return sd
+		}
+	}
+	return nil
+}
+
+func serviceNameMatch(fullName, name string) bool {
+	return fullName == name || strings.HasSuffix(fullName, "."+name)
+}
@@ -0,0 +1,299 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	MATCH_TYPE_EQ         = "="
+	MATCH_TYPE_NE         = "!="
+	MATCH_TYPE_LT         = "<"
+	MATCH_TYPE_LE         = "<="
+	MATCH_TYPE_GT         = ">"
+	MATCH_TYPE_GE         = ">="
+	MATCH_TYPE_REGEX      = "regex"
+	MATCH_TYPE_CONTAINS   = "contains"
+	MATCH_TYPE_EXISTS     = "exists"
+	MATCH_TYPE_NOT_EXISTS = "notExists"
+	MATCH_TYPE_IN         = "in"
+)
+
+var valueMatchTypes = []string{
+	MATCH_TYPE_EQ, MATCH_TYPE_NE,
+	MATCH_TYPE_LT, MATCH_TYPE_LE, MATCH_TYPE_GT, MATCH_TYPE_GE,
+	MATCH_TYPE_REGEX, MATCH_TYPE_CONTAINS,
+	MATCH_TYPE_EXISTS, MATCH_TYPE_NOT_EXISTS,
+	MATCH_TYPE_IN,
+}
+
+// valid checks the value of a matcher can be used with its match type
+func (m Matcher) valid() error {
+	if _, pattern, ok := strings.Cut(m.Key, "|"); ok {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return err
+		}
+	}
+	switch m.MatchType {
+	case MATCH_TYPE_LT, MATCH_TYPE_LE, MATCH_TYPE_GT, MATCH_TYPE_GE:
+		if _, err := parseNumber(m.Value); err != nil {
+			return err
+		}
+	case MATCH_TYPE_REGEX:
+		if _, err := regexp.Compile(m.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderKey renders a key from values. A key may end with |regex to extract part of the value,
+// the first capture group or else the whole match, e.g. "version|^v(\d+)". No match renders empty.
+func renderKey(key string, values map[string]interface{}) (string, error) {
+	path, pattern, extract := strings.Cut(key, "|")
+	val, err := TextTemplate(fmt.Sprintf("{{.%s}}", path)).Parse(values)
+	if err != nil || !extract {
+		return val, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return val, err
+	}
+	match := re.FindStringSubmatch(val)
+	switch len(match) {
+	case 0:
+		return "", nil
+	case 1:
+		return match[0], nil
+	}
+	return match[1], nil
+}
+
+// eval renders the matcher's key from values and compares it, the rendered value is returned for logging
+func (m Matcher) eval(values map[string]interface{}) (bool, string, error) {
+	val, err := renderKey(m.Key, values)
+	exists := err == nil && val != "" && val != "<no value>"
+	switch m.MatchType {
+	case MATCH_TYPE_EXISTS:
+		return exists, val, nil
+	case MATCH_TYPE_NOT_EXISTS:
+		return !exists, val, nil
+	}
+	if err != nil {
+		return false, val, err
+	}
+
+	switch m.MatchType {
+	case MATCH_TYPE_EQ:
+		return val == m.Value, val, nil
+	case MATCH_TYPE_NE:
+		return val != m.Value, val, nil
+	case MATCH_TYPE_CONTAINS:
+		return strings.Contains(val, m.Value), val, nil
+	case MATCH_TYPE_IN:
+		for _, item := range strings.Split(m.Value, ",") {
+			if strings.TrimSpace(item) == val {
+				return true, val, nil
+			}
+		}
+		return false, val, nil
+	case MATCH_TYPE_REGEX:
+		re, err := regexp.Compile(m.Value)
+		if err != nil {
+			return false, val, err
+		}
+		return re.MatchString(val), val, nil
+	case MATCH_TYPE_LT, MATCH_TYPE_LE, MATCH_TYPE_GT, MATCH_TYPE_GE:
+		if !exists {
+			return false, val, nil
+		}
+		actual, err := parseNumber(val)
+		if err != nil {
+			// a value that is not a number never satisfies a numeric comparison
+			return false, val, nil
+		}
+		expected, err := parseNumber(m.Value)
+		if err != nil {
+			return false, val, err
+		}
+		cmp := actual.Cmp(expected)
+		switch m.MatchType {
+		case MATCH_TYPE_LT:
+			return cmp < 0, val, nil
+		case MATCH_TYPE_LE:
+			return cmp <= 0, val, nil
+		case MATCH_TYPE_GT:
+			return cmp > 0, val, nil
+		default:
+			return cmp >= 0, val, nil
+		}
+	}
+	return false, val, fmt.Errorf("match type %s not supported", m.MatchType)
+}
+
+// parseNumber parses a decimal or 0x prefixed hex number
+func parseNumber(val string) (*big.Float, error) {
+	val = strings.TrimSpace(val)
+	if hex, ok := strings.CutPrefix(val, "0x"); ok {
+		i, ok := new(big.Int).SetString(hex, 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid hex number: %s", val)
+		}
+		return new(big.Float).SetInt(i), nil
+	}
+	f, ok := new(big.Float).SetString(val)
+	if !ok {
+		return nil, fmt.Errorf("invalid number: %s", val)
+	}
+	return f, nil
+}
+
+// matchValues evaluates the matchers against values. Without an expression every matcher must match,
+// otherwise the expression combines matcher indexes with &&, ||, ! and parentheses, e.g. "0 && (1 || !2)".
+// The details of the evaluated matchers are returned for logging.
+func matchValues(matchers []Matcher, expr string, values map[string]interface{}) (bool, []string, error) {
+	results := make([]bool, len(matchers))
+	details := make([]string, 0, len(matchers))
+	for i, matcher := range matchers {
+		ok, val, err := matcher.eval(values)
+		if err != nil {
+			return false, details, err
+		}
+		results[i] = ok
+		details = append(details, fmt.Sprintf("%s %s %s: %t", val, matcher.MatchType, matcher.Value, ok))
+		if !ok && expr == "" {
+			return false, details, nil
+		}
+	}
+	if expr == "" {
+		return true, details, nil
+	}
+	p := &exprParser{tokens: tokenizeExpr(expr), results: results}
+	ok, err := p.parse()
+	return ok, details, err
+}
+
+type exprParser struct {
+	tokens  []string
+	pos     int
+	results []bool
+}
+
+func (p *exprParser) parse() (bool, error) {
+	ok, err := p.or()
+	if err != nil {
+		return false, err
+	}
+	if p.pos != len(p.tokens) {
+		return false, fmt.Errorf("unexpected %s in matcher expression", p.tokens[p.pos])
+	}
+	return ok, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) or() (bool, error) {
+	ret, err := p.and()
+	if err != nil {
+		return false, err
+	}
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return false, err
+		}
+		ret = ret || right
+	}
+	return ret, nil
+}
+
+func (p *exprParser) and() (bool, error) {
+	ret, err := p.unary()
+	if err != nil {
+		return false, err
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return false, err
+		}
+		ret = ret && right
+	}
+	return ret, nil
+}
+
+func (p *exprParser) unary() (bool, error) {
+	token := p.peek()
+	switch token {
+	case "":
+		return false, errors.New("unexpected end of matcher expression")
+	case "!":
+		p.pos++
+		ret, err := p.unary()
+		return !ret, err
+	case "(":
+		p.pos++
+		ret, err := p.or()
+		if err != nil {
+			return false, err
+		}
+		if p.peek() != ")" {
+			return false, errors.New("missing ) in matcher expression")
+		}
+		p.pos++
+		return ret, nil
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || idx >= len(p.results) {
+		return false, fmt.Errorf("invalid matcher index %s in matcher expression", token)
+	}
+	p.pos++
+	return p.results[idx], nil
+}
+
+func tokenizeExpr(expr string) []string {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		r := rune(expr[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(expr) && unicode.IsDigit(rune(expr[j])) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		default:
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		}
+	}
+	return tokens
+}
+
+// validExpr checks an expression parses and only references existing matchers
+func validExpr(expr string, matchers int) error {
+	if expr == "" {
+		return nil
+	}
+	p := &exprParser{tokens: tokenizeExpr(expr), results: make([]bool, matchers)}
+	_, err := p.parse()
+	return err
+}
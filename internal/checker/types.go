@@ -10,22 +10,19 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/avast/retry-go/v4"
 	"github.com/golang/protobuf/jsonpb"
-	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
 	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
 	"github.com/samber/lo"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/grpc/metadata"
 )
 
 type checkStrategy string
@@ -36,6 +33,32 @@ const (
 	CHECK_STRATEGY_GRPC_BLOCK_HEIGHT checkStrategy = "GrpcBlockHeight"
 	CHECK_STRATEGY_SIMPLE            checkStrategy = "Simple"
 	CHECK_STRATEGY_MANUAL            checkStrategy = "Manual"
+	CHECK_STRATEGY_LATENCY           checkStrategy = "Latency"
+	CHECK_STRATEGY_CHAIN_IDENTITY    checkStrategy = "ChainIdentity"
+	CHECK_STRATEGY_SYNC_STATE        checkStrategy = "SyncState"
+	CHECK_STRATEGY_PEER_COUNT        checkStrategy = "PeerCount"
+	CHECK_STRATEGY_CAPABILITY        checkStrategy = "Capability"
+	CHECK_STRATEGY_GRPC_VALUE_MATCH  checkStrategy = "GrpcValueMatch"
+	CHECK_STRATEGY_CONSENSUS         checkStrategy = "Consensus"
+	CHECK_STRATEGY_FRESHNESS         checkStrategy = "Freshness"
+)
+
+// capability tags reported by the Capability strategy, logs: and state: tags carry a block count, e.g. logs:2000
+const (
+	CAPABILITY_ARCHIVE = "archive"
+	CAPABILITY_STATE   = "state"
+	CAPABILITY_TRACE   = "trace"
+	CAPABILITY_DEBUG   = "debug"
+	CAPABILITY_LOGS    = "logs"
+	CAPABILITY_BATCH   = "batch"
+)
+
+const (
+	CHAIN_TYPE_EVM      = "evm"
+	CHAIN_TYPE_TRON     = "tron"
+	CHAIN_TYPE_COMETBFT = "cometbft"
+	CHAIN_TYPE_COSMOS   = "cosmos"
+	CHAIN_TYPE_SOLANA   = "solana"
 )
 
 type HealthCheckCondition struct {
@@ -43,30 +66,171 @@ type HealthCheckCondition struct {
 	CheckStrategy checkStrategy `json:"checkStrategy"`
 	Payload       string        `json:"payload,omitempty"`
 	Matchers      []Matcher     `json:"matchers"`
+	// Method and Path target plain http endpoints such as cosmos rest, cometbft or tron, e.g. GET /status.
+	// The payload is optional once a method is set.
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+	// Headers are added to the check request, e.g. an api key of a rest endpoint
+	Headers map[string]string `json:"headers,omitempty"`
+	// StatusCodes are the response codes a node may answer with, 200 when empty
+	StatusCodes []int `json:"statusCodes,omitempty"`
+	// Samples is how many times the Latency strategy sends the payload
+	Samples int `json:"samples,omitempty"`
+	// Expr combines the matchers of ValueMatch by index, e.g. "0 && (1 || !2)", all must match when empty
+	Expr string `json:"expr,omitempty"`
+	// ChainType tells ChainIdentity, SyncState, PeerCount, Consensus and Freshness how to ask a node: evm (default), tron, cometbft, cosmos or solana
+	ChainType string `json:"chainType,omitempty"`
+	// MinPeers is the fewest peers PeerCount accepts
+	MinPeers int `json:"minPeers,omitempty"`
+	// Capabilities limits the probes of the Capability strategy: archive, trace, debug, logs and batch, all when empty
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Quorum is the share of answering nodes that must agree on a block hash for Consensus, a majority when empty
+	Quorum float64 `json:"quorum,omitempty"`
+	// MaxAge is how old the latest block may be for Freshness, e.g. 1m, set by the chain's block time
+	MaxAge string `json:"maxAge,omitempty"`
+
+	notes *conditionNotes
+}
+
+// conditionNotes keeps what a run of a condition observed on each node, for CheckResults
+type conditionNotes struct {
+	mu    sync.Mutex
+	nodes map[string]*NodeResult
+}
+
+func (n *conditionNotes) node(url string) *NodeResult {
+	ret, ok := n.nodes[url]
+	if !ok {
+		ret = &NodeResult{}
+		n.nodes[url] = ret
+	}
+	return ret
+}
+
+// fail logs why a node failed the condition and keeps the reason for CheckResults
+func (c *HealthCheckCondition) fail(url, format string, args ...any) {
+	reason := fmt.Sprintf(format, args...)
+	log.Printf("checkStrategy: %s, %s\n", c.CheckStrategy, reason)
+	if c.notes == nil {
+		return
+	}
+	c.notes.mu.Lock()
+	c.notes.node(url).Reason = reason
+	c.notes.mu.Unlock()
+}
+
+// observe keeps the height and latency measured on a node, zero values are ignored
+func (c *HealthCheckCondition) observe(url string, height int64, latency time.Duration) {
+	if c.notes == nil {
+		return
+	}
+	c.notes.mu.Lock()
+	defer c.notes.mu.Unlock()
+	node := c.notes.node(url)
+	if height > 0 {
+		node.Height = height
+	}
+	if latency > 0 {
+		node.Latency = latency
+	}
 }
 
 func (c *HealthCheckCondition) ignore(url string) bool {
 	return lo.Contains(c.Ignore, url)
 }
 
+// needsPayload tells json-rpc conditions, which post their payload, from plain http ones
+func (c *HealthCheckCondition) needsPayload() bool {
+	return c.Payload == "" && c.Method == ""
+}
+
+// newRequest builds the check request, the payload is posted to the url unless the condition says otherwise
+func (c *HealthCheckCondition) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	if c.Path != "" {
+		url = strings.TrimRight(url, "/") + c.Path
+	}
+	method := http.MethodPost
+	if c.Method != "" {
+		method = strings.ToUpper(c.Method)
+	}
+	var body io.Reader
+	if c.Payload != "" && method != http.MethodGet && method != http.MethodHead {
+		body = strings.NewReader(c.Payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
 type HealthCheckConditionList []*HealthCheckCondition
 
-func (cl HealthCheckConditionList) Check(checker HealthChecker, chainId string, urls []string, caches CheckCaches) (map[string]bool, error) {
-	ret := make(map[string]bool, len(urls))
+func (cl HealthCheckConditionList) Check(ctx context.Context, checker HealthChecker, chainId string, urls []string, caches CheckCaches) (map[string]bool, error) {
+	results, err := cl.CheckResults(ctx, checker, chainId, urls, caches)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]bool, len(results))
+	for url, result := range results {
+		ret[url] = result.Passed
+	}
+	return ret, nil
+}
+
+// NodeResult is the outcome of a rule's conditions on a node
+type NodeResult struct {
+	// Strategy is the strategy that failed the node, or the last one checked when it passed
+	Strategy checkStrategy
+	Passed   bool
+	Reason   string
+	Height   int64
+	Latency  time.Duration
+}
+
+// CheckResults runs the conditions one after another, a node failing a condition is not checked by the next ones.
+// The requests to the nodes are bound to ctx, once it is done the run stops with its error: a canceled request doesn't fail a node
+func (cl HealthCheckConditionList) CheckResults(ctx context.Context, checker HealthChecker, chainId string, urls []string, caches CheckCaches) (map[string]NodeResult, error) {
+	ret := make(map[string]NodeResult, len(urls))
 	for _, condition := range cl {
 		urls = lo.Filter(urls, func(u string, _ int) bool {
-			if valid, ok := ret[u]; ok {
-				return valid
+			if result, ok := ret[u]; ok {
+				return result.Passed
 			}
 			return true
 		})
-		curRet, err := checker.Check(chainId, urls, condition, caches)
+		condition.notes = &conditionNotes{nodes: make(map[string]*NodeResult)}
+		curRet, err := checker.Check(ctx, chainId, urls, condition, caches)
 		if err != nil {
 			return nil, err
 		}
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
 		// sync result
 		for k, valid := range curRet {
-			ret[k] = valid
+			result := ret[k]
+			result.Strategy = condition.CheckStrategy
+			result.Passed = valid
+			if note, ok := condition.notes.nodes[k]; ok {
+				result.Reason = note.Reason
+				if note.Height > 0 {
+					result.Height = note.Height
+				}
+				if note.Latency > 0 {
+					result.Latency = note.Latency
+				}
+			}
+			if !valid && result.Reason == "" {
+				result.Reason = fmt.Sprintf("failed %s check", condition.CheckStrategy)
+			}
+			ret[k] = result
 		}
 	}
 	return ret, nil
@@ -79,10 +243,23 @@ type Matcher struct {
 }
 
 type HealthChecker interface {
-	Check(chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error)
+	Check(ctx context.Context, chainId string, urls []string, condition *HealthCheckCondition, caches CheckCaches) (map[string]bool, error)
 	ValidCondition(condition *HealthCheckCondition) error
 }
 
+// NodeReport holds what the checks of a run measured on a node
+type NodeReport struct {
+	LatencyP50 time.Duration
+	LatencyP95 time.Duration
+	// Tags are the capabilities found by the Capability strategy, nil when the node was not probed
+	Tags []string
+}
+
+// Reporter is implemented by checkers that keep measurements of the nodes they checked
+type Reporter interface {
+	Reports() map[string]NodeReport
+}
+
 type checkCacheValue map[string]interface{}
 
 type CheckCaches map[string]*TimedCache[checkCacheValue]
@@ -107,6 +284,9 @@ func (cc CheckCaches) match(req *http.Request) (*TimedCache[checkCacheValue], er
 }
 
 func (cc CheckCaches) makeKey(req *http.Request) (string, error) {
+	if req.GetBody == nil {
+		return req.Method + " " + req.URL.String(), nil
+	}
 	body, err := req.GetBody()
 	if err != nil {
 		return "", err
@@ -140,8 +320,12 @@ func (cc CheckCaches) put(req *http.Request, value checkCacheValue, expire time.
 type JsonRpcCaller struct {
 }
 
-func (c *JsonRpcCaller) Call(cli *http.Client, req *http.Request) (map[string]interface{}, error) {
-	req.Header.Set("Content-Type", "application/json")
+// Call sends the request and decodes the JSON response. A response that is not an object, such as an array or a number,
+// is returned under the result key. Any of statusCodes is accepted, 200 when none is given.
+func (c *JsonRpcCaller) Call(cli *http.Client, req *http.Request, statusCodes ...int) (map[string]interface{}, error) {
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	var resp *http.Response
 	resp, err := cli.Do(req)
@@ -149,14 +333,50 @@ func (c *JsonRpcCaller) Call(cli *http.Client, req *http.Request) (map[string]in
 		log.Printf("fail to call, url: %s, err: %s\n", req.URL.String(), err.Error())
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
+	defer resp.Body.Close()
+	if len(statusCodes) == 0 {
+		statusCodes = []int{http.StatusOK}
+	}
+	if !lo.Contains(statusCodes, resp.StatusCode) {
 		log.Printf("unexpected status code, url: %s , code: %d\n", req.URL.String(), resp.StatusCode)
 		return nil, fmt.Errorf("unexpected status code, url: %s , code: %d", req.URL.String(), resp.StatusCode)
 	}
-	defer resp.Body.Close()
 
-	var ret map[string]interface{}
-	return ret, json.NewDecoder(resp.Body).Decode(&ret)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		// e.g. 204 No Content, the status code was all there was to check
+		return map[string]interface{}{}, nil
+	}
+	var ret interface{}
+	if err = json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+	switch v := ret.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return v, nil
+	}
+	return map[string]interface{}{"result": ret}, nil
+}
+
+// CallInto posts the request and decodes the response into out, for strategies that parse a known response shape
+func (c *JsonRpcCaller) CallInto(cli *http.Client, req *http.Request, out any) error {
+	if req.Method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code, url: %s , code: %d", req.URL.String(), resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
 }
 
 type TimedCache[T any] struct {
@@ -217,15 +437,28 @@ type checkResult struct {
 	err   error
 }
 
+type latencyResult struct {
+	url     string
+	p50     time.Duration
+	p95     time.Duration
+	ignored bool
+	failed  bool
+	err     error
+}
+
 type heightResult struct {
 	url    string
 	height int64
 	err    error
 }
 
-type GrpcCaller struct{}
+type GrpcCaller struct {
+	// LoadProtoset reads a descriptor set uploaded to the protoset collection
+	LoadProtoset func(name string) ([]byte, error)
+}
 
-func (c *GrpcCaller) Call(url, protoset, service, method string) (map[string]interface{}, error) {
+// Call invokes the payload's method on url, retried up to 3 times while ctx is not done
+func (c *GrpcCaller) Call(ctx context.Context, url string, payload ConditionGrpcPayload) (map[string]interface{}, error) {
 	var creds grpc.DialOption
 	if strings.Contains(url, "443") {
 		creds = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
@@ -237,22 +470,22 @@ func (c *GrpcCaller) Call(url, protoset, service, method string) (map[string]int
 		return nil, err
 	}
 	defer cc.Close()
-	data, err := os.ReadFile(protoset)
+	md, err := c.resolveMethod(ctx, cc, url, payload)
 	if err != nil {
 		return nil, err
 	}
-
-	var fs descriptorpb.FileDescriptorSet
-	if err = proto.Unmarshal(data, &fs); err != nil {
-		return nil, err
-	}
-
-	fd, _ := desc.CreateFileDescriptorFromSet(&fs)
-	md := fd.FindService(fmt.Sprintf("%s.%s", fd.GetPackage(), service)).FindMethodByName(method)
 	req := dynamic.NewMessage(md.GetInputType())
+	if len(payload.Request) > 0 {
+		if err = (&jsonpb.Unmarshaler{}).Unmarshal(bytes.NewReader(payload.Request), req); err != nil {
+			return nil, fmt.Errorf("invalid request for %s: %w", md.GetFullyQualifiedName(), err)
+		}
+	}
+	if len(payload.Metadata) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(payload.Metadata))
+	}
 	stub := grpcdynamic.NewStub(cc)
 	return retry.DoWithData(func() (map[string]interface{}, error) {
-		reply, err := stub.InvokeRpc(context.Background(), md, req)
+		reply, err := stub.InvokeRpc(ctx, md, req)
 		if err != nil {
 			return nil, err
 		}
@@ -260,5 +493,5 @@ func (c *GrpcCaller) Call(url, protoset, service, method string) (map[string]int
 		str, _ := marshaller.MarshalToString(reply)
 		var ret map[string]interface{}
 		return ret, json.Unmarshal([]byte(str), &ret)
-	}, retry.Attempts(3), retry.Delay(500*time.Millisecond))
+	}, retry.Attempts(3), retry.Delay(500*time.Millisecond), retry.Context(ctx))
 }
@@ -2,6 +2,8 @@ package client
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -93,6 +95,12 @@ type SecretKey struct {
 	RouteRules   string `json:"route_rules"`
 }
 
+// AccessKeyHash is the hex SHA-256 of an access key, stored where the key itself must not be, such as usage
+func AccessKeyHash(accessKey string) string {
+	sum := sha256.Sum256([]byte(accessKey))
+	return hex.EncodeToString(sum[:])
+}
+
 func (cgc *ChainGatewayClient) PostSecretKey(sk *SecretKey) error {
 	urlStr := fmt.Sprintf("%s/%s/%s", cgc.RootPath, ADMIN_PATH, "secret")
 
@@ -152,14 +160,28 @@ func (cgc *ChainGatewayClient) PostConfig(c *Config) error {
 type Protocol string
 
 const (
-	PROTOCOL_JSONRPC Protocol = "jsonrpc"
-	PROTOCOL_GRPC    Protocol = "grpc"
+	PROTOCOL_JSONRPC  Protocol = "jsonrpc"
+	PROTOCOL_GRPC     Protocol = "grpc"
+	PROTOCOL_REST     Protocol = "rest"
+	PROTOCOL_COMETBFT Protocol = "cometbft"
 )
 
 type CheckRule struct {
+	Id       string                           `json:"id,omitempty"`
 	ChainId  string                           `json:"chain_id"`
 	Source   string                           `json:"source"`
 	Rules    checker.HealthCheckConditionList `json:"rules"`
 	Protocol Protocol                         `json:"protocol,omitempty"`
 	Disabled bool                             `json:"disabled,omitempty"`
+	// FailThreshold and PassThreshold are the consecutive failures that remove a ready node
+	// and the consecutive passes that restore it, 1 when unset
+	FailThreshold int `json:"fail_threshold,omitempty"`
+	PassThreshold int `json:"pass_threshold,omitempty"`
+	// MinReady is the smallest ready list, 1 when unset, and MaxShrink the largest share
+	// of the ready list in percent one run may remove, unlimited when unset
+	MinReady  int     `json:"min_ready,omitempty"`
+	MaxShrink float64 `json:"max_shrink,omitempty"`
+	// Interval is how often the rule runs, 1m when unset, and Timeout how long a run may take, 2m when unset
+	Interval string `json:"interval,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
 }
@@ -118,6 +118,10 @@ func (c *ConfigCol) Apply(app core.App, cli *client.ChainGatewayClient) {
 type HealthCheckConfig struct {
 	Grpc    bool `json:"grpc"`
 	Jsonrpc bool `json:"jsonrpc"`
+	// ResultRetention is how long check_result records are kept, e.g. 72h, 7 days when empty
+	ResultRetention string `json:"resultRetention,omitempty"`
+	// Workers is how many check rules run at once, 4 when unset, read when the dashboard starts
+	Workers int `json:"workers,omitempty"`
 }
 
 func (c *ConfigCol) configHealthCheck(app core.App, conf *HealthCheckConfig) error {
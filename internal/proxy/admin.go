@@ -0,0 +1,397 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// recentOutcomes is the window the error rate of a node is computed over
+	recentOutcomes       = 100
+	defaultEjectDuration = 10 * time.Minute
+)
+
+type nodeStats struct {
+	inflight int64
+	requests int64
+	outcomes [recentOutcomes]bool
+	next     int
+	count    int
+}
+
+func (s *nodeStats) errorRate() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	var failed int
+	for i := range s.count {
+		if s.outcomes[i] {
+			failed++
+		}
+	}
+	return float64(failed) / float64(s.count)
+}
+
+// nodeMonitor tracks outstanding requests, recent errors, circuit breakers and manual ejections of upstream nodes
+type nodeMonitor struct {
+	mu            sync.Mutex
+	logger        *zap.Logger
+	breakerPolicy *BreakerPolicy
+	nodes         map[string]*nodeStats
+	breakers      map[string]*circuitBreaker
+	transitions   map[breakerTransition]int64
+	ejected       map[string]time.Time
+	lastRefresh   time.Time
+	refreshErr    string
+}
+
+func newNodeMonitor(breakerPolicy *BreakerPolicy, logger *zap.Logger) *nodeMonitor {
+	return &nodeMonitor{
+		logger:        logger,
+		breakerPolicy: breakerPolicy,
+		nodes:         make(map[string]*nodeStats),
+		breakers:      make(map[string]*circuitBreaker),
+		transitions:   make(map[breakerTransition]int64),
+		ejected:       make(map[string]time.Time),
+	}
+}
+
+func (m *nodeMonitor) stats(url string) *nodeStats {
+	s, ok := m.nodes[url]
+	if !ok {
+		s = &nodeStats{}
+		m.nodes[url] = s
+	}
+	return s
+}
+
+// errNodeUnavailable is returned when a node can't take a call, e.g. its half-open breaker has no probe left
+var errNodeUnavailable = errors.New("node unavailable, circuit breaker is probing")
+
+// begin starts a request on url and returns its start time.
+// It fails when the breaker of url is open or half-open with every probe taken, the check and the
+// reservation happen under one lock so concurrent calls can't exceed the probe limit
+func (m *nodeMonitor) begin(url string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.reserveProbe(url) {
+		return time.Time{}, errNodeUnavailable
+	}
+	s := m.stats(url)
+	s.inflight++
+	s.requests++
+	return time.Now(), nil
+}
+
+// end finishes a request begun on url, requests canceled by the client or a hedge don't count as outcomes
+func (m *nodeMonitor) end(ctx context.Context, url string, failed bool, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stats(url)
+	s.inflight--
+	m.releaseProbe(url)
+	if ctx.Err() != nil {
+		return
+	}
+	s.outcomes[s.next] = failed
+	s.next = (s.next + 1) % recentOutcomes
+	if s.count < recentOutcomes {
+		s.count++
+	}
+	m.recordBreaker(url, failed, latency)
+}
+
+func (m *nodeMonitor) eject(url string, d time.Duration) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	until := time.Now().Add(d)
+	m.ejected[url] = until
+	return until
+}
+
+func (m *nodeMonitor) restore(url string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.ejected[url]
+	delete(m.ejected, url)
+	return ok
+}
+
+// available reports whether url may be selected, it is neither ejected nor behind an open breaker
+func (m *nodeMonitor) available(url string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.ejectedLocked(url) && m.breakerAllows(url)
+}
+
+func (m *nodeMonitor) ejectedLocked(url string) bool {
+	until, ok := m.ejected[url]
+	if ok && time.Now().After(until) {
+		delete(m.ejected, url)
+		return false
+	}
+	return ok
+}
+
+// filter drops the unavailable urls, the input slice is left untouched
+func (m *nodeMonitor) filter(urls []string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.ejected) == 0 && len(m.breakers) == 0 {
+		return urls
+	}
+	ret := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if !m.ejectedLocked(url) && m.breakerAllows(url) {
+			ret = append(ret, url)
+		}
+	}
+	return ret
+}
+
+func (m *nodeMonitor) refreshed(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.refreshErr = err.Error()
+		return
+	}
+	m.lastRefresh = time.Now()
+	m.refreshErr = ""
+}
+
+type adminNodeStatus struct {
+	Url          string     `json:"url"`
+	Source       string     `json:"source,omitempty"`
+	State        string     `json:"state,omitempty"`
+	Inflight     int64      `json:"inflight"`
+	Requests     int64      `json:"requests"`
+	ErrorRate    float64    `json:"errorRate"`
+	Breaker      string     `json:"breaker"`
+	Height       int64      `json:"height,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	Ejected      bool       `json:"ejected"`
+	EjectedUntil *time.Time `json:"ejectedUntil,omitempty"`
+}
+
+type adminChainStatus struct {
+	ChainId string            `json:"chainId"`
+	Nodes   []adminNodeStatus `json:"nodes"`
+}
+
+type adminStatus struct {
+	Protocol     string             `json:"protocol"`
+	LastRefresh  time.Time          `json:"lastRefresh"`
+	RefreshError string             `json:"refreshError,omitempty"`
+	CachedKeys   int                `json:"cachedKeys"`
+	Chains       []adminChainStatus `json:"chains"`
+}
+
+// adminPool lists a node of a chain pool, state is the connection state for protocols that keep one
+type adminPool struct {
+	chainId string
+	source  string
+	url     string
+	state   string
+}
+
+// adminServer serves the operator api of a proxy on its own port
+type adminServer struct {
+	protocol   string
+	token      string
+	logger     *zap.Logger
+	nodes      *nodeMonitor
+	secretKeys *secretKeyStore
+	pools      func() []adminPool
+	refresh    func()
+	// heights reports the known block height of a node, nil when the proxy does not track heights
+	heights func(url string) (int64, bool)
+	// tags reports the capability tags of a node, nil when the proxy does not route by capability
+	tags func(url string) []string
+}
+
+// start listens on addr, a listen error is sent to errC.
+// Without a token only a loopback address is accepted, anyone reaching the port could eject nodes
+func (a *adminServer) start(addr string, errC chan<- error) (*http.Server, error) {
+	if a.token == "" && !isLoopbackAddr(addr) {
+		return nil, fmt.Errorf("admin api on %s requires --admin-token, only loopback addresses may go without one", addr)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/status", a.auth(a.handleStatus))
+	mux.HandleFunc("POST /admin/refresh", a.auth(a.handleRefresh))
+	mux.HandleFunc("POST /admin/eject", a.auth(a.handleEject))
+	mux.HandleFunc("POST /admin/restore", a.auth(a.handleRestore))
+	mux.HandleFunc("POST /admin/keys/flush", a.auth(a.handleFlushKeys))
+	mux.HandleFunc("GET /metrics", a.auth(a.handleMetrics))
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	go func() {
+		a.logger.Info("admin listening on", zap.String("address", addr))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errC <- err
+		}
+	}()
+	return srv, nil
+}
+
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// resolveUrl returns the pool url an operator refers to, by the url itself or by its desensitized form from the status
+func (a *adminServer) resolveUrl(url string) string {
+	for _, pool := range a.pools() {
+		if pool.url == url || desensitize(pool.url) == url {
+			return pool.url
+		}
+	}
+	return url
+}
+
+func (a *adminServer) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if a.token != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+a.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, req)
+	}
+}
+
+func (a *adminServer) status() *adminStatus {
+	pools := a.pools()
+	a.nodes.mu.Lock()
+	defer a.nodes.mu.Unlock()
+
+	st := &adminStatus{
+		Protocol:     a.protocol,
+		LastRefresh:  a.nodes.lastRefresh,
+		RefreshError: a.nodes.refreshErr,
+		CachedKeys:   a.secretKeys.len(),
+		Chains:       []adminChainStatus{},
+	}
+	chains := make(map[string]*adminChainStatus)
+	for _, pool := range pools {
+		chain, ok := chains[pool.chainId]
+		if !ok {
+			chain = &adminChainStatus{ChainId: pool.chainId}
+			chains[pool.chainId] = chain
+		}
+		node := adminNodeStatus{
+			Url:     desensitize(pool.url),
+			Source:  pool.source,
+			State:   pool.state,
+			Breaker: a.nodes.breakerState(pool.url).String(),
+		}
+		if a.heights != nil {
+			node.Height, _ = a.heights(pool.url)
+		}
+		if a.tags != nil {
+			node.Tags = a.tags(pool.url)
+		}
+		if s, ok := a.nodes.nodes[pool.url]; ok {
+			node.Inflight = s.inflight
+			node.Requests = s.requests
+			node.ErrorRate = s.errorRate()
+		}
+		if a.nodes.ejectedLocked(pool.url) {
+			until := a.nodes.ejected[pool.url]
+			node.Ejected = true
+			node.EjectedUntil = &until
+		}
+		chain.Nodes = append(chain.Nodes, node)
+	}
+	for _, chain := range chains {
+		sort.Slice(chain.Nodes, func(i, j int) bool {
+			if chain.Nodes[i].Source != chain.Nodes[j].Source {
+				return chain.Nodes[i].Source < chain.Nodes[j].Source
+			}
+			return chain.Nodes[i].Url < chain.Nodes[j].Url
+		})
+		st.Chains = append(st.Chains, *chain)
+	}
+	sort.Slice(st.Chains, func(i, j int) bool {
+		return st.Chains[i].ChainId < st.Chains[j].ChainId
+	})
+	return st
+}
+
+func (a *adminServer) handleStatus(w http.ResponseWriter, req *http.Request) {
+	writeJson(w, a.status())
+}
+
+func (a *adminServer) handleRefresh(w http.ResponseWriter, req *http.Request) {
+	a.refresh()
+	writeJson(w, a.status())
+}
+
+// handleEject takes a node out of rotation for duration (10m by default), e.g. POST /admin/eject?url=...&duration=1h
+func (a *adminServer) handleEject(w http.ResponseWriter, req *http.Request) {
+	url := req.URL.Query().Get("url")
+	if url == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+	d := defaultEjectDuration
+	if v := req.URL.Query().Get("duration"); v != "" {
+		var err error
+		if d, err = time.ParseDuration(v); err != nil || d <= 0 {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+	}
+	until := a.nodes.eject(a.resolveUrl(url), d)
+	a.logger.Warn("node ejected", zap.String("url", desensitize(url)), zap.Time("until", until))
+	writeJson(w, map[string]any{"url": desensitize(url), "ejectedUntil": until})
+}
+
+func (a *adminServer) handleRestore(w http.ResponseWriter, req *http.Request) {
+	url := req.URL.Query().Get("url")
+	if url == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+	restored := a.nodes.restore(a.resolveUrl(url))
+	if restored {
+		a.logger.Info("node restored", zap.String("url", desensitize(url)))
+	}
+	writeJson(w, map[string]any{"url": desensitize(url), "restored": restored})
+}
+
+func (a *adminServer) handleFlushKeys(w http.ResponseWriter, req *http.Request) {
+	n := a.secretKeys.flush()
+	a.logger.Info("secret key cache flushed", zap.Int("count", n))
+	writeJson(w, map[string]any{"flushed": n})
+}
+
+func (a *adminServer) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	a.nodes.writeMetrics(a.protocol, func(format string, args ...any) {
+		fmt.Fprintf(w, format, args...)
+	})
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestIsLoopbackAddr(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:9090": true,
+		"localhost:9090": true,
+		"[::1]:9090":     true,
+		"0.0.0.0:9090":   false,
+		":9090":          false,
+		"10.0.0.5:9090":  false,
+		"invalid":        false,
+	}
+	for addr, want := range tests {
+		if got := isLoopbackAddr(addr); got != want {
+			t.Errorf("isLoopbackAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestAdminServer_startRequiresToken(t *testing.T) {
+	a := &adminServer{logger: zap.NewNop()}
+	if _, err := a.start("0.0.0.0:0", make(chan error, 1)); err == nil {
+		t.Fatalf("expected error for a public address without a token")
+	}
+	srv, err := a.start("127.0.0.1:0", make(chan error, 1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	srv.Close()
+}
+
+func TestAdminServer_auth(t *testing.T) {
+	a := &adminServer{token: "secret"}
+	handler := a.auth(func(w http.ResponseWriter, req *http.Request) {})
+	tests := map[string]int{
+		"Bearer secret":  http.StatusOK,
+		"Bearer secret2": http.StatusUnauthorized,
+		"Bearer":         http.StatusUnauthorized,
+		"secret":         http.StatusUnauthorized,
+		"":               http.StatusUnauthorized,
+	}
+	for header, want := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/admin/status", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != want {
+			t.Errorf("Authorization %q: status %d, want %d", header, rec.Code, want)
+		}
+	}
+}
+
+func TestAdminServer_statusDesensitized(t *testing.T) {
+	url := "https://mainnet.infura.io/v3/0123456789abcdef0123456789abcdef"
+	a := &adminServer{
+		nodes:      newNodeMonitor(nil, zap.NewNop()),
+		secretKeys: newSecretKeyStore(nil, zap.NewNop()),
+		pools: func() []adminPool {
+			return []adminPool{{chainId: "1", source: "paid", url: url}}
+		},
+	}
+	st := a.status()
+	if got := st.Chains[0].Nodes[0].Url; strings.Contains(got, "0123456789abcdef") {
+		t.Fatalf("expected the api key redacted, got %s", got)
+	}
+	// the redacted url still refers to the node
+	if got := a.resolveUrl(st.Chains[0].Nodes[0].Url); got != url {
+		t.Fatalf("expected %s, got %s", url, got)
+	}
+}
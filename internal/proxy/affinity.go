@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	AffinityOff     = "off"
+	AffinitySession = "session"
+	AffinityIp      = "ip"
+)
+
+// AffinityPolicy keeps the calls of a session on one node so its reads stay consistent.
+// Sessions are keyed by access key plus the Header value in session mode, or by visitor ip in ip mode.
+// The node is picked by rendezvous hashing over the available nodes, so a session only moves when its node leaves the pool.
+type AffinityPolicy struct {
+	Mode   string
+	Header string
+}
+
+func NewAffinityPolicy() *AffinityPolicy {
+	return &AffinityPolicy{
+		Mode:   AffinityOff,
+		Header: "X-Session-Id",
+	}
+}
+
+func ParseAffinityMode(mode string) (string, error) {
+	switch mode {
+	case "", AffinityOff:
+		return AffinityOff, nil
+	case AffinitySession, AffinityIp:
+		return mode, nil
+	}
+	return "", fmt.Errorf("affinity mode %s not supported, use off, session or ip", mode)
+}
+
+func (a *AffinityPolicy) enabled() bool {
+	return a != nil && (a.Mode == AffinitySession || a.Mode == AffinityIp)
+}
+
+// httpKey returns the session key of an http request, empty when the request has no session
+func (a *AffinityPolicy) httpKey(accessKey string, req *http.Request) string {
+	if !a.enabled() {
+		return ""
+	}
+	if a.Mode == AffinityIp {
+		return visitorIp(req)
+	}
+	if session := req.Header.Get(a.Header); session != "" {
+		return accessKey + "/" + session
+	}
+	return ""
+}
+
+// grpcKey returns the session key of a grpc call, empty when the call has no session
+func (a *AffinityPolicy) grpcKey(ctx context.Context, md metadata.MD) string {
+	if !a.enabled() {
+		return ""
+	}
+	if a.Mode == AffinityIp {
+		for _, key := range []string{"x-forwarded-for", "x-real-ip"} {
+			if vals := md.Get(key); len(vals) > 0 && vals[0] != "" {
+				return strings.TrimSpace(strings.Split(vals[0], ",")[0])
+			}
+		}
+		if p, ok := peer.FromContext(ctx); ok {
+			if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+				return host
+			}
+			return p.Addr.String()
+		}
+		return ""
+	}
+	session := md.Get(strings.ToLower(a.Header))
+	accessKey := md.Get("accesskey")
+	if len(session) == 0 || session[0] == "" || len(accessKey) == 0 {
+		return ""
+	}
+	return accessKey[0] + "/" + session[0]
+}
+
+// rendezvousOrder sorts a copy of urls by their rendezvous score for key, the first url is the session's node
+func rendezvousOrder(key string, urls []string) []string {
+	scores := make(map[string]uint64, len(urls))
+	for _, url := range urls {
+		scores[url] = rendezvousScore(key, url)
+	}
+	ordered := append([]string(nil), urls...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return scores[ordered[i]] > scores[ordered[j]]
+	})
+	return ordered
+}
+
+func rendezvousScore(key, url string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(url))
+	return h.Sum64()
+}
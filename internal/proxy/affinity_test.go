@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+var affinityTestUrls = []string{
+	"https://a.example.com",
+	"https://b.example.com",
+	"https://c.example.com",
+	"https://d.example.com",
+	"https://e.example.com",
+}
+
+func TestRendezvousOrder(t *testing.T) {
+	ordered := rendezvousOrder("session", affinityTestUrls)
+	if len(ordered) != len(affinityTestUrls) {
+		t.Fatalf("got %d urls, want %d", len(ordered), len(affinityTestUrls))
+	}
+	sorted := slices.Clone(ordered)
+	slices.Sort(sorted)
+	if !slices.Equal(sorted, affinityTestUrls) {
+		t.Fatalf("rendezvousOrder(%v) = %v, not a permutation", affinityTestUrls, ordered)
+	}
+
+	reversed := slices.Clone(affinityTestUrls)
+	slices.Reverse(reversed)
+	if got := rendezvousOrder("session", reversed); !slices.Equal(got, ordered) {
+		t.Errorf("order depends on the input order: %v != %v", got, ordered)
+	}
+	if !slices.Equal(affinityTestUrls, []string{
+		"https://a.example.com",
+		"https://b.example.com",
+		"https://c.example.com",
+		"https://d.example.com",
+		"https://e.example.com",
+	}) {
+		t.Errorf("rendezvousOrder modified its input: %v", affinityTestUrls)
+	}
+}
+
+func TestRendezvousOrder_nodeLeaves(t *testing.T) {
+	ordered := rendezvousOrder("session", affinityTestUrls)
+
+	// another node leaving keeps the session on its node
+	other := slices.DeleteFunc(slices.Clone(affinityTestUrls), func(url string) bool { return url == ordered[len(ordered)-1] })
+	if got := rendezvousOrder("session", other); got[0] != ordered[0] {
+		t.Errorf("session moved from %s to %s when %s left", ordered[0], got[0], ordered[len(ordered)-1])
+	}
+
+	// the session's node leaving moves it to its next node
+	own := slices.DeleteFunc(slices.Clone(affinityTestUrls), func(url string) bool { return url == ordered[0] })
+	if got := rendezvousOrder("session", own); got[0] != ordered[1] {
+		t.Errorf("session moved to %s, want %s", got[0], ordered[1])
+	}
+}
+
+func TestRendezvousOrder_spread(t *testing.T) {
+	first := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		first[rendezvousOrder(fmt.Sprintf("session-%d", i), affinityTestUrls)[0]]++
+	}
+	for _, url := range affinityTestUrls {
+		if n := first[url]; n < 100 {
+			t.Errorf("%s leads %d of 1000 sessions, want about 200", url, n)
+		}
+	}
+}
+
+func TestOrderTargets(t *testing.T) {
+	tests := []struct {
+		name        string
+		n           int
+		affinityKey string
+		want        int
+	}{
+		{name: "no session", n: 3, want: 3},
+		{name: "session", n: 3, affinityKey: "session", want: 3},
+		{name: "fewer urls than n", n: 10, affinityKey: "session", want: len(affinityTestUrls)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := orderTargets(slices.Clone(affinityTestUrls), tt.n, tt.affinityKey)
+			if len(got) != tt.want {
+				t.Fatalf("got %d targets, want %d", len(got), tt.want)
+			}
+			if tt.affinityKey != "" {
+				if want := rendezvousOrder(tt.affinityKey, affinityTestUrls)[:tt.want]; !slices.Equal(got, want) {
+					t.Errorf("orderTargets = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerPolicy trips the circuit breaker of a node when too many of its recent calls fail or are slow.
+// An open breaker keeps the node out of selection for OpenDuration, then HalfOpenCalls successful probes
+// close it again. HalfOpenCalls also caps the probes in flight while half-open.
+type BreakerPolicy struct {
+	// ErrorRate and SlowCallRate are shares of the window in [0,1], 0 disables the check
+	ErrorRate     float64
+	SlowCallRate  float64
+	SlowCall      time.Duration
+	Window        int
+	MinCalls      int
+	OpenDuration  time.Duration
+	HalfOpenCalls int
+}
+
+func NewBreakerPolicy() *BreakerPolicy {
+	return &BreakerPolicy{
+		ErrorRate:     0.5,
+		SlowCall:      5 * time.Second,
+		Window:        20,
+		MinCalls:      10,
+		OpenDuration:  30 * time.Second,
+		HalfOpenCalls: 3,
+	}
+}
+
+func (p *BreakerPolicy) enabled() bool {
+	return p != nil && (p.ErrorRate > 0 || p.SlowCallRate > 0)
+}
+
+type breakerOutcome struct {
+	failed bool
+	slow   bool
+}
+
+type circuitBreaker struct {
+	state     breakerState
+	outcomes  []breakerOutcome
+	next      int
+	count     int
+	openedAt  time.Time
+	successes int
+	// probes are the half-open calls in flight, reserved by begin
+	probes int
+}
+
+func (b *circuitBreaker) push(policy *BreakerPolicy, o breakerOutcome) {
+	if len(b.outcomes) != policy.Window {
+		b.outcomes = make([]breakerOutcome, policy.Window)
+		b.next, b.count = 0, 0
+	}
+	b.outcomes[b.next] = o
+	b.next = (b.next + 1) % len(b.outcomes)
+	if b.count < len(b.outcomes) {
+		b.count++
+	}
+}
+
+func (b *circuitBreaker) tripped(policy *BreakerPolicy) bool {
+	if b.count < max(policy.MinCalls, 1) {
+		return false
+	}
+	var failed, slow int
+	for _, o := range b.outcomes[:b.count] {
+		if o.failed {
+			failed++
+		}
+		if o.slow {
+			slow++
+		}
+	}
+	n := float64(b.count)
+	return (policy.ErrorRate > 0 && float64(failed)/n >= policy.ErrorRate) ||
+		(policy.SlowCallRate > 0 && float64(slow)/n >= policy.SlowCallRate)
+}
+
+type breakerTransition struct {
+	url  string
+	from breakerState
+	to   breakerState
+}
+
+// breaker returns the breaker of url, m.mu must be held
+func (m *nodeMonitor) breaker(url string) *circuitBreaker {
+	b, ok := m.breakers[url]
+	if !ok {
+		b = &circuitBreaker{}
+		m.breakers[url] = b
+	}
+	return b
+}
+
+// transition moves the breaker of url to state, m.mu must be held
+func (m *nodeMonitor) transition(url string, b *circuitBreaker, to breakerState) {
+	from := b.state
+	b.state = to
+	switch to {
+	case breakerOpen:
+		b.openedAt = time.Now()
+	case breakerHalfOpen:
+		b.successes, b.probes = 0, 0
+	case breakerClosed:
+		b.next, b.count = 0, 0
+	}
+	m.transitions[breakerTransition{url: url, from: from, to: to}]++
+	fields := []zap.Field{zap.String("url", desensitize(url)), zap.String("from", from.String()), zap.String("to", to.String())}
+	if to == breakerOpen {
+		m.logger.Warn("circuit breaker state changed", fields...)
+	} else {
+		m.logger.Info("circuit breaker state changed", fields...)
+	}
+}
+
+// breakerAllows reports whether the breaker lets a call through, half-open breakers allow a few probes at a time.
+// Only begin reserves a probe, for selection this is a hint. m.mu must be held.
+func (m *nodeMonitor) breakerAllows(url string) bool {
+	if !m.breakerPolicy.enabled() {
+		return true
+	}
+	b, ok := m.breakers[url]
+	if !ok {
+		return true
+	}
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < m.breakerPolicy.OpenDuration {
+			return false
+		}
+		m.transition(url, b, breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		return b.probes < max(m.breakerPolicy.HalfOpenCalls, 1)
+	}
+	return true
+}
+
+// reserveProbe takes a probe slot when the breaker of url is half-open, false when none is left.
+// m.mu must be held.
+func (m *nodeMonitor) reserveProbe(url string) bool {
+	if !m.breakerPolicy.enabled() {
+		return true
+	}
+	b, ok := m.breakers[url]
+	if !ok {
+		return true
+	}
+	if !m.breakerAllows(url) {
+		return false
+	}
+	if b.state == breakerHalfOpen {
+		b.probes++
+	}
+	return true
+}
+
+// releaseProbe frees the probe slot of a finished call, m.mu must be held
+func (m *nodeMonitor) releaseProbe(url string) {
+	if b, ok := m.breakers[url]; ok && b.state == breakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// recordBreaker feeds a finished call into the breaker of url, m.mu must be held
+func (m *nodeMonitor) recordBreaker(url string, failed bool, latency time.Duration) {
+	policy := m.breakerPolicy
+	if !policy.enabled() {
+		return
+	}
+	o := breakerOutcome{
+		failed: failed,
+		slow:   policy.SlowCallRate > 0 && latency >= policy.SlowCall,
+	}
+	b := m.breaker(url)
+	switch b.state {
+	case breakerClosed:
+		b.push(policy, o)
+		if b.tripped(policy) {
+			m.transition(url, b, breakerOpen)
+		}
+	case breakerHalfOpen:
+		if o.failed || o.slow {
+			m.transition(url, b, breakerOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= max(policy.HalfOpenCalls, 1) {
+			m.transition(url, b, breakerClosed)
+		}
+	}
+	// calls started before the breaker opened are ignored
+}
+
+// breakerState returns the state of url without moving it, m.mu must be held
+func (m *nodeMonitor) breakerState(url string) breakerState {
+	if b, ok := m.breakers[url]; ok {
+		return b.state
+	}
+	return breakerClosed
+}
+
+// writeMetrics writes the node and breaker metrics in the prometheus text format
+func (m *nodeMonitor) writeMetrics(protocol string, w func(format string, args ...any)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w("# HELP cg_node_inflight_requests Requests outstanding on an upstream node.\n")
+	w("# TYPE cg_node_inflight_requests gauge\n")
+	for url, s := range m.nodes {
+		w("cg_node_inflight_requests{protocol=%q,url=%q} %d\n", protocol, desensitize(url), s.inflight)
+	}
+	w("# HELP cg_node_requests_total Requests sent to an upstream node.\n")
+	w("# TYPE cg_node_requests_total counter\n")
+	for url, s := range m.nodes {
+		w("cg_node_requests_total{protocol=%q,url=%q} %d\n", protocol, desensitize(url), s.requests)
+	}
+	w("# HELP cg_breaker_state Circuit breaker state of an upstream node, 0 closed, 1 open, 2 half-open.\n")
+	w("# TYPE cg_breaker_state gauge\n")
+	for url, b := range m.breakers {
+		w("cg_breaker_state{protocol=%q,url=%q} %d\n", protocol, desensitize(url), b.state)
+	}
+	w("# HELP cg_breaker_transitions_total Circuit breaker state changes of an upstream node.\n")
+	w("# TYPE cg_breaker_transitions_total counter\n")
+	for t, n := range m.transitions {
+		w("cg_breaker_transitions_total{protocol=%q,url=%q,from=%q,to=%q} %d\n", protocol, desensitize(t.url), t.from, t.to, n)
+	}
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const breakerTestUrl = "https://node.example.com"
+
+func newBreakerTestMonitor() *nodeMonitor {
+	return newNodeMonitor(&BreakerPolicy{
+		ErrorRate:     0.5,
+		Window:        4,
+		MinCalls:      4,
+		OpenDuration:  time.Hour,
+		HalfOpenCalls: 2,
+	}, zap.NewNop())
+}
+
+// call runs one finished call on url through the monitor
+func (m *nodeMonitor) call(t *testing.T, url string, failed bool) {
+	t.Helper()
+	if _, err := m.begin(url); err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	m.end(context.Background(), url, failed, time.Millisecond)
+}
+
+func (m *nodeMonitor) state(url string) breakerState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.breakerState(url)
+}
+
+// halfOpen moves an open breaker past its open duration
+func (m *nodeMonitor) halfOpen(url string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.breakers[url].openedAt = time.Now().Add(-m.breakerPolicy.OpenDuration)
+}
+
+func tripBreaker(t *testing.T, m *nodeMonitor) {
+	t.Helper()
+	for _, failed := range []bool{false, true, false, true} {
+		m.call(t, breakerTestUrl, failed)
+	}
+	if s := m.state(breakerTestUrl); s != breakerOpen {
+		t.Fatalf("state = %s, want open", s)
+	}
+}
+
+func TestBreaker_closedToOpen(t *testing.T) {
+	m := newBreakerTestMonitor()
+	for _, failed := range []bool{true, true, false} {
+		m.call(t, breakerTestUrl, failed)
+	}
+	if s := m.state(breakerTestUrl); s != breakerClosed {
+		t.Fatalf("below min calls state = %s, want closed", s)
+	}
+	m.call(t, breakerTestUrl, false)
+	if s := m.state(breakerTestUrl); s != breakerOpen {
+		t.Fatalf("state = %s, want open", s)
+	}
+	if _, err := m.begin(breakerTestUrl); err != errNodeUnavailable {
+		t.Fatalf("begin on an open breaker = %v, want %v", err, errNodeUnavailable)
+	}
+}
+
+func TestBreaker_openToHalfOpen(t *testing.T) {
+	m := newBreakerTestMonitor()
+	tripBreaker(t, m)
+	m.halfOpen(breakerTestUrl)
+	if _, err := m.begin(breakerTestUrl); err != nil {
+		t.Fatalf("begin after the open duration: %v", err)
+	}
+	if s := m.state(breakerTestUrl); s != breakerHalfOpen {
+		t.Fatalf("state = %s, want half-open", s)
+	}
+}
+
+func TestBreaker_halfOpenProbeLimit(t *testing.T) {
+	m := newBreakerTestMonitor()
+	tripBreaker(t, m)
+	m.halfOpen(breakerTestUrl)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		admitted int
+	)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.begin(breakerTestUrl); err == nil {
+				mu.Lock()
+				admitted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if admitted != m.breakerPolicy.HalfOpenCalls {
+		t.Fatalf("admitted %d probes, want %d", admitted, m.breakerPolicy.HalfOpenCalls)
+	}
+
+	// a finished probe frees its slot
+	m.end(context.Background(), breakerTestUrl, false, time.Millisecond)
+	if _, err := m.begin(breakerTestUrl); err != nil {
+		t.Fatalf("begin after a probe finished: %v", err)
+	}
+}
+
+func TestBreaker_halfOpenToClosed(t *testing.T) {
+	m := newBreakerTestMonitor()
+	tripBreaker(t, m)
+	m.halfOpen(breakerTestUrl)
+	m.call(t, breakerTestUrl, false)
+	if s := m.state(breakerTestUrl); s != breakerHalfOpen {
+		t.Fatalf("after one success state = %s, want half-open", s)
+	}
+	m.call(t, breakerTestUrl, false)
+	if s := m.state(breakerTestUrl); s != breakerClosed {
+		t.Fatalf("state = %s, want closed", s)
+	}
+}
+
+func TestBreaker_halfOpenToOpen(t *testing.T) {
+	m := newBreakerTestMonitor()
+	tripBreaker(t, m)
+	m.halfOpen(breakerTestUrl)
+	m.call(t, breakerTestUrl, true)
+	if s := m.state(breakerTestUrl); s != breakerOpen {
+		t.Fatalf("state = %s, want open", s)
+	}
+}
+
+func TestBreaker_disabled(t *testing.T) {
+	m := newNodeMonitor(nil, zap.NewNop())
+	for i := 0; i < 10; i++ {
+		m.call(t, breakerTestUrl, true)
+	}
+	if s := m.state(breakerTestUrl); s != breakerClosed {
+		t.Fatalf("state = %s, want closed", s)
+	}
+}
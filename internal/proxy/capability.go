@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pundix/chain-gateway/internal/client"
+	"github.com/pundix/chain-gateway/pkg/pocketbase"
+)
+
+const (
+	// reads deeper than this below the chain head need a node keeping old state
+	recentStateDepth = 128
+	// eth_getLogs ranges up to this many blocks are served by every node
+	defaultLogsRange = 100
+)
+
+// capabilityNeed is a capability tag a request needs, value is the block depth or range for state and logs
+type capabilityNeed struct {
+	tag   string
+	value int64
+}
+
+// capabilityStore holds the capability tags of nodes, found by the Capability check strategy and kept in upstream_node
+type capabilityStore struct {
+	mu   sync.RWMutex
+	tags map[string][]string
+}
+
+func newCapabilityStore() *capabilityStore {
+	return &capabilityStore{tags: make(map[string][]string)}
+}
+
+func (s *capabilityStore) fetch(cli *pocketbase.Client, protocol client.Protocol) error {
+	items, err := cli.ListAllRecords("upstream_node", pocketbase.ListOptions{
+		Filter: fmt.Sprintf("protocol = '%s'", protocol),
+	})
+	if err != nil {
+		return err
+	}
+	tags := make(map[string][]string, len(items))
+	for _, record := range items {
+		url, _ := record["url"].(string)
+		values, ok := record["tags"].([]any)
+		if url == "" || !ok {
+			continue
+		}
+		for _, v := range values {
+			if tag, ok := v.(string); ok {
+				tags[url] = append(tags[url], tag)
+			}
+		}
+	}
+	s.mu.Lock()
+	s.tags = tags
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *capabilityStore) get(url string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tags[url]
+}
+
+// has reports whether a node was probed and whether it has the capability
+func (s *capabilityStore) has(url string, need capabilityNeed) (bool, bool) {
+	s.mu.RLock()
+	tags, probed := s.tags[url]
+	s.mu.RUnlock()
+	if !probed {
+		return false, false
+	}
+	for _, tag := range tags {
+		name, value, _ := strings.Cut(tag, ":")
+		switch {
+		case need.tag == "state" && name == "archive":
+			return true, true
+		case name != need.tag:
+			continue
+		case value == "":
+			return true, true
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= need.value {
+			return true, true
+		}
+	}
+	return true, false
+}
+
+// filter keeps the nodes tagged with the capability. Without any tagged node the urls are kept,
+// so pools that were never probed route as before.
+func (s *capabilityStore) filter(urls []string, need capabilityNeed) []string {
+	if need.tag == "" || len(urls) == 0 {
+		return urls
+	}
+	ret := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if _, ok := s.has(url, need); ok {
+			ret = append(ret, url)
+		}
+	}
+	if len(ret) == 0 {
+		return urls
+	}
+	return ret
+}
+
+// stateMethods read account state and need old state for blocks far below the head
+var stateMethods = map[string]bool{
+	"eth_call":                true,
+	"eth_estimateGas":         true,
+	"eth_getBalance":          true,
+	"eth_getCode":             true,
+	"eth_getTransactionCount": true,
+	"eth_getStorageAt":        true,
+	"eth_getProof":            true,
+	"debug_traceCall":         true,
+}
+
+// requiredCapability returns the capability a request needs, head is the highest known height of the chain
+func requiredCapability(method string, body []byte, block, head int64) capabilityNeed {
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		return capabilityNeed{tag: "batch"}
+	}
+	switch {
+	case strings.HasPrefix(method, "trace_"):
+		return capabilityNeed{tag: "trace"}
+	case strings.HasPrefix(method, "debug_") && !stateMethods[method]:
+		return capabilityNeed{tag: "debug"}
+	case method == "eth_getLogs":
+		if r := logsRange(body, head); r > defaultLogsRange {
+			return capabilityNeed{tag: "logs", value: r}
+		}
+	case stateMethods[method] && block > 0 && head-block > recentStateDepth:
+		return capabilityNeed{tag: "state", value: head - block}
+	}
+	return capabilityNeed{}
+}
+
+// logsRange returns the block range of an eth_getLogs filter, 0 when it can't be told
+func logsRange(body []byte, head int64) int64 {
+	var req struct {
+		Params []struct {
+			FromBlock string `json:"fromBlock"`
+			ToBlock   string `json:"toBlock"`
+			BlockHash string `json:"blockHash"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || len(req.Params) == 0 || req.Params[0].BlockHash != "" {
+		return 0
+	}
+	bound := func(tag string) (int64, bool) {
+		if tag == "" || tag == "latest" {
+			return head, head > 0
+		}
+		n, err := parseBlockNumber(tag)
+		return n, err == nil
+	}
+	from, ok := bound(req.Params[0].FromBlock)
+	if !ok {
+		return 0
+	}
+	to, ok := bound(req.Params[0].ToBlock)
+	if !ok || to < from {
+		return 0
+	}
+	return to - from + 1
+}
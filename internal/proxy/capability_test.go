@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLogsRange(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		head int64
+		want int64
+	}{
+		{name: "numbers", body: `{"method":"eth_getLogs","params":[{"fromBlock":"0x1","toBlock":"0x64"}]}`, want: 100},
+		{name: "single block", body: `{"method":"eth_getLogs","params":[{"fromBlock":"0x10","toBlock":"0x10"}]}`, want: 1},
+		{name: "to latest", body: `{"method":"eth_getLogs","params":[{"fromBlock":"0x3e8","toBlock":"latest"}]}`, head: 2000, want: 1001},
+		{name: "defaults to the head", body: `{"method":"eth_getLogs","params":[{}]}`, head: 2000, want: 1},
+		{name: "latest without a known head", body: `{"method":"eth_getLogs","params":[{"fromBlock":"0x1"}]}`},
+		{name: "block hash", body: `{"method":"eth_getLogs","params":[{"blockHash":"0xabc"}]}`, head: 2000},
+		{name: "other tag", body: `{"method":"eth_getLogs","params":[{"fromBlock":"earliest","toBlock":"0x10"}]}`},
+		{name: "reversed range", body: `{"method":"eth_getLogs","params":[{"fromBlock":"0x10","toBlock":"0x1"}]}`},
+		{name: "no params", body: `{"method":"eth_getLogs","params":[]}`},
+		{name: "invalid json", body: `{`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := logsRange([]byte(tt.body), tt.head); got != tt.want {
+				t.Errorf("logsRange() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequiredCapability(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		block  int64
+		head   int64
+		want   capabilityNeed
+	}{
+		{name: "batch", method: "eth_blockNumber", body: ` [{"method":"eth_blockNumber"}]`, want: capabilityNeed{tag: "batch"}},
+		{name: "trace", method: "trace_block", want: capabilityNeed{tag: "trace"}},
+		{name: "debug", method: "debug_traceTransaction", want: capabilityNeed{tag: "debug"}},
+		{name: "debug state method at a recent block", method: "debug_traceCall", block: 1000, head: 1000},
+		{name: "debug state method at an old block", method: "debug_traceCall", block: 100, head: 1000, want: capabilityNeed{tag: "state", value: 900}},
+		{name: "small logs range", method: "eth_getLogs", body: `{"params":[{"fromBlock":"0x1","toBlock":"0x64"}]}`},
+		{name: "large logs range", method: "eth_getLogs", body: `{"params":[{"fromBlock":"0x1","toBlock":"0x65"}]}`, want: capabilityNeed{tag: "logs", value: 101}},
+		{name: "state within recent depth", method: "eth_getBalance", block: 1000 - recentStateDepth, head: 1000},
+		{name: "state below recent depth", method: "eth_getBalance", block: 999 - recentStateDepth, head: 1000, want: capabilityNeed{tag: "state", value: recentStateDepth + 1}},
+		{name: "state without a block", method: "eth_getBalance", head: 1000},
+		{name: "plain read", method: "eth_blockNumber"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requiredCapability(tt.method, []byte(tt.body), tt.block, tt.head); got != tt.want {
+				t.Errorf("requiredCapability() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapabilityStore_filter(t *testing.T) {
+	s := newCapabilityStore()
+	s.tags = map[string][]string{
+		"archive": {"archive", "trace"},
+		"full":    {"state:256", "logs:1000"},
+		"light":   {"logs:100"},
+	}
+	urls := []string{"archive", "full", "light", "unprobed"}
+	tests := []struct {
+		name string
+		need capabilityNeed
+		want []string
+	}{
+		{name: "no need", want: urls},
+		{name: "archive serves old state", need: capabilityNeed{tag: "state", value: 10000}, want: []string{"archive"}},
+		{name: "state depth", need: capabilityNeed{tag: "state", value: 200}, want: []string{"archive", "full"}},
+		{name: "logs range", need: capabilityNeed{tag: "logs", value: 500}, want: []string{"full"}},
+		{name: "plain tag", need: capabilityNeed{tag: "trace"}, want: []string{"archive"}},
+		{name: "no tagged node keeps every url", need: capabilityNeed{tag: "debug"}, want: urls},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.filter(urls, tt.need); !slices.Equal(got, tt.want) {
+				t.Errorf("filter(%+v) = %v, want %v", tt.need, got, tt.want)
+			}
+		})
+	}
+}
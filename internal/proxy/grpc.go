@@ -4,12 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 	"net"
 	"os"
 	"os/signal"
-	"strings"
-	"sync"
 	"syscall"
 	"time"
 
@@ -25,27 +22,44 @@ import (
 )
 
 type GrpcProxier struct {
-	Duration        time.Duration
-	logger          *zap.Logger
-	cli             *pocketbase.Client
-	secretKeyCaches map[string]*client.SecretKey
-	upstreamCaches  grpcUpstreamCaches
-	secretMu        sync.RWMutex
+	Addr           string
+	Duration       time.Duration
+	Timeout        *TimeoutPolicy
+	Hedge          *HedgePolicy
+	Breaker        *BreakerPolicy
+	Affinity       *AffinityPolicy
+	Usage          *UsageRecorder
+	AdminAddr      string
+	AdminToken     string
+	logger         *zap.Logger
+	cli            *pocketbase.Client
+	secretKeys     *secretKeyStore
+	upstreamCaches grpcUpstreamCaches
+	nodes          *nodeMonitor
 }
 
 func NewGrpc(cli *pocketbase.Client) *GrpcProxier {
 	logger, _ := zap.NewDevelopment(zap.IncreaseLevel(zap.InfoLevel))
+	breaker := NewBreakerPolicy()
 	return &GrpcProxier{
-		logger:          logger,
-		secretKeyCaches: make(map[string]*client.SecretKey),
-		upstreamCaches:  make(grpcUpstreamCaches),
-		cli:             cli,
-		Duration:        5 * time.Minute,
+		Addr:           "0.0.0.0:50051",
+		logger:         logger,
+		secretKeys:     newSecretKeyStore(cli, logger),
+		upstreamCaches: make(grpcUpstreamCaches),
+		cli:            cli,
+		Duration:       5 * time.Minute,
+		Timeout:        NewTimeoutPolicy(),
+		Hedge:          NewHedgePolicy(),
+		Breaker:        breaker,
+		Affinity:       NewAffinityPolicy(),
+		Usage:          NewUsageRecorder(cli, logger),
+		nodes:          newNodeMonitor(breaker, logger),
 	}
 }
 
 func (p *GrpcProxier) Fetch() {
 	p.fetchUpstream()
+	p.Usage.Run()
 	go func() {
 		ticker := time.NewTicker(p.Duration)
 		defer ticker.Stop()
@@ -66,12 +80,28 @@ func (p *GrpcProxier) Proxy() error {
 
 	grpc_health_v1.RegisterHealthServer(srv, &HealthServerImpl{})
 
-	lis, err := net.Listen("tcp", "0.0.0.0:50051")
+	lis, err := net.Listen("tcp", p.Addr)
 	if err != nil {
 		return err
 	}
 
 	errC := make(chan error)
+	if p.AdminAddr != "" {
+		admin := &adminServer{
+			protocol:   "grpc",
+			token:      p.AdminToken,
+			logger:     p.logger,
+			nodes:      p.nodes,
+			secretKeys: p.secretKeys,
+			pools:      p.upstreamCaches.pools,
+			refresh:    p.fetchUpstream,
+		}
+		adminSrv, err := admin.start(p.AdminAddr, errC)
+		if err != nil {
+			return err
+		}
+		defer adminSrv.Close()
+	}
 	go func() {
 		p.logger.Info("listening on", zap.String("address", lis.Addr().String()))
 		if err := srv.Serve(lis); err != nil {
@@ -85,6 +115,7 @@ func (p *GrpcProxier) Proxy() error {
 	select {
 	case <-sigC:
 		srv.GracefulStop()
+		p.Usage.Flush()
 		p.logger.Info("grpc server stopped")
 		return nil
 	case err := <-errC:
@@ -119,47 +150,66 @@ func (p *GrpcProxier) director(ctx context.Context, fullMethodName string) (cont
 	if err != nil {
 		return nil, nil, err
 	}
-	outCtx := metadata.NewOutgoingContext(ctx, md.Copy())
+	outCtx, cancel := p.Timeout.apply(metadata.NewOutgoingContext(ctx, md.Copy()), chainId, fullMethodName)
+	// the proxy handler derives its own cancel from outCtx, release ours with the server stream
+	context.AfterFunc(ctx, cancel)
 
 	var upstream *grpcUpstream
 	var cc *grpc.ClientConn
 	upstream, err = p.upstreamCaches.get(chainId)
+	affinityKey := p.Affinity.grpcKey(ctx, md)
 	if upstream != nil {
-		cc, err = upstream.get()
+		if affinityKey != "" {
+			cc, err = upstream.getByKey(affinityKey)
+		} else {
+			cc, err = upstream.get()
+		}
 	}
 	if err != nil {
 		var sk *client.SecretKey
-		p.secretMu.RLock()
 		if vals := md.Get("accessKey"); len(vals) > 0 {
-			sk = p.secretKeyCaches[vals[0]]
+			sk = p.secretKeys.get(vals[0])
 		}
-		p.secretMu.RUnlock()
 		if sk != nil {
 			rt := NewRequestTraceBuilder(sk.Service, sk.Group).
 				WithChainIdAndSource(chainId, "custom/grpc").
+				WithAccessKey(sk.AccessKey).
 				WithRequest(md, fullMethodName).
 				WithResponse(0, status.New(codes.Unavailable, err.Error())).Build()
+			p.Usage.recordGrpc(rt)
 			p.logger.Warn("get endpoint failed", zap.Any("request trace", rt))
 		} else {
 			p.logger.Warn("get endpoint failed", zap.Error(err))
 		}
 	}
+	// sticky sessions stay on their node, hedging would race them against another one
+	if err == nil && p.Hedge.enabled() && affinityKey == "" && isReadGrpcMethod(fullMethodName) {
+		return outCtx, &hedgedConn{
+			hedge:    p.Hedge,
+			upstream: upstream,
+			primary:  cc,
+			chainId:  chainId,
+			logger:   p.logger,
+		}, nil
+	}
 	return outCtx, cc, err
 }
 
 func (p *GrpcProxier) fetchUpstream() {
-	listResp, err := p.cli.ListRecords("ready_upstream", pocketbase.ListOptions{
+	items, err := p.cli.ListAllRecords("ready_upstream", pocketbase.ListOptions{
 		Filter: "protocol = 'grpc'",
 	})
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		p.logger.Error("fetch upstream failed", zap.Error(err))
+		p.nodes.refreshed(err)
 		return
 	}
-	if listResp == nil || len(listResp.Items) == 0 {
+	if len(items) == 0 {
 		p.logger.Error("upstream not found")
+		p.nodes.refreshed(errors.New("upstream not found"))
 		return
 	}
-	for _, record := range listResp.Items {
+	for _, record := range items {
 		var rpc []string
 		for _, url := range record["rpc"].([]any) {
 			rpc = append(rpc, url.(string))
@@ -171,9 +221,11 @@ func (p *GrpcProxier) fetchUpstream() {
 			clis:    make(map[string]*grpc.ClientConn),
 			next:    0,
 			logger:  p.logger,
+			nodes:   p.nodes,
 		}, p.loggingStreamInterceptor)
 	}
-	p.logger.Info("fetch upstream success", zap.Any("count", len(listResp.Items)))
+	p.nodes.refreshed(nil)
+	p.logger.Info("fetch upstream success", zap.Any("count", len(items)))
 }
 
 func (p *GrpcProxier) loggingStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (gcs grpc.ClientStream, err error) {
@@ -182,15 +234,14 @@ func (p *GrpcProxier) loggingStreamInterceptor(ctx context.Context, desc *grpc.S
 	if err != nil {
 		return nil, err
 	}
-	var service, group string
-	p.secretMu.RLock()
+	var service, group, accessKey string
 	if vals := md.Get("accesskey"); len(vals) > 0 {
-		if sk, ok := p.secretKeyCaches[vals[0]]; ok && sk != nil {
+		accessKey = vals[0]
+		if sk := p.secretKeys.get(vals[0]); sk != nil {
 			service = sk.Service
 			group = sk.Group
 		}
 	}
-	p.secretMu.RUnlock()
 	if service == "" {
 		service = "unknown"
 	}
@@ -200,56 +251,28 @@ func (p *GrpcProxier) loggingStreamInterceptor(ctx context.Context, desc *grpc.S
 	requestTraceBuilder := NewRequestTraceBuilder(service, group).
 		WithChainIdAndSource(chainId, "custom/grpc").
 		WithUpstreamNode(cc.Target()).
+		WithAccessKey(accessKey).
 		WithRequest(md, method)
+	start, err := p.nodes.begin(cc.Target())
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
 	gcs, err = streamer(ctx, desc, cc, method)
 	if err != nil {
+		p.nodes.end(ctx, cc.Target(), true, time.Since(start))
 		return nil, err
 	}
-	return newWrappedStream(gcs, requestTraceBuilder, p.logger), nil
+	return newWrappedStream(ctx, gcs, requestTraceBuilder, p.logger, p.Usage, p.nodes, start), nil
 }
 
 func (p *GrpcProxier) authStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 	md, _ := metadata.FromIncomingContext(ss.Context())
 	accessKey := md.Get("accessKey")
-	if len(accessKey) == 0 || !p.verifyAccessKey(accessKey[0]) {
+	if len(accessKey) == 0 {
 		return status.Error(codes.Unauthenticated, codes.Unauthenticated.String())
 	}
-	return handler(srv, ss)
-}
-
-func (p *GrpcProxier) verifyAccessKey(accessKey string) bool {
-	p.secretMu.RLock()
-	if _, ok := p.secretKeyCaches[accessKey]; ok {
-		p.secretMu.RUnlock()
-		return true
-	}
-	p.secretMu.RUnlock()
-
-	escaped := strings.ReplaceAll(accessKey, "'", "''")
-	record, err := p.cli.GetFirstListItem("secret_key", pocketbase.ListOptions{
-		Filter: fmt.Sprintf("access_key = '%s'", escaped),
-	})
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		p.logger.Warn("get secret key failed", zap.Error(err))
-		return false
-	}
-
-	if record != nil {
-		p.secretMu.Lock()
-		if _, ok := p.secretKeyCaches[accessKey]; ok {
-			p.secretMu.Unlock()
-			return true
-		}
-		p.secretKeyCaches[accessKey] = &client.SecretKey{
-			AccessKey:    accessKey,
-			SecretKey:    record["secret_key"].(string),
-			Service:      record["service"].(string),
-			Group:        record["group"].(string),
-			AllowOrigins: record["allow_origins"].(string),
-			AllowIps:     record["allow_ips"].(string),
-		}
-		p.secretMu.Unlock()
-		return true
+	if _, ok := p.secretKeys.verify(accessKey[0]); !ok {
+		return status.Error(codes.Unauthenticated, codes.Unauthenticated.String())
 	}
-	return false
+	return handler(srv, ss)
 }
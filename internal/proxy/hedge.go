@@ -0,0 +1,450 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// latencies kept per chain to estimate the hedge delay
+	hedgeSamples = 256
+	// no hedging until a chain has seen this many responses
+	hedgeMinSamples = 20
+	// unused budget carried over, bounds the burst of hedges after a quiet period
+	hedgeMaxTokens = 10
+	// how long a computed hedge delay is reused before the samples are sorted again
+	hedgeRefresh = time.Second
+)
+
+// HedgePolicy sends a read to a second node when the first is slower than the Percentile latency of its chain.
+// Budget caps the extra upstream load as a percentage of hedgeable requests, zero Percentile disables hedging.
+type HedgePolicy struct {
+	Percentile float64
+	MinDelay   time.Duration
+	Budget     float64
+	mu         sync.Mutex
+	trackers   map[string]*latencyTracker
+	tokens     float64
+}
+
+func NewHedgePolicy() *HedgePolicy {
+	return &HedgePolicy{
+		MinDelay: 20 * time.Millisecond,
+		Budget:   10,
+		trackers: make(map[string]*latencyTracker),
+	}
+}
+
+func (hp *HedgePolicy) enabled() bool {
+	return hp != nil && hp.Percentile > 0 && hp.Budget > 0
+}
+
+// delay returns how long to wait before hedging, false when the chain has too few samples yet
+func (hp *HedgePolicy) delay(chainId string) (time.Duration, bool) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	tracker, ok := hp.trackers[chainId]
+	if !ok || tracker.len() < hedgeMinSamples {
+		return 0, false
+	}
+	return max(tracker.cachedPercentile(hp.Percentile, time.Now()), hp.MinDelay), true
+}
+
+func (hp *HedgePolicy) observe(chainId string, latency time.Duration) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	tracker, ok := hp.trackers[chainId]
+	if !ok {
+		tracker = &latencyTracker{}
+		hp.trackers[chainId] = tracker
+	}
+	tracker.add(latency)
+}
+
+// request earns Budget percent of a hedge for every hedgeable request
+func (hp *HedgePolicy) request() {
+	hp.mu.Lock()
+	hp.tokens = min(hp.tokens+hp.Budget/100, hedgeMaxTokens)
+	hp.mu.Unlock()
+}
+
+// allow spends a token, there is no hedge without one
+func (hp *HedgePolicy) allow() bool {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if hp.tokens < 1 {
+		return false
+	}
+	hp.tokens--
+	return true
+}
+
+type latencyTracker struct {
+	samples []time.Duration
+	next    int
+	// the last computed percentile, refreshed every hedgeRefresh
+	cached   time.Duration
+	cachedP  float64
+	computed time.Time
+}
+
+func (t *latencyTracker) add(latency time.Duration) {
+	if len(t.samples) < hedgeSamples {
+		t.samples = append(t.samples, latency)
+		return
+	}
+	t.samples[t.next] = latency
+	t.next = (t.next + 1) % hedgeSamples
+}
+
+func (t *latencyTracker) len() int {
+	return len(t.samples)
+}
+
+func (t *latencyTracker) cachedPercentile(p float64, now time.Time) time.Duration {
+	if p != t.cachedP || now.Sub(t.computed) >= hedgeRefresh {
+		t.cached, t.cachedP, t.computed = t.percentile(p), p, now
+	}
+	return t.cached
+}
+
+func (t *latencyTracker) percentile(p float64) time.Duration {
+	sorted := slices.Clone(t.samples)
+	slices.Sort(sorted)
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+var jsonRpcWriteMethodPrefixes = []string{"eth_send", "eth_sign", "eth_subscribe", "eth_unsubscribe", "personal_", "miner_", "admin_"}
+
+// isReadMethod reports whether every method of the request only reads chain state
+func (rp *requestParams) isReadMethod() bool {
+	for _, method := range strings.Split(rp.rpcMethod, "&") {
+		for _, prefix := range jsonRpcWriteMethodPrefixes {
+			if strings.HasPrefix(method, prefix) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isReadGrpcMethod guesses whether a grpc method only reads, broadcasts and other writes are never hedged
+func isReadGrpcMethod(fullMethodName string) bool {
+	service, method := path.Split(fullMethodName)
+	// cosmos query services, e.g. /cosmos.bank.v1beta1.Query/Balance
+	if strings.HasSuffix(strings.TrimSuffix(service, "/"), ".Query") {
+		return true
+	}
+	for _, prefix := range []string{"Get", "List", "Query"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type hedgeResult struct {
+	resp *http.Response
+	body []byte
+	err  error
+	rtb  *JsonRpcRequestTraceBuilder
+	idx  int
+}
+
+// hedgedForward wraps forward, a read slower than the hedge delay is raced against a single call to the next node,
+// the hedge is not retried so a hedge token costs exactly one upstream call.
+// Sticky sessions are never hedged, the second node could answer from a different chain head.
+func (p *JsonRpcProxier) hedgedForward(ctx context.Context, requestTraceBuilder *JsonRpcRequestTraceBuilder, reqParams *requestParams, targetUrls []string) (*http.Response, []byte, error) {
+	if !p.Hedge.enabled() || !reqParams.isReadMethod() || reqParams.affinityKey != "" {
+		return p.forward(ctx, requestTraceBuilder, reqParams, targetUrls)
+	}
+	p.Hedge.request()
+	delay, ok := p.Hedge.delay(reqParams.chainId)
+	if !ok || len(targetUrls) < 2 {
+		resp, body, err := p.forward(ctx, requestTraceBuilder, reqParams, targetUrls)
+		if err == nil {
+			p.Hedge.observe(reqParams.chainId, time.Since(reqParams.startTime))
+		}
+		return resp, body, err
+	}
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	attempt := func(targets []string) {
+		rt := *requestTraceBuilder.rt
+		rtb := &JsonRpcRequestTraceBuilder{rt: &rt}
+		attemptCtx, cancel := context.WithCancel(ctx)
+		context.AfterFunc(ctx, cancel)
+		idx := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, body, err := p.forward(attemptCtx, rtb, reqParams, targets)
+			results <- hedgeResult{resp: resp, body: body, err: err, rtb: rtb, idx: idx}
+		}()
+	}
+
+	attempt(targetUrls)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	var last hedgeResult
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			last = r
+			if r.err != nil {
+				continue
+			}
+			for i, cancel := range cancels {
+				if i != r.idx {
+					cancel()
+				}
+			}
+			// the loser may still hold a streamed body
+			go func(n int) {
+				for range n {
+					if loser := <-results; loser.resp != nil {
+						loser.resp.Body.Close()
+					}
+				}
+			}(pending)
+			p.Hedge.observe(reqParams.chainId, time.Since(reqParams.startTime))
+			*requestTraceBuilder.rt = *r.rtb.rt
+			return r.resp, r.body, nil
+		case <-timer.C:
+			if p.Hedge.allow() {
+				p.logger.Debug("hedge request", zap.String("chainId", reqParams.chainId), zap.String("method", reqParams.rpcMethod))
+				attempt(targetUrls[1:2])
+				pending++
+			}
+		}
+	}
+	*requestTraceBuilder.rt = *last.rtb.rt
+	return last.resp, last.body, last.err
+}
+
+// hedgedConn opens hedged streams for read methods on a chain
+type hedgedConn struct {
+	hedge    *HedgePolicy
+	upstream *grpcUpstream
+	primary  *grpc.ClientConn
+	chainId  string
+	logger   *zap.Logger
+}
+
+func (c *hedgedConn) Invoke(ctx context.Context, method string, args any, reply any, opts ...grpc.CallOption) error {
+	return c.primary.Invoke(ctx, method, args, reply, opts...)
+}
+
+func (c *hedgedConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	c.hedge.request()
+	delay, ok := c.hedge.delay(c.chainId)
+	s := &hedgedStream{
+		conn:    c,
+		ctx:     ctx,
+		desc:    desc,
+		method:  method,
+		opts:    opts,
+		start:   time.Now(),
+		delay:   delay,
+		noHedge: !ok,
+	}
+	if err := s.open(c.primary); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type hedgedAttempt struct {
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+}
+
+type hedgedRecv struct {
+	attempt *hedgedAttempt
+	msg     proto.Message
+	err     error
+}
+
+// hedgedStream replays the frames sent so far on a second node when the first answer is late,
+// the first attempt to answer wins and the other one is cancelled
+type hedgedStream struct {
+	conn      *hedgedConn
+	ctx       context.Context
+	desc      *grpc.StreamDesc
+	method    string
+	opts      []grpc.CallOption
+	start     time.Time
+	delay     time.Duration
+	noHedge   bool
+	mu        sync.Mutex
+	attempts  []*hedgedAttempt
+	sent      []proto.Message
+	closeSend bool
+	winner    *hedgedAttempt
+}
+
+func (s *hedgedStream) open(cc *grpc.ClientConn) error {
+	ctx, cancel := context.WithCancel(s.ctx)
+	stream, err := cc.NewStream(ctx, s.desc, s.method, s.opts...)
+	if err != nil {
+		cancel()
+		return err
+	}
+	attempt := &hedgedAttempt{stream: stream, cancel: cancel}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.sent {
+		if err = stream.SendMsg(m); err != nil {
+			cancel()
+			return err
+		}
+	}
+	if s.closeSend {
+		stream.CloseSend()
+	}
+	s.attempts = append(s.attempts, attempt)
+	return nil
+}
+
+func (s *hedgedStream) current() *hedgedAttempt {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.winner != nil {
+		return s.winner
+	}
+	return s.attempts[0]
+}
+
+func (s *hedgedStream) SendMsg(m any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.winner != nil {
+		return s.winner.stream.SendMsg(m)
+	}
+	if msg, ok := m.(proto.Message); ok {
+		// the proxy handler reuses its frame
+		s.sent = append(s.sent, proto.Clone(msg))
+	}
+	var err error
+	for _, attempt := range s.attempts {
+		err = attempt.stream.SendMsg(m)
+	}
+	return err
+}
+
+func (s *hedgedStream) CloseSend() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeSend = true
+	if s.winner != nil {
+		return s.winner.stream.CloseSend()
+	}
+	var err error
+	for _, attempt := range s.attempts {
+		err = attempt.stream.CloseSend()
+	}
+	return err
+}
+
+func (s *hedgedStream) RecvMsg(m any) error {
+	s.mu.Lock()
+	winner := s.winner
+	s.mu.Unlock()
+	if winner != nil {
+		return winner.stream.RecvMsg(m)
+	}
+
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return s.current().stream.RecvMsg(m)
+	}
+	results := make(chan hedgedRecv, 2)
+	recv := func(attempt *hedgedAttempt, frame proto.Message) {
+		err := attempt.stream.RecvMsg(frame)
+		results <- hedgedRecv{attempt: attempt, msg: frame, err: err}
+	}
+	go recv(s.attempts[0], msg.ProtoReflect().New().Interface())
+	pending := 1
+
+	var timerC <-chan time.Time
+	if !s.noHedge {
+		timer := time.NewTimer(s.delay)
+		defer timer.Stop()
+		timerC = timer.C
+	}
+	var last hedgedRecv
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			last = r
+			if r.err != nil && !errors.Is(r.err, io.EOF) && pending > 0 {
+				continue
+			}
+			return s.win(r, msg)
+		case <-timerC:
+			timerC = nil
+			s.noHedge = true
+			if !s.conn.hedge.allow() {
+				continue
+			}
+			cc, err := s.conn.upstream.getExcept(s.conn.primary)
+			if err != nil {
+				continue
+			}
+			if err = s.open(cc); err != nil {
+				s.conn.logger.Warn("open hedge stream failed", zap.Error(err), zap.String("chainId", s.conn.chainId))
+				continue
+			}
+			go recv(s.attempts[len(s.attempts)-1], msg.ProtoReflect().New().Interface())
+			pending++
+		}
+	}
+	return s.win(last, msg)
+}
+
+func (s *hedgedStream) win(r hedgedRecv, msg proto.Message) error {
+	s.mu.Lock()
+	s.winner = r.attempt
+	s.sent = nil
+	for _, attempt := range s.attempts {
+		if attempt != r.attempt {
+			attempt.cancel()
+		}
+	}
+	s.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	s.conn.hedge.observe(s.conn.chainId, time.Since(s.start))
+	proto.Reset(msg)
+	proto.Merge(msg, r.msg)
+	return nil
+}
+
+func (s *hedgedStream) Header() (metadata.MD, error) {
+	return s.current().stream.Header()
+}
+
+func (s *hedgedStream) Trailer() metadata.MD {
+	return s.current().stream.Trailer()
+}
+
+func (s *hedgedStream) Context() context.Context {
+	return s.current().stream.Context()
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLatencyTracker_percentile(t *testing.T) {
+	tracker := &latencyTracker{}
+	for i := 100; i >= 1; i-- {
+		tracker.add(time.Duration(i) * time.Millisecond)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{0.5, 50 * time.Millisecond},
+		{0.95, 95 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := tracker.percentile(tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestLatencyTracker_window(t *testing.T) {
+	tracker := &latencyTracker{}
+	for i := 0; i < hedgeSamples; i++ {
+		tracker.add(time.Second)
+	}
+	// newer samples replace the oldest ones
+	for i := 0; i < hedgeSamples; i++ {
+		tracker.add(time.Millisecond)
+	}
+	if tracker.len() != hedgeSamples {
+		t.Fatalf("expected %d samples, got %d", hedgeSamples, tracker.len())
+	}
+	if got := tracker.percentile(1); got != time.Millisecond {
+		t.Fatalf("expected old samples dropped, got %v", got)
+	}
+}
+
+func TestLatencyTracker_cachedPercentile(t *testing.T) {
+	tracker := &latencyTracker{}
+	tracker.add(10 * time.Millisecond)
+	now := time.Now()
+	if got := tracker.cachedPercentile(0.5, now); got != 10*time.Millisecond {
+		t.Fatalf("expected 10ms, got %v", got)
+	}
+	tracker.add(30 * time.Millisecond)
+	tracker.add(30 * time.Millisecond)
+	if got := tracker.cachedPercentile(0.5, now.Add(hedgeRefresh/2)); got != 10*time.Millisecond {
+		t.Fatalf("expected the cached delay, got %v", got)
+	}
+	if got := tracker.cachedPercentile(0.5, now.Add(hedgeRefresh)); got != 30*time.Millisecond {
+		t.Fatalf("expected the delay refreshed, got %v", got)
+	}
+}
+
+func TestHedgePolicy_delay(t *testing.T) {
+	hp := NewHedgePolicy()
+	hp.Percentile = 0.9
+	for i := 0; i < hedgeMinSamples-1; i++ {
+		hp.observe("1", time.Millisecond)
+	}
+	if _, ok := hp.delay("1"); ok {
+		t.Fatalf("expected no delay before %d samples", hedgeMinSamples)
+	}
+	hp.observe("1", time.Millisecond)
+	// MinDelay is the floor
+	if d, ok := hp.delay("1"); !ok || d != hp.MinDelay {
+		t.Fatalf("expected %v, got %v %v", hp.MinDelay, d, ok)
+	}
+}
+
+func TestJsonRpcProxier_hedgedForward_singleHedgeCall(t *testing.T) {
+	var calls [3]atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[0].Add(1)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer slow.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[1].Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	spare := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[2].Add(1)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer spare.Close()
+
+	p := NewJsonRpc(nil)
+	p.Hedge.Percentile = 0.5
+	p.Hedge.MinDelay = 10 * time.Millisecond
+	p.Hedge.tokens = hedgeMaxTokens
+	for range hedgeMinSamples {
+		p.Hedge.observe("1", time.Millisecond)
+	}
+	reqParams := &requestParams{
+		chainId:    "1",
+		rpcMethod:  "eth_chainId",
+		httpMethod: http.MethodPost,
+		startTime:  time.Now(),
+		headers:    http.Header{"Content-Type": []string{"application/json"}},
+		body:       []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`),
+	}
+	_, body, err := p.hedgedForward(context.Background(), NewJsonRpcRequestTraceBuilder("", ""), reqParams, []string{slow.URL, failing.URL, spare.URL})
+	if err != nil || len(body) == 0 {
+		t.Fatalf("expected the primary's answer, got %q, %v", body, err)
+	}
+	// the failed hedge is not retried on the spare node
+	if got := []int32{calls[0].Load(), calls[1].Load(), calls[2].Load()}; got[0] != 1 || got[1] != 1 || got[2] != 0 {
+		t.Fatalf("calls per node = %v, want [1 1 0]", got)
+	}
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pundix/chain-gateway/internal/client"
+	"github.com/pundix/chain-gateway/pkg/pocketbase"
+	"go.uber.org/zap"
+)
+
+// blockParamIndex is the position of the block parameter of methods that read at a given block
+var blockParamIndex = map[string]int{
+	"eth_getBlockByNumber":                    0,
+	"eth_getBlockReceipts":                    0,
+	"eth_getBlockTransactionCountByNumber":    0,
+	"eth_getTransactionByBlockNumberAndIndex": 0,
+	"eth_getUncleByBlockNumberAndIndex":       0,
+	"eth_getUncleCountByBlockNumber":          0,
+	"debug_traceBlockByNumber":                0,
+	"trace_block":                             0,
+	"eth_call":                                1,
+	"eth_estimateGas":                         1,
+	"eth_getBalance":                          1,
+	"eth_getCode":                             1,
+	"eth_getTransactionCount":                 1,
+	"debug_traceCall":                         1,
+	"eth_getStorageAt":                        2,
+	"eth_getProof":                            2,
+}
+
+// HeightTracker keeps the latest block height of every jsonrpc node.
+// Heights are measured by the BlockHeight checks, read from upstream_node, and learned from proxied responses.
+type HeightTracker struct {
+	// Interval between reads of upstream_node, 0 disables block aware routing
+	Interval time.Duration
+	logger   *zap.Logger
+	cli      *pocketbase.Client
+	mu       sync.RWMutex
+	heights  map[string]int64
+	start    sync.Once
+}
+
+func NewHeightTracker(cli *pocketbase.Client, logger *zap.Logger) *HeightTracker {
+	return &HeightTracker{
+		Interval: 15 * time.Second,
+		logger:   logger,
+		cli:      cli,
+		heights:  make(map[string]int64),
+	}
+}
+
+func (t *HeightTracker) enabled() bool {
+	return t != nil && t.Interval > 0
+}
+
+// Run reads the heights of the nodes returned by urls until the process exits
+func (t *HeightTracker) Run(urls func() []string) {
+	if !t.enabled() {
+		return
+	}
+	t.start.Do(func() {
+		go func() {
+			ticker := time.NewTicker(t.Interval)
+			defer ticker.Stop()
+			for {
+				if err := t.fetch(urls()); err != nil {
+					t.logger.Warn("fetch node heights failed", zap.Error(err))
+				}
+				<-ticker.C
+			}
+		}()
+	})
+}
+
+// fetch reads the heights the checks stored in upstream_node, nodes that left the pool are forgotten
+func (t *HeightTracker) fetch(urls []string) error {
+	items, err := t.cli.ListAllRecords("upstream_node", pocketbase.ListOptions{
+		Filter: fmt.Sprintf("protocol = '%s' && height > 0", client.PROTOCOL_JSONRPC),
+	})
+	if err != nil {
+		return err
+	}
+	checked := make(map[string]int64, len(items))
+	for _, record := range items {
+		url, _ := record["url"].(string)
+		height, _ := record["height"].(float64)
+		// a node checked by several rules keeps its highest height
+		checked[url] = max(checked[url], int64(height))
+	}
+	t.update(urls, checked)
+	return nil
+}
+
+// update replaces the heights of the pool by the checked ones, a height learned since is kept when higher
+func (t *HeightTracker) update(urls []string, checked map[string]int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	heights := make(map[string]int64, len(urls))
+	for _, url := range urls {
+		if height := max(checked[url], t.heights[url]); height > 0 {
+			heights[url] = height
+		}
+	}
+	t.heights = heights
+}
+
+// observe raises the known height of a node, heights never go down until the node leaves the pool
+func (t *HeightTracker) observe(url string, height int64) {
+	if !t.enabled() || height <= 0 {
+		return
+	}
+	t.mu.Lock()
+	if height > t.heights[url] {
+		t.heights[url] = height
+	}
+	t.mu.Unlock()
+}
+
+// observeResponse learns the height of a node from its eth_blockNumber response
+func (t *HeightTracker) observeResponse(url, method string, body []byte) {
+	if !t.enabled() || method != "eth_blockNumber" {
+		return
+	}
+	var ret struct {
+		Result string `json:"result"`
+	}
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return
+	}
+	if height, err := parseBlockNumber(ret.Result); err == nil {
+		t.observe(url, height)
+	}
+}
+
+func (t *HeightTracker) height(url string) (int64, bool) {
+	if !t.enabled() {
+		return 0, false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	height, ok := t.heights[url]
+	return height, ok
+}
+
+// covering keeps the urls whose height reaches block, nodes of unknown height are kept as well.
+// When no node has reached block all urls are returned, the block may simply not exist yet.
+func (t *HeightTracker) covering(urls []string, block int64) []string {
+	if !t.enabled() || block <= 0 || len(urls) == 0 {
+		return urls
+	}
+	ret := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if height, ok := t.height(url); !ok || height >= block {
+			ret = append(ret, url)
+		}
+	}
+	if len(ret) == 0 {
+		return urls
+	}
+	return ret
+}
+
+// head returns the highest known height among urls, 0 when none is known
+func (t *HeightTracker) head(urls []string) int64 {
+	var head int64
+	for _, url := range urls {
+		if height, ok := t.height(url); ok && height > head {
+			head = height
+		}
+	}
+	return head
+}
+
+// aheadFirst returns a copy of urls with the nodes known to have reached block first, in their order
+func (t *HeightTracker) aheadFirst(urls []string, block int64) []string {
+	ret := make([]string, 0, len(urls))
+	var behind []string
+	for _, url := range urls {
+		if height, ok := t.height(url); ok && height >= block {
+			ret = append(ret, url)
+		} else {
+			behind = append(behind, url)
+		}
+	}
+	return append(ret, behind...)
+}
+
+// ahead reports whether one of urls is known to have reached block
+func (t *HeightTracker) ahead(urls []string, block int64) bool {
+	for _, url := range urls {
+		if height, ok := t.height(url); ok && height >= block {
+			return true
+		}
+	}
+	return false
+}
+
+// requestedBlock returns the block number a single jsonrpc request reads at, 0 for tags, hashes and batches
+func requestedBlock(body []byte) int64 {
+	var req jsonRpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0
+	}
+	idx, ok := blockParamIndex[req.Method]
+	if !ok {
+		return 0
+	}
+	var params []json.RawMessage
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) <= idx {
+		return 0
+	}
+	var tag string
+	if err := json.Unmarshal(params[idx], &tag); err != nil {
+		// EIP-1898 block parameter
+		var obj struct {
+			BlockNumber string `json:"blockNumber"`
+		}
+		if err = json.Unmarshal(params[idx], &obj); err != nil {
+			return 0
+		}
+		tag = obj.BlockNumber
+	}
+	block, err := parseBlockNumber(tag)
+	if err != nil {
+		return 0
+	}
+	return block
+}
+
+// parseBlockNumber parses a hex quantity, block tags such as latest are rejected
+func parseBlockNumber(val string) (int64, error) {
+	if !strings.HasPrefix(val, "0x") {
+		return 0, fmt.Errorf("invalid block number: %s", val)
+	}
+	return strconv.ParseInt(strings.TrimPrefix(val, "0x"), 16, 64)
+}
+
+// nullResult reports whether a jsonrpc response has a null result and no error
+func nullResult(body []byte) bool {
+	var ret struct {
+		Result json.RawMessage `json:"result"`
+		Error  *jsonRpcError   `json:"error"`
+	}
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return false
+	}
+	return ret.Error == nil && (len(ret.Result) == 0 || string(ret.Result) == "null")
+}
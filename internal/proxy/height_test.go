@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRequestedBlock(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int64
+	}{
+		{name: "block number", body: `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByNumber","params":["0x10",false]}`, want: 16},
+		{name: "block tag", body: `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByNumber","params":["latest",false]}`},
+		{name: "second param", body: `{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0xabc","0x20"]}`, want: 32},
+		{name: "third param", body: `{"jsonrpc":"2.0","id":1,"method":"eth_getStorageAt","params":["0xabc","0x0","0x30"]}`, want: 48},
+		{name: "eip-1898 number", body: `{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[{},{"blockNumber":"0x40"}]}`, want: 64},
+		{name: "eip-1898 hash", body: `{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[{},{"blockHash":"0xdef"}]}`},
+		{name: "missing param", body: `{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0xabc"]}`},
+		{name: "not block specific", body: `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`},
+		{name: "batch", body: `[{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByNumber","params":["0x10",false]}]`},
+		{name: "invalid json", body: `{`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestedBlock([]byte(tt.body)); got != tt.want {
+				t.Errorf("requestedBlock() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestHeightTracker(heights map[string]int64) *HeightTracker {
+	t := NewHeightTracker(nil, zap.NewNop())
+	t.heights = heights
+	return t
+}
+
+func TestHeightTracker_covering(t *testing.T) {
+	heights := newTestHeightTracker(map[string]int64{"a": 100, "b": 90})
+	urls := []string{"a", "b", "c"}
+	tests := []struct {
+		name  string
+		block int64
+		want  []string
+	}{
+		{name: "every node reached it", block: 80, want: urls},
+		{name: "unknown heights are kept", block: 95, want: []string{"a", "c"}},
+		{name: "no known node reached it", block: 200, want: []string{"c"}},
+		{name: "not block specific", want: urls},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := heights.covering(urls, tt.block); !slices.Equal(got, tt.want) {
+				t.Errorf("covering(%d) = %v, want %v", tt.block, got, tt.want)
+			}
+		})
+	}
+
+	// when no node reached the block it may not exist yet, every node is kept
+	if got := newTestHeightTracker(map[string]int64{"a": 100}).covering([]string{"a"}, 200); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("covering of an unreached block = %v, want [a]", got)
+	}
+	if got := (&HeightTracker{}).covering(urls, 95); !slices.Equal(got, urls) {
+		t.Errorf("disabled tracker covering = %v, want %v", got, urls)
+	}
+}
+
+func TestHeightTracker_aheadFirst(t *testing.T) {
+	heights := newTestHeightTracker(map[string]int64{"a": 90, "b": 100, "d": 110})
+	urls := []string{"a", "b", "c", "d"}
+	if got, want := heights.aheadFirst(urls, 100), []string{"b", "d", "a", "c"}; !slices.Equal(got, want) {
+		t.Errorf("aheadFirst = %v, want %v", got, want)
+	}
+	if !slices.Equal(urls, []string{"a", "b", "c", "d"}) {
+		t.Errorf("aheadFirst modified its input: %v", urls)
+	}
+}
+
+func TestJsonRpcProxier_lagging(t *testing.T) {
+	p := &JsonRpcProxier{Heights: newTestHeightTracker(map[string]int64{"a": 90, "b": 100})}
+	null := []byte(`{"jsonrpc":"2.0","id":1,"result":null}`)
+	tests := []struct {
+		name      string
+		url       string
+		block     int64
+		body      []byte
+		remaining []string
+		want      bool
+	}{
+		{name: "behind with a node ahead", url: "a", block: 100, body: null, remaining: []string{"c", "b"}, want: true},
+		{name: "no remaining node ahead", url: "a", block: 100, body: null, remaining: []string{"c"}},
+		{name: "node reached the block", url: "b", block: 100, body: null, remaining: []string{"a"}},
+		{name: "result is not null", url: "a", block: 100, body: []byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`), remaining: []string{"b"}},
+		{name: "not block specific", url: "a", body: null, remaining: []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.lagging(tt.url, tt.block, tt.body, tt.remaining); got != tt.want {
+				t.Errorf("lagging() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeightTracker_update(t *testing.T) {
+	heights := newTestHeightTracker(map[string]int64{"a": 120, "b": 90, "gone": 100})
+	heights.update([]string{"a", "b", "c"}, map[string]int64{"a": 110, "b": 100, "gone": 100})
+	want := map[string]int64{"a": 120, "b": 100}
+	for url, height := range want {
+		if got, ok := heights.height(url); !ok || got != height {
+			t.Errorf("height(%s) = %d, %v, want %d", url, got, ok, height)
+		}
+	}
+	for _, url := range []string{"c", "gone"} {
+		if _, ok := heights.height(url); ok {
+			t.Errorf("height of %s kept", url)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pundix/chain-gateway/internal/client"
+	"github.com/pundix/chain-gateway/pkg/pocketbase"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+)
+
+var httpPathRegexp = regexp.MustCompile(`^/v1/([a-z0-9\-]+)/([a-z0-9]{32})(/.*)?$`)
+
+// HttpProxier proxies plain http upstreams such as cosmos rest (lcd) and cometbft rpc
+type HttpProxier struct {
+	Addr           string
+	Protocol       client.Protocol
+	Duration       time.Duration
+	Timeout        *TimeoutPolicy
+	Breaker        *BreakerPolicy
+	Affinity       *AffinityPolicy
+	Usage          *UsageRecorder
+	AdminAddr      string
+	AdminToken     string
+	logger         *zap.Logger
+	cli            *pocketbase.Client
+	httpCli        *http.Client
+	secretKeys     *secretKeyStore
+	upstreamCaches *jsonRpcUpstreamCaches
+	nodes          *nodeMonitor
+}
+
+func NewHttp(cli *pocketbase.Client, protocol client.Protocol) *HttpProxier {
+	logger, _ := zap.NewDevelopment(zap.IncreaseLevel(zap.InfoLevel))
+	addr := "0.0.0.0:1317"
+	if protocol == client.PROTOCOL_COMETBFT {
+		addr = "0.0.0.0:26657"
+	}
+	breaker := NewBreakerPolicy()
+	return &HttpProxier{
+		Addr:           addr,
+		Protocol:       protocol,
+		Duration:       5 * time.Minute,
+		Timeout:        NewTimeoutPolicy(),
+		Breaker:        breaker,
+		Affinity:       NewAffinityPolicy(),
+		Usage:          NewUsageRecorder(cli, logger),
+		logger:         logger,
+		cli:            cli,
+		httpCli:        &http.Client{},
+		secretKeys:     newSecretKeyStore(cli, logger),
+		upstreamCaches: &jsonRpcUpstreamCaches{},
+		nodes:          newNodeMonitor(breaker, logger),
+	}
+}
+
+func (p *HttpProxier) Fetch() {
+	p.fetchUpstream()
+	p.Usage.Run()
+	go func() {
+		ticker := time.NewTicker(p.Duration)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			p.fetchUpstream()
+		}
+	}()
+}
+
+func (p *HttpProxier) Proxy() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/", p.handle)
+	srv := &http.Server{
+		Addr:    p.Addr,
+		Handler: mux,
+	}
+
+	errC := make(chan error)
+	if p.AdminAddr != "" {
+		admin := &adminServer{
+			protocol:   string(p.Protocol),
+			token:      p.AdminToken,
+			logger:     p.logger,
+			nodes:      p.nodes,
+			secretKeys: p.secretKeys,
+			pools:      p.upstreamCaches.pools,
+			refresh:    p.fetchUpstream,
+		}
+		adminSrv, err := admin.start(p.AdminAddr, errC)
+		if err != nil {
+			return err
+		}
+		defer adminSrv.Close()
+	}
+	go func() {
+		p.logger.Info("listening on", zap.String("address", p.Addr), zap.String("protocol", string(p.Protocol)))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errC <- err
+		}
+	}()
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case <-sigC:
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := srv.Shutdown(ctx)
+		p.Usage.Flush()
+		p.logger.Info("http server stopped", zap.String("protocol", string(p.Protocol)))
+		return err
+	case err := <-errC:
+		return err
+	}
+}
+
+// handle serves /v1/{chainId}/{accessKey}/{path}, the path and query are passed to the upstream as is
+func (p *HttpProxier) handle(w http.ResponseWriter, req *http.Request) {
+	startTime := time.Now()
+	match := httpPathRegexp.FindStringSubmatch(req.URL.Path)
+	if len(match) == 0 {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	chainId, accessKey, path := match[1], match[2], match[3]
+
+	// auth
+	sk, ok := p.secretKeys.verify(accessKey)
+	if !ok {
+		http.Error(w, "invalid access key", http.StatusUnauthorized)
+		return
+	}
+	accessControlAllowOrigin, code, err := allowOrigin(sk, req)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if req.Method == http.MethodOptions {
+		handleCors(w, accessControlAllowOrigin)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if req.Method != http.MethodPost && req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", accessControlAllowOrigin)
+
+	// source and service belong to the gateway, everything else goes upstream
+	query := req.URL.Query()
+	source := query.Get("source")
+	service := query.Get("service")
+	if service == "" {
+		service = sk.Service
+	}
+	query.Del("source")
+	query.Del("service")
+
+	requestTraceBuilder := NewJsonRpcRequestTraceBuilder(service, sk.Group).
+		WithChainIdAndSource(chainId, source).
+		WithAccessKey(accessKey)
+	rt := requestTraceBuilder.rt
+	rt.Protocol = string(p.Protocol)
+	rt.Method = req.Method + " " + path
+	rt.VisitorIp = visitorIp(req)
+	rt.Origin = req.Header.Get("origin")
+
+	targetUrls := p.getChainEndpoints(chainId, source, p.Affinity.httpKey(accessKey, req))
+	if len(targetUrls) == 0 {
+		http.Error(w, "chainId not support, no available nodes", http.StatusBadRequest)
+		p.trace(requestTraceBuilder.WithError(http.StatusBadRequest, "no available nodes").Build())
+		return
+	}
+
+	defer req.Body.Close()
+	reqBodyBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	reqParams := &requestParams{
+		accessKey:  accessKey,
+		source:     source,
+		chainId:    chainId,
+		rpcMethod:  path,
+		httpMethod: req.Method,
+		startTime:  startTime,
+		headers:    req.Header.Clone(),
+		body:       reqBodyBytes,
+	}
+
+	ctx, cancel := p.Timeout.apply(req.Context(), chainId, path)
+	defer cancel()
+	resp, respBodyBytes, err := p.forward(ctx, requestTraceBuilder, reqParams, targetUrls, path, query.Encode())
+	if err != nil {
+		if _, ok := err.(*RetryableError); !ok {
+			http.Error(w, "upstream unavailable", http.StatusBadGateway)
+			p.trace(requestTraceBuilder.WithError(http.StatusBadGateway, err.Error()).Build())
+			return
+		}
+	}
+	requestTraceBuilder.WithVersion(jsonRpcVersion)
+
+	for k, values := range resp.Header {
+		if k == "Content-Length" {
+			continue
+		}
+		for _, v := range values {
+			w.Header().Set(k, v)
+		}
+	}
+	w.Header().Set("X-CGV2-Version", jsonRpcVersion)
+	if rt.Status == strconv.Itoa(http.StatusMultiStatus) {
+		// large response, copy body to writer
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(respBodyBytes)))
+		w.WriteHeader(resp.StatusCode)
+		w.Write(respBodyBytes)
+	}
+	p.trace(rt)
+}
+
+// forward tries the targets in order, only server errors are retried on the next node
+func (p *HttpProxier) forward(ctx context.Context, requestTraceBuilder *JsonRpcRequestTraceBuilder, reqParams *requestParams, targetUrls []string, path, rawQuery string) (*http.Response, []byte, error) {
+	var respBodyBytes []byte
+	resp, err := callFuncWithRetry(len(targetUrls), func(i int) (*http.Response, error) {
+		if i != 0 {
+			requestTraceBuilder.IncrementRetries()
+		}
+		targetUrl := strings.TrimRight(targetUrls[i], "/") + path
+		if rawQuery != "" {
+			targetUrl += "?" + rawQuery
+		}
+		requestTraceBuilder.WithUpstreamNode(targetUrls[i])
+
+		var body io.Reader
+		if len(reqParams.body) > 0 {
+			body = bytes.NewReader(reqParams.body)
+		}
+		r, err := http.NewRequestWithContext(ctx, reqParams.httpMethod, targetUrl, body)
+		if err != nil {
+			return nil, err
+		}
+		r.Header = reqParams.headers.Clone()
+		r.Header.Del("Accept-Encoding")
+		r.Header.Del("Content-Length")
+
+		nodeStart, err := p.nodes.begin(targetUrls[i])
+		if err != nil {
+			return nil, err
+		}
+		resp, err := p.httpCli.Do(r)
+		if err != nil {
+			p.nodes.end(ctx, targetUrls[i], true, time.Since(nodeStart))
+			return nil, err
+		}
+		failed := resp.StatusCode >= http.StatusInternalServerError
+		defer func() {
+			p.nodes.end(ctx, targetUrls[i], failed, time.Since(nodeStart))
+		}()
+		latency := time.Since(reqParams.startTime).Milliseconds()
+		if resp.ContentLength > maxTracedResponseSize {
+			requestTraceBuilder.WithLargeResponse(latency)
+			return resp, nil
+		}
+		defer resp.Body.Close()
+
+		respBodyBytes, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		requestTraceBuilder.rt.Latency = latency
+		if resp.StatusCode >= http.StatusInternalServerError {
+			requestTraceBuilder.WithError(resp.StatusCode, string(respBodyBytes))
+			return resp, &RetryableError{
+				Code:    resp.StatusCode,
+				Message: resp.Status,
+			}
+		}
+		requestTraceBuilder.WithError(resp.StatusCode, http.StatusText(resp.StatusCode))
+		return resp, nil
+	})
+	return resp, respBodyBytes, err
+}
+
+// getChainEndpoints returns the shuffled ready urls of a chain, paid sources are only used on request.
+// Requests with a session get the urls in their rendezvous order instead.
+func (p *HttpProxier) getChainEndpoints(chainId, source, affinityKey string) []string {
+	var urls []string
+	for s, sourceUrls := range p.upstreamCaches.get(chainId) {
+		if source == "" && strings.Contains(s, "paid") {
+			continue
+		}
+		if source != "" && s != source {
+			continue
+		}
+		urls = append(urls, sourceUrls...)
+	}
+	urls = p.nodes.filter(lo.Uniq(urls))
+	if affinityKey != "" {
+		return rendezvousOrder(affinityKey, urls)
+	}
+	return lo.Shuffle(urls)
+}
+
+func (p *HttpProxier) trace(rt *JsonRpcRequestTrace) {
+	p.logger.Info("reached endpoint", zap.Any("request trace", rt))
+	p.Usage.recordJsonRpc(rt)
+}
+
+func (p *HttpProxier) fetchUpstream() {
+	upstreams, err := fetchReadyUpstreams(p.cli, p.Protocol)
+	p.nodes.refreshed(err)
+	if err != nil {
+		p.logger.Error("fetch upstream failed", zap.Error(err), zap.String("protocol", string(p.Protocol)))
+		return
+	}
+	p.upstreamCaches.set(upstreams)
+	p.logger.Info("fetch upstream success", zap.Any("count", len(upstreams)), zap.String("protocol", string(p.Protocol)))
+}
@@ -0,0 +1,661 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pundix/chain-gateway/internal/client"
+	"github.com/pundix/chain-gateway/pkg/pocketbase"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+)
+
+const (
+	jsonRpcVersion = "v2.1"
+	// responses larger than this are streamed to the client without being traced
+	maxTracedResponseSize = 5 * 1024 * 1024
+)
+
+type JsonRpcProxier struct {
+	Addr       string
+	Duration   time.Duration
+	Timeout    *TimeoutPolicy
+	Hedge      *HedgePolicy
+	Breaker    *BreakerPolicy
+	Affinity   *AffinityPolicy
+	Heights    *HeightTracker
+	Usage      *UsageRecorder
+	AdminAddr  string
+	AdminToken string
+	// per access key websocket limits, 0 means unlimited
+	WsMaxConnections   int
+	WsMaxSubscriptions int
+	logger             *zap.Logger
+	cli                *pocketbase.Client
+	httpCli            *http.Client
+	secretKeys         *secretKeyStore
+	upstreamCaches     *jsonRpcUpstreamCaches
+	nodes              *nodeMonitor
+	capabilities       *capabilityStore
+	routeRules         map[string]methodRouteRule
+	routeMu            sync.RWMutex
+	wsLimiter          *wsLimiter
+	wsUpstreams        *wsUpstreamPool
+}
+
+func NewJsonRpc(cli *pocketbase.Client) *JsonRpcProxier {
+	logger, _ := zap.NewDevelopment(zap.IncreaseLevel(zap.InfoLevel))
+	breaker := NewBreakerPolicy()
+	httpCli := &http.Client{}
+	return &JsonRpcProxier{
+		Addr:           "0.0.0.0:8545",
+		Duration:       5 * time.Minute,
+		Timeout:        NewTimeoutPolicy(),
+		Hedge:          NewHedgePolicy(),
+		Breaker:        breaker,
+		Affinity:       NewAffinityPolicy(),
+		Heights:        NewHeightTracker(cli, logger),
+		Usage:          NewUsageRecorder(cli, logger),
+		logger:         logger,
+		cli:            cli,
+		httpCli:        httpCli,
+		secretKeys:     newSecretKeyStore(cli, logger),
+		upstreamCaches: &jsonRpcUpstreamCaches{},
+		nodes:          newNodeMonitor(breaker, logger),
+		capabilities:   newCapabilityStore(),
+		wsLimiter:      newWsLimiter(),
+		wsUpstreams:    newWsUpstreamPool(logger),
+	}
+}
+
+func (p *JsonRpcProxier) Fetch() {
+	p.fetchUpstream()
+	p.Usage.Run()
+	p.Heights.Run(p.upstreamCaches.urls)
+	p.fetchRouteRules()
+	p.fetchCapabilities()
+	go func() {
+		ticker := time.NewTicker(p.Duration)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			p.fetchUpstream()
+			p.fetchRouteRules()
+			p.fetchCapabilities()
+		}
+	}()
+}
+
+func (p *JsonRpcProxier) Proxy() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/", p.handleV1)
+	mux.HandleFunc("/v2/", p.handleV2)
+	srv := &http.Server{
+		Addr:    p.Addr,
+		Handler: mux,
+	}
+
+	errC := make(chan error)
+	if p.AdminAddr != "" {
+		admin := &adminServer{
+			protocol:   string(client.PROTOCOL_JSONRPC),
+			token:      p.AdminToken,
+			logger:     p.logger,
+			nodes:      p.nodes,
+			secretKeys: p.secretKeys,
+			pools:      p.upstreamCaches.pools,
+			heights:    p.Heights.height,
+			tags:       p.capabilities.get,
+			refresh:    p.fetchUpstream,
+		}
+		adminSrv, err := admin.start(p.AdminAddr, errC)
+		if err != nil {
+			return err
+		}
+		defer adminSrv.Close()
+	}
+	go func() {
+		p.logger.Info("listening on", zap.String("address", p.Addr))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errC <- err
+		}
+	}()
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case <-sigC:
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := srv.Shutdown(ctx)
+		p.Usage.Flush()
+		p.logger.Info("jsonrpc server stopped")
+		return err
+	case err := <-errC:
+		return err
+	}
+}
+
+func (p *JsonRpcProxier) handleV1(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := parseV1PathParameters(req.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.handle(reqParams, w, req)
+}
+
+func (p *JsonRpcProxier) handleV2(w http.ResponseWriter, req *http.Request) {
+	reqParams, err := parseV2PathParameters(req.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.handle(reqParams, w, req)
+}
+
+func (p *JsonRpcProxier) handle(reqParams *requestParams, w http.ResponseWriter, req *http.Request) {
+	reqParams.startTime = time.Now()
+
+	// auth
+	sk, ok := p.secretKeys.verify(reqParams.accessKey)
+	if !ok {
+		http.Error(w, "invalid access key", http.StatusUnauthorized)
+		return
+	}
+	accessControlAllowOrigin, code, err := allowOrigin(sk, req)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	reqParams.affinityKey = p.Affinity.httpKey(reqParams.accessKey, req)
+
+	if req.Method == http.MethodOptions {
+		// support cors
+		handleCors(w, accessControlAllowOrigin)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if req.Method != http.MethodPost && req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", accessControlAllowOrigin)
+
+	query := req.URL.Query()
+	if reqParams.chainId == "" {
+		reqParams.chainId = query.Get("chainId")
+	}
+	if reqParams.chainId == "" {
+		http.Error(w, "chainId is required", http.StatusBadRequest)
+		return
+	}
+	reqParams.source = query.Get("source")
+
+	service := query.Get("service")
+	if service == "" {
+		service = sk.Service
+	}
+	if req.Method == http.MethodGet && isWebSocketUpgrade(req) {
+		p.handleWebSocket(reqParams, sk, service, w, req)
+		return
+	}
+	if req.Method == http.MethodGet {
+		p.handleGetMethod(reqParams, w)
+		return
+	}
+
+	requestTraceBuilder := NewJsonRpcRequestTraceBuilder(service, sk.Group)
+	defer req.Body.Close()
+	reqBodyBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if err = requestTraceBuilder.WithRequest(reqBodyBytes, req); err != nil {
+		http.Error(w, "failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	reqParams.rpcMethod = requestTraceBuilder.rt.Method
+	reqParams.httpMethod = req.Method
+	reqParams.body = reqBodyBytes
+	reqParams.headers = req.Header.Clone()
+	if p.Heights.enabled() {
+		reqParams.block = requestedBlock(reqBodyBytes)
+	}
+
+	if err = p.applyRouteRules(reqParams, sk); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.handlePostMethod(req.Context(), requestTraceBuilder, reqParams, w)
+}
+
+func allowOrigin(sk *client.SecretKey, req *http.Request) (string, int, error) {
+	if sk.AllowOrigins == "" {
+		return "*", http.StatusOK, nil
+	}
+	regex, err := regexp.Compile(sk.AllowOrigins)
+	if err != nil {
+		return "", http.StatusInternalServerError, errors.New("invalid allow origins")
+	}
+	if !regex.MatchString(req.Header.Get("Origin")) {
+		return "", http.StatusForbidden, errors.New("origin not allowed")
+	}
+	return req.Header.Get("Origin"), http.StatusOK, nil
+}
+
+func handleCors(w http.ResponseWriter, origin string) {
+	w.Header().Add("Access-Control-Allow-Origin", origin)
+	w.Header().Add("Access-Control-Allow-Methods", "GET,POST,OPTIONS")
+	w.Header().Add("Access-Control-Max-Age", "86400")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type")
+}
+
+func (p *JsonRpcProxier) applyRouteRules(reqParams *requestParams, sk *client.SecretKey) error {
+	// global route rule
+	routeRules := make(map[string]methodRouteRule)
+	p.routeMu.RLock()
+	for k, routeRule := range p.routeRules {
+		routeRules[k] = routeRule
+	}
+	p.routeMu.RUnlock()
+
+	if sk.RouteRules != "" {
+		var skRouteRules map[string]methodRouteRule
+		if err := json.Unmarshal([]byte(sk.RouteRules), &skRouteRules); err != nil {
+			return err
+		}
+		// override global route rule
+		for k, routeRule := range skRouteRules {
+			routeRules[k] = routeRule
+		}
+	}
+
+	// method route rule
+	if rule, ok := routeRules[reqParams.rpcMethod]; ok && rule.match(reqParams.chainId) {
+		reqParams.source = rule.Source
+	}
+	return nil
+}
+
+func (p *JsonRpcProxier) handlePostMethod(ctx context.Context, requestTraceBuilder *JsonRpcRequestTraceBuilder, reqParams *requestParams, w http.ResponseWriter) {
+	requestTraceBuilder.WithChainIdAndSource(reqParams.chainId, reqParams.source).
+		WithAccessKey(reqParams.accessKey)
+	targetUrls, code, err := p.selectTargets(reqParams, requestTraceBuilder)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		p.trace(requestTraceBuilder.WithError(code, err.Error()).Build())
+		return
+	}
+
+	ctx, cancel := p.Timeout.apply(ctx, reqParams.chainId, reqParams.rpcMethod)
+	defer cancel()
+	resp, respBodyBytes, err := p.hedgedForward(ctx, requestTraceBuilder, reqParams, targetUrls)
+	if err != nil {
+		if _, ok := err.(*RetryableError); !ok {
+			http.Error(w, "upstream unavailable", http.StatusBadGateway)
+			p.trace(requestTraceBuilder.WithError(http.StatusBadGateway, err.Error()).Build())
+			return
+		}
+	}
+	requestTraceBuilder.WithVersion(jsonRpcVersion)
+
+	for k, values := range resp.Header {
+		if k == "Content-Length" {
+			continue
+		}
+		for _, v := range values {
+			w.Header().Set(k, v)
+		}
+	}
+	w.Header().Set("X-CGV2-Version", jsonRpcVersion)
+
+	rt := requestTraceBuilder.Build()
+	if rt.Status == strconv.Itoa(http.StatusMultiStatus) {
+		// large response, copy body to writer
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	} else if len(respBodyBytes) == 0 {
+		w.WriteHeader(resp.StatusCode)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(respBodyBytes)))
+		w.WriteHeader(resp.StatusCode)
+		w.Write(respBodyBytes)
+	}
+	p.trace(rt)
+}
+
+// selectTargets picks the upstream urls to try in order, following the free/paid/mev routing of the worker
+func (p *JsonRpcProxier) selectTargets(reqParams *requestParams, requestTraceBuilder *JsonRpcRequestTraceBuilder) ([]string, int, error) {
+	endpointMap := p.getChainEndpoints(reqParams)
+	if len(endpointMap) == 0 {
+		return nil, http.StatusBadRequest, errors.New("chainId not support, no available nodes")
+	}
+	if reqParams.block > 0 {
+		for k, urls := range endpointMap {
+			endpointMap[k] = p.Heights.covering(urls, reqParams.block)
+		}
+	}
+	head := p.Heights.head(slices.Concat(endpointMap["free"], endpointMap["paid"]))
+	if need := requiredCapability(reqParams.rpcMethod, reqParams.body, reqParams.block, head); need.tag != "" {
+		for k, urls := range endpointMap {
+			endpointMap[k] = p.capabilities.filter(urls, need)
+		}
+	}
+	if reqParams.source != "" {
+		source := reqParams.source
+		if !reqParams.isPaidMode() {
+			source = "free"
+		}
+		if len(endpointMap[source]) == 0 {
+			return nil, http.StatusBadRequest, errors.New("source not support, no available nodes")
+		}
+	}
+
+	var targetUrls []string
+	if reqParams.isTxMethod() {
+		requestTraceBuilder.WithMode("paid_tx")
+		arr := endpointMap["paid"]
+		if reqParams.isMevMode() {
+			requestTraceBuilder.WithMode("mev_tx")
+			arr = endpointMap["free"]
+		} else if len(arr) == 0 {
+			requestTraceBuilder.WithMode("free_tx")
+			arr = endpointMap["free"]
+		}
+		if len(arr) == 0 {
+			return nil, http.StatusBadRequest, errors.New("chainId or source not support, no available nodes")
+		}
+		targetUrls = append(targetUrls, orderTargets(arr, 1, reqParams.affinityKey)...)
+	} else if reqParams.isPaidMode() {
+		requestTraceBuilder.WithMode("paid_query")
+		targetUrls = orderTargets(endpointMap["paid"], 3, reqParams.affinityKey)
+	} else {
+		requestTraceBuilder.WithMode("free_query")
+		targetUrls = orderTargets(endpointMap["free"], 3, reqParams.affinityKey)
+		if !reqParams.isMevMode() {
+			if arr := endpointMap["paid"]; len(arr) > 0 {
+				targetUrls = append(targetUrls, orderTargets(arr, 1, reqParams.affinityKey)...)
+			}
+		}
+	}
+	if len(targetUrls) == 0 {
+		return nil, http.StatusBadRequest, errors.New("chainId or source not support, no available nodes")
+	}
+	return targetUrls, http.StatusOK, nil
+}
+
+// orderTargets returns the first n urls to try, the session's node leads when the request has a session
+func orderTargets(arr []string, n int, affinityKey string) []string {
+	if affinityKey == "" {
+		return shuffleTop(arr, n)
+	}
+	ordered := rendezvousOrder(affinityKey, arr)
+	if len(ordered) > n {
+		return ordered[:n]
+	}
+	return ordered
+}
+
+func shuffleTop(arr []string, n int) []string {
+	rand.Shuffle(len(arr), func(i, j int) {
+		arr[i], arr[j] = arr[j], arr[i]
+	})
+	if len(arr) > n {
+		return arr[:n]
+	}
+	return arr
+}
+
+func (p *JsonRpcProxier) forward(ctx context.Context, requestTraceBuilder *JsonRpcRequestTraceBuilder, reqParams *requestParams, targetUrls []string) (*http.Response, []byte, error) {
+	var respBodyBytes []byte
+	resp, err := callFuncWithRetry(len(targetUrls), func(i int) (*http.Response, error) {
+		if i != 0 {
+			requestTraceBuilder.IncrementRetries()
+		}
+		targetUrl := targetUrls[i]
+		requestTraceBuilder.WithUpstreamNode(targetUrl)
+
+		r, err := http.NewRequestWithContext(ctx, reqParams.httpMethod, targetUrl, bytes.NewReader(reqParams.body))
+		if err != nil {
+			return nil, err
+		}
+		r.Header = reqParams.headers.Clone()
+		// let the transport negotiate compression so the response can be traced
+		r.Header.Del("Accept-Encoding")
+		r.Header.Del("Content-Length")
+
+		nodeStart, err := p.nodes.begin(targetUrl)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := p.httpCli.Do(r)
+		if err != nil {
+			p.nodes.end(ctx, targetUrl, true, time.Since(nodeStart))
+			return nil, err
+		}
+		failed := true
+		defer func() {
+			p.nodes.end(ctx, targetUrl, failed, time.Since(nodeStart))
+		}()
+
+		if resp.ContentLength > maxTracedResponseSize {
+			failed = false
+			requestTraceBuilder.WithLargeResponse(time.Since(reqParams.startTime).Milliseconds())
+			return resp, nil
+		}
+		defer resp.Body.Close()
+
+		respBodyBytes, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err = requestTraceBuilder.WithResponse(resp.StatusCode, respBodyBytes, time.Since(reqParams.startTime).Milliseconds()); err != nil {
+			return nil, err
+		}
+		if requestTraceBuilder.rt.ok() {
+			failed = false
+			p.Heights.observeResponse(targetUrl, reqParams.rpcMethod, respBodyBytes)
+			if p.lagging(targetUrl, reqParams.block, respBodyBytes, targetUrls[i+1:]) {
+				// retry on a node that has the block, targetUrls may be shared with a hedged attempt so it is copied
+				targetUrls = append(targetUrls[:i+1:i+1], p.Heights.aheadFirst(targetUrls[i+1:], reqParams.block)...)
+				return resp, &RetryableError{Code: resp.StatusCode, Message: "block not reached by node"}
+			}
+			return resp, nil
+		}
+		return resp, &RetryableError{
+			Code:    resp.StatusCode,
+			Message: resp.Status,
+		}
+	})
+	return resp, respBodyBytes, err
+}
+
+// lagging reports whether a null result may come from a node behind the requested block,
+// so the request is worth retrying on one of the remaining urls that has reached it
+func (p *JsonRpcProxier) lagging(url string, block int64, body []byte, remaining []string) bool {
+	if block <= 0 || !nullResult(body) {
+		return false
+	}
+	if height, ok := p.Heights.height(url); ok && height >= block {
+		return false
+	}
+	return p.Heights.ahead(remaining, block)
+}
+
+func (p *JsonRpcProxier) handleGetMethod(reqParams *requestParams, w http.ResponseWriter) {
+	endpointMap := p.getChainEndpoints(reqParams)
+	if len(endpointMap) == 0 {
+		http.Error(w, "chainId not support, no available nodes", http.StatusBadRequest)
+		return
+	}
+	if reqParams.source != "" {
+		source := reqParams.source
+		if !reqParams.isPaidMode() {
+			source = "free"
+		}
+		if _, ok := endpointMap[source]; !ok {
+			http.Error(w, "source not support, no available nodes", http.StatusBadRequest)
+			return
+		}
+		endpointMap = map[string][]string{
+			reqParams.source: endpointMap[source],
+		}
+	}
+
+	ret := []string{}
+	// desensitize
+	for _, endpoints := range endpointMap {
+		for _, endpoint := range endpoints {
+			ret = append(ret, desensitize(endpoint))
+		}
+	}
+
+	jsonBytes, err := json.Marshal(ret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+var (
+	apiKeyRegexp   = regexp.MustCompile(`.*/([a-z0-9]{32})`)
+	nodeRealRegexp = regexp.MustCompile(`.*/([a-zA-Z-]{21})`)
+)
+
+func desensitize(endpoint string) string {
+	for _, re := range []*regexp.Regexp{apiKeyRegexp, nodeRealRegexp} {
+		if match := re.FindStringSubmatch(endpoint); len(match) == 2 {
+			endpoint = strings.Replace(endpoint, match[1], "REDACTED", 1)
+		}
+	}
+	return endpoint
+}
+
+// getChainEndpoints returns copies of the cached urls, split into free and paid
+func (p *JsonRpcProxier) getChainEndpoints(reqParams *requestParams) map[string][]string {
+	upstreamMap := p.upstreamCaches.get(reqParams.chainId)
+	if len(upstreamMap) == 0 {
+		return map[string][]string{}
+	}
+
+	ret := map[string][]string{
+		"free": {},
+		"paid": p.nodes.filter(lo.Uniq(upstreamMap["paid"])),
+	}
+	if reqParams.source == "" {
+		for source, urls := range upstreamMap {
+			if strings.Contains(source, "paid") {
+				continue
+			}
+			ret["free"] = append(ret["free"], urls...)
+		}
+		ret["free"] = p.nodes.filter(lo.Uniq(ret["free"]))
+	} else if reqParams.source != "paid" {
+		ret["free"] = p.nodes.filter(lo.Uniq(upstreamMap[reqParams.source]))
+	}
+	return ret
+}
+
+func (p *JsonRpcProxier) trace(rt *JsonRpcRequestTrace) {
+	p.logger.Info("reached endpoint", zap.Any("request trace", rt))
+	p.Usage.recordJsonRpc(rt)
+}
+
+func (p *JsonRpcProxier) fetchUpstream() {
+	upstreams, err := fetchReadyUpstreams(p.cli, client.PROTOCOL_JSONRPC)
+	p.nodes.refreshed(err)
+	if err != nil {
+		p.logger.Error("fetch upstream failed", zap.Error(err))
+		return
+	}
+	p.upstreamCaches.set(upstreams)
+	ready := make(map[string]bool)
+	for _, sources := range upstreams {
+		for _, urls := range sources {
+			for _, url := range urls {
+				ready[url] = true
+			}
+		}
+	}
+	p.wsUpstreams.prune(ready)
+	p.logger.Info("fetch upstream success", zap.Any("count", len(upstreams)))
+}
+
+// fetchReadyUpstreams loads the ready urls of a protocol grouped by chain id and source
+func fetchReadyUpstreams(cli *pocketbase.Client, protocol client.Protocol) (map[string]map[string][]string, error) {
+	items, err := cli.ListAllRecords("ready_upstream", pocketbase.ListOptions{
+		Filter: fmt.Sprintf("protocol = '%s'", protocol),
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New("upstream not found")
+	}
+	upstreams := make(map[string]map[string][]string)
+	for _, record := range items {
+		chainId := record["chain_id"].(string)
+		source := record["source"].(string)
+		rpc, _ := record["rpc"].([]any)
+		if _, ok := upstreams[chainId]; !ok {
+			upstreams[chainId] = make(map[string][]string)
+		}
+		for _, url := range rpc {
+			upstreams[chainId][source] = append(upstreams[chainId][source], url.(string))
+		}
+	}
+	return upstreams, nil
+}
+
+func (p *JsonRpcProxier) fetchCapabilities() {
+	if err := p.capabilities.fetch(p.cli, client.PROTOCOL_JSONRPC); err != nil {
+		p.logger.Error("fetch node capabilities failed", zap.Error(err))
+	}
+}
+
+func (p *JsonRpcProxier) fetchRouteRules() {
+	record, err := p.cli.GetFirstListItem("config", pocketbase.ListOptions{
+		Filter: "module = 'upstream' && key = 'route_rules'",
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			p.logger.Error("fetch route rules failed", zap.Error(err))
+		}
+		return
+	}
+	bytes, err := json.Marshal(record["value"])
+	if err != nil {
+		p.logger.Error("fetch route rules failed", zap.Error(err))
+		return
+	}
+	var routeRules map[string]methodRouteRule
+	if err = json.Unmarshal(bytes, &routeRules); err != nil {
+		p.logger.Error("parse route rules failed", zap.Error(err))
+		return
+	}
+	p.routeMu.Lock()
+	p.routeRules = routeRules
+	p.routeMu.Unlock()
+}
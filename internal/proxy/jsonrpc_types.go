@@ -0,0 +1,350 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type JsonRpcRequestTrace struct {
+	Protocol  string      `json:"protocol"`
+	ID        interface{} `json:"id"`
+	Method    string      `json:"method"`
+	ChainId   string      `json:"chainId"`
+	Source    string      `json:"source"`
+	Url       string      `json:"url"`
+	Latency   int64       `json:"latency"`
+	Group     string      `json:"group"`
+	Service   string      `json:"service"`
+	Status    string      `json:"status"`
+	Message   string      `json:"message"`
+	VisitorIp string      `json:"visitorIp"`
+	Origin    string      `json:"origin"`
+	Version   string      `json:"version"`
+	Retries   int         `json:"retries"`
+	Mode      string      `json:"mode"`
+	accessKey string
+}
+
+type JsonRpcRequestTraceBuilder struct {
+	rt *JsonRpcRequestTrace
+}
+
+func NewJsonRpcRequestTraceBuilder(service, group string) *JsonRpcRequestTraceBuilder {
+	return &JsonRpcRequestTraceBuilder{
+		rt: &JsonRpcRequestTrace{
+			Protocol: "jsonrpc",
+			Service:  service,
+			Group:    group,
+		},
+	}
+}
+
+type jsonRpcResponse struct {
+	Error *jsonRpcError `json:"error"`
+}
+
+type jsonRpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type jsonRpcRequest struct {
+	ID     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+func (b *JsonRpcRequestTraceBuilder) WithError(code int, message string) *JsonRpcRequestTraceBuilder {
+	b.rt.Status = strconv.Itoa(code)
+	b.rt.Message = message
+	return b
+}
+
+func (b *JsonRpcRequestTraceBuilder) WithAccessKey(accessKey string) *JsonRpcRequestTraceBuilder {
+	b.rt.accessKey = accessKey
+	return b
+}
+
+func (b *JsonRpcRequestTraceBuilder) WithVersion(version string) *JsonRpcRequestTraceBuilder {
+	b.rt.Version = version
+	return b
+}
+
+func (b *JsonRpcRequestTraceBuilder) WithMode(mode string) *JsonRpcRequestTraceBuilder {
+	b.rt.Mode = mode
+	return b
+}
+
+func (b *JsonRpcRequestTraceBuilder) WithResponse(statusCode int, bodyBytes []byte, latency int64) error {
+	b.rt.Latency = latency
+	if statusCode != http.StatusOK {
+		b.rt.Status = strconv.Itoa(statusCode)
+		b.rt.Message = string(bodyBytes)
+		return nil
+	}
+
+	var jsonResponse jsonRpcResponse
+	var jsonResponses []jsonRpcResponse
+	if err := json.Unmarshal(bodyBytes, &jsonResponse); err != nil {
+		if err := json.Unmarshal(bodyBytes, &jsonResponses); err != nil {
+			// unexpected end of JSON input
+			b.rt.Status = strconv.Itoa(statusCode)
+			b.rt.Message = string(bodyBytes)
+			return nil
+		}
+		if len(jsonResponses) == 0 {
+			return errors.New("empty response")
+		}
+	}
+	if len(jsonResponses) == 0 {
+		jsonResponses = append(jsonResponses, jsonResponse)
+	}
+
+	var status []string
+	var message []string
+	for _, jr := range jsonResponses {
+		if jr.Error != nil {
+			status = append(status, strconv.Itoa(jr.Error.Code))
+			message = append(message, jr.Error.Message)
+		} else {
+			status = append(status, "200")
+			message = append(message, "OK")
+		}
+	}
+	b.rt.Status = strings.Join(status, "&")
+	b.rt.Message = strings.Join(message, "&")
+	return nil
+}
+
+func (b *JsonRpcRequestTraceBuilder) WithLargeResponse(latency int64) *JsonRpcRequestTraceBuilder {
+	b.rt.Status = strconv.Itoa(http.StatusMultiStatus)
+	b.rt.Message = "Response entity too large"
+	b.rt.Latency = latency
+	return b
+}
+
+func (b *JsonRpcRequestTraceBuilder) IncrementRetries() *JsonRpcRequestTraceBuilder {
+	b.rt.Retries = b.rt.Retries + 1
+	return b
+}
+
+func (b *JsonRpcRequestTraceBuilder) WithUpstreamNode(url string) *JsonRpcRequestTraceBuilder {
+	b.rt.Url = url
+	return b
+}
+
+func (b *JsonRpcRequestTraceBuilder) WithChainIdAndSource(chainId, source string) *JsonRpcRequestTraceBuilder {
+	b.rt.ChainId = chainId
+	b.rt.Source = source
+	return b
+}
+
+func (b *JsonRpcRequestTraceBuilder) WithRequest(bodyBytes []byte, req *http.Request) error {
+	requests, err := parseJsonRpcRequests(bodyBytes)
+	if err != nil {
+		return err
+	}
+
+	var methods []string
+	var ids []string
+	for _, jr := range requests {
+		methods = append(methods, jr.Method)
+		switch v := jr.ID.(type) {
+		case string:
+			ids = append(ids, v)
+		case float64:
+			ids = append(ids, fmt.Sprintf("%.0f", v))
+		case nil:
+			ids = append(ids, "null")
+		default:
+			ids = append(ids, fmt.Sprintf("%v", v))
+		}
+	}
+	b.rt.ID = strings.Join(ids, "&")
+	b.rt.Method = strings.Join(methods, "&")
+	b.rt.VisitorIp = visitorIp(req)
+	b.rt.Origin = req.Header.Get("origin")
+	return nil
+}
+
+func (b *JsonRpcRequestTraceBuilder) Build() *JsonRpcRequestTrace {
+	return b.rt
+}
+
+// ok reports whether the traced response can be returned to the client without retrying
+func (rt *JsonRpcRequestTrace) ok() bool {
+	return rt.Status == "200" || rt.Status == "3" || rt.Status == "200&200"
+}
+
+func parseJsonRpcRequests(bodyBytes []byte) ([]jsonRpcRequest, error) {
+	var jsonRequest jsonRpcRequest
+	var jsonRequestList []jsonRpcRequest
+	if err := json.Unmarshal(bodyBytes, &jsonRequest); err != nil {
+		if err = json.Unmarshal(bodyBytes, &jsonRequestList); err != nil {
+			return nil, err
+		}
+		if len(jsonRequestList) == 0 {
+			return nil, errors.New("empty request")
+		}
+	}
+	if len(jsonRequestList) == 0 {
+		jsonRequestList = append(jsonRequestList, jsonRequest)
+	}
+	return jsonRequestList, nil
+}
+
+func visitorIp(req *http.Request) string {
+	for _, header := range []string{"CF-Connecting-IP", "X-Forwarded-For", "X-Real-Ip"} {
+		if ip := req.Header.Get(header); ip != "" {
+			return strings.TrimSpace(strings.Split(ip, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+type RetryableError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RetryableError) Error() string {
+	return e.Message
+}
+
+func callFuncWithRetry[T any](callTimes int, fn func(int) (T, error)) (T, error) {
+	var lastErr error
+	var ret T
+	for i := 0; i < callTimes; i++ {
+		ret, lastErr = fn(i)
+		if lastErr == nil {
+			break
+		}
+	}
+	return ret, lastErr
+}
+
+type requestParams struct {
+	accessKey  string
+	source     string
+	chainId    string
+	rpcMethod  string
+	httpMethod string
+	startTime  time.Time
+	headers    http.Header
+	body       []byte
+	// affinityKey is the session of the request, empty without session affinity
+	affinityKey string
+	// block is the block number the request reads at, 0 when it is not block specific
+	block int64
+}
+
+func (rp *requestParams) isPaidMode() bool {
+	return strings.Contains(rp.source, "paid")
+}
+
+func (rp *requestParams) isMevMode() bool {
+	return strings.Contains(rp.source, "mev")
+}
+
+func (rp *requestParams) isTxMethod() bool {
+	methods := []string{
+		"eth_sign",
+		"eth_signTransaction",
+		"eth_sendTransaction",
+		"eth_sendRawTransaction",
+	}
+	return slices.Contains(methods, rp.rpcMethod)
+}
+
+var (
+	v1PathRegexp = regexp.MustCompile(`^/v1/([a-z0-9\-]+)/([a-z0-9]{32})$`)
+	v2PathRegexp = regexp.MustCompile(`^/v2/([a-z0-9]{32})$`)
+)
+
+func parseV1PathParameters(path string) (*requestParams, error) {
+	match := v1PathRegexp.FindStringSubmatch(path)
+	if len(match) == 0 {
+		return nil, errors.New("invalid path")
+	}
+	return &requestParams{
+		chainId:   match[1],
+		accessKey: match[2],
+	}, nil
+}
+
+func parseV2PathParameters(path string) (*requestParams, error) {
+	match := v2PathRegexp.FindStringSubmatch(path)
+	if len(match) == 0 {
+		return nil, errors.New("invalid path")
+	}
+	return &requestParams{
+		accessKey: match[1],
+	}, nil
+}
+
+type methodRouteRule struct {
+	Source   string `json:"source"`
+	ChainIds string `json:"chainIds"`
+}
+
+func (r methodRouteRule) match(chainId string) bool {
+	return slices.Contains(strings.Split(r.ChainIds, ","), chainId)
+}
+
+// jsonRpcUpstreamCaches holds the ready urls of every chain grouped by source
+type jsonRpcUpstreamCaches struct {
+	mu        sync.RWMutex
+	upstreams map[string]map[string][]string
+}
+
+func (c *jsonRpcUpstreamCaches) get(chainId string) map[string][]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.upstreams[chainId]
+}
+
+func (c *jsonRpcUpstreamCaches) pools() []adminPool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var pools []adminPool
+	for chainId, sources := range c.upstreams {
+		for source, urls := range sources {
+			for _, url := range urls {
+				pools = append(pools, adminPool{chainId: chainId, source: source, url: url})
+			}
+		}
+	}
+	return pools
+}
+
+// urls returns every ready url once
+func (c *jsonRpcUpstreamCaches) urls() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var ret []string
+	for _, sources := range c.upstreams {
+		for _, urls := range sources {
+			ret = append(ret, urls...)
+		}
+	}
+	return slices.Compact(slices.Sorted(slices.Values(ret)))
+}
+
+func (c *jsonRpcUpstreamCaches) set(upstreams map[string]map[string][]string) {
+	c.mu.Lock()
+	c.upstreams = upstreams
+	c.mu.Unlock()
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pundix/chain-gateway/internal/client"
+	"github.com/pundix/chain-gateway/pkg/pocketbase"
+	"go.uber.org/zap"
+)
+
+type secretKeyStore struct {
+	cli    *pocketbase.Client
+	logger *zap.Logger
+	caches map[string]*client.SecretKey
+	mu     sync.RWMutex
+}
+
+func newSecretKeyStore(cli *pocketbase.Client, logger *zap.Logger) *secretKeyStore {
+	return &secretKeyStore{
+		cli:    cli,
+		logger: logger,
+		caches: make(map[string]*client.SecretKey),
+	}
+}
+
+// get only looks at the cache, it never reaches pocketbase
+func (s *secretKeyStore) get(accessKey string) *client.SecretKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.caches[accessKey]
+}
+
+func (s *secretKeyStore) verify(accessKey string) (*client.SecretKey, bool) {
+	if sk := s.get(accessKey); sk != nil {
+		return sk, true
+	}
+
+	escaped := strings.ReplaceAll(accessKey, "'", "''")
+	record, err := s.cli.GetFirstListItem("secret_key", pocketbase.ListOptions{
+		Filter: fmt.Sprintf("access_key = '%s'", escaped),
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		s.logger.Warn("get secret key failed", zap.Error(err))
+		return nil, false
+	}
+	if record == nil {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sk, ok := s.caches[accessKey]; ok {
+		return sk, true
+	}
+	sk := &client.SecretKey{
+		AccessKey:    accessKey,
+		SecretKey:    record["secret_key"].(string),
+		Service:      record["service"].(string),
+		Group:        record["group"].(string),
+		AllowOrigins: record["allow_origins"].(string),
+		AllowIps:     record["allow_ips"].(string),
+	}
+	if routeRules, ok := record["route_rules"].(string); ok {
+		sk.RouteRules = routeRules
+	}
+	s.caches[accessKey] = sk
+	return sk, true
+}
+
+func (s *secretKeyStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.caches)
+}
+
+// flush drops every cached key, they are loaded from pocketbase again on the next request
+func (s *secretKeyStore) flush() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.caches)
+	s.caches = make(map[string]*client.SecretKey)
+	return n
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeoutPolicy decides the deadline of a proxied call.
+// Method overrides win over chain overrides, which win over Default.
+// Max caps deadlines supplied by clients, zero means no cap.
+type TimeoutPolicy struct {
+	Default time.Duration
+	Max     time.Duration
+	Chains  map[string]time.Duration
+	Methods map[string]time.Duration
+}
+
+func NewTimeoutPolicy() *TimeoutPolicy {
+	return &TimeoutPolicy{
+		Default: 30 * time.Second,
+		Chains:  make(map[string]time.Duration),
+		Methods: make(map[string]time.Duration),
+	}
+}
+
+// timeout returns the policy timeout of a call. A JSON-RPC batch comes as "a&b",
+// it gets the strictest override of its methods
+func (tp *TimeoutPolicy) timeout(chainId, fullMethodName string) time.Duration {
+	var (
+		method time.Duration
+		found  bool
+	)
+	for _, name := range strings.Split(fullMethodName, "&") {
+		if d, ok := tp.Methods[name]; ok && (!found || d < method) {
+			method, found = d, true
+		}
+	}
+	if found {
+		return method
+	}
+	if d, ok := tp.Chains[chainId]; ok {
+		return d
+	}
+	return tp.Default
+}
+
+// apply returns a context carrying the effective deadline.
+// A client deadline is kept as long as it is below Max, otherwise the policy timeout is used.
+func (tp *TimeoutPolicy) apply(ctx context.Context, chainId, fullMethodName string) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if tp.Max > 0 && time.Until(deadline) > tp.Max {
+			return context.WithTimeout(ctx, tp.Max)
+		}
+		return context.WithCancel(ctx)
+	}
+	timeout := tp.timeout(chainId, fullMethodName)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// ParseTimeouts converts flag values like {"3448148188": "10s"} into durations.
+func ParseTimeouts(values map[string]string) (map[string]time.Duration, error) {
+	ret := make(map[string]time.Duration, len(values))
+	for k, v := range values {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout for %s: %w", k, err)
+		}
+		ret[k] = d
+	}
+	return ret, nil
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseTimeouts(t *testing.T) {
+	got, err := ParseTimeouts(map[string]string{"3448148188": "10s", "/protocol.Wallet/GetNowBlock": "1500ms"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["3448148188"] != 10*time.Second || got["/protocol.Wallet/GetNowBlock"] != 1500*time.Millisecond {
+		t.Fatalf("unexpected timeouts %v", got)
+	}
+	if _, err = ParseTimeouts(map[string]string{"1": "ten"}); err == nil {
+		t.Fatalf("expected error for an invalid duration")
+	}
+}
+
+func TestTimeoutPolicy_timeout(t *testing.T) {
+	tp := NewTimeoutPolicy()
+	tp.Chains["1"] = 10 * time.Second
+	tp.Methods["eth_call"] = 5 * time.Second
+	tp.Methods["eth_getLogs"] = 20 * time.Second
+
+	tests := []struct {
+		chainId, method string
+		want            time.Duration
+	}{
+		{"2", "eth_blockNumber", 30 * time.Second},
+		{"1", "eth_blockNumber", 10 * time.Second},
+		{"1", "eth_call", 5 * time.Second},
+		{"2", "eth_getLogs", 20 * time.Second},
+		// batches take the strictest method override
+		{"1", "eth_getLogs&eth_call&eth_blockNumber", 5 * time.Second},
+		{"1", "eth_getLogs&eth_blockNumber", 20 * time.Second},
+		{"1", "eth_chainId&eth_blockNumber", 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := tp.timeout(tt.chainId, tt.method); got != tt.want {
+			t.Errorf("timeout(%s, %s) = %v, want %v", tt.chainId, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestTimeoutPolicy_apply(t *testing.T) {
+	tp := NewTimeoutPolicy()
+	tp.Max = time.Minute
+	tp.Methods["eth_call"] = 5 * time.Second
+
+	ctx, cancel := tp.apply(context.Background(), "1", "eth_call")
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > 5*time.Second {
+		t.Fatalf("expected the method timeout, got %v", deadline)
+	}
+
+	// a client deadline below Max is kept
+	client, clientCancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer clientCancel()
+	ctx, cancel = tp.apply(client, "1", "eth_call")
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) < 15*time.Second {
+		t.Fatalf("expected the client deadline, got %v", deadline)
+	}
+
+	// a client deadline above Max is capped
+	client, clientCancel = context.WithTimeout(context.Background(), time.Hour)
+	defer clientCancel()
+	ctx, cancel = tp.apply(client, "1", "eth_call")
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Minute {
+		t.Fatalf("expected the deadline capped at Max, got %v", deadline)
+	}
+
+	// no deadline when the policy timeout is zero
+	tp.Default = 0
+	ctx, cancel = tp.apply(context.Background(), "1", "eth_blockNumber")
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Fatalf("expected no deadline")
+	}
+}
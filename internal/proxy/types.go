@@ -34,6 +34,7 @@ type RequestTrace struct {
 	Status    codes.Code `json:"status"`
 	Message   string     `json:"message"`
 	VisitorIp string     `json:"visitorIp"`
+	accessKey string
 }
 
 func (rt *RequestTrace) Println() {
@@ -62,6 +63,11 @@ func (b *RequestTraceBuilder) WithResponse(latency int64, status *status.Status)
 	return b
 }
 
+func (b *RequestTraceBuilder) WithAccessKey(accessKey string) *RequestTraceBuilder {
+	b.rt.accessKey = accessKey
+	return b
+}
+
 func (b *RequestTraceBuilder) WithUpstreamNode(url string) *RequestTraceBuilder {
 	b.rt.Url = url
 	return b
@@ -93,16 +99,32 @@ func (b *RequestTraceBuilder) Build() *RequestTrace {
 
 type wrappedStream struct {
 	grpc.ClientStream
-	logger *zap.Logger
-	rtb    *RequestTraceBuilder
-	start  time.Time
+	ctx      context.Context
+	logger   *zap.Logger
+	usage    *UsageRecorder
+	nodes    *nodeMonitor
+	rtb      *RequestTraceBuilder
+	start    time.Time
+	recorded bool
+	// stopNode reports whether the node request is still open, it is closed on the first response or when ctx ends
+	stopNode  func() bool
+	nodeStart time.Time
 }
 
-func newWrappedStream(s grpc.ClientStream, rtb *RequestTraceBuilder, logger *zap.Logger) grpc.ClientStream {
+// newWrappedStream wraps a stream to a node whose call was begun at start
+func newWrappedStream(ctx context.Context, s grpc.ClientStream, rtb *RequestTraceBuilder, logger *zap.Logger, usage *UsageRecorder, nodes *nodeMonitor, start time.Time) grpc.ClientStream {
+	url := rtb.rt.Url
 	return &wrappedStream{
 		ClientStream: s,
+		ctx:          ctx,
 		logger:       logger,
+		usage:        usage,
+		nodes:        nodes,
 		rtb:          rtb,
+		stopNode: context.AfterFunc(ctx, func() {
+			nodes.end(ctx, url, false, time.Since(start))
+		}),
+		nodeStart: start,
 	}
 }
 
@@ -110,17 +132,43 @@ func (w *wrappedStream) RecvMsg(m interface{}) error {
 	err := w.ClientStream.RecvMsg(m)
 	callStatus := status.New(codes.OK, codes.OK.String())
 	if err != nil {
-		if status, ok := status.FromError(err); ok {
+		if errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+			callStatus = status.Newf(codes.DeadlineExceeded, "upstream timeout: %s", w.rtb.rt.Url)
+		} else if status, ok := status.FromError(err); ok {
 			callStatus = status
 		} else {
 			return err
 		}
 	}
 	rt := w.rtb.WithResponse(time.Since(w.start).Milliseconds(), callStatus).Build()
+	// a call is counted once, streams receive many messages and the losing hedge is canceled
+	if !w.recorded && callStatus.Code() != codes.Canceled {
+		w.recorded = true
+		w.usage.recordGrpc(rt)
+	}
+	if w.stopNode() {
+		w.nodes.end(w.ctx, rt.Url, isUpstreamFault(callStatus.Code()), time.Since(w.nodeStart))
+	}
+	if callStatus.Code() == codes.DeadlineExceeded {
+		w.logger.Warn("upstream timeout", zap.Any("request trace", rt))
+		return callStatus.Err()
+	}
 	w.logger.Info("reached endpoint", zap.Any("request trace", rt))
+	if err != nil {
+		return callStatus.Err()
+	}
 	return nil
 }
 
+// isUpstreamFault reports whether a status code blames the node rather than the request
+func isUpstreamFault(code codes.Code) bool {
+	switch code {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
 func (w *wrappedStream) SendMsg(m interface{}) error {
 	w.start = time.Now()
 	return w.ClientStream.SendMsg(m)
@@ -151,16 +199,40 @@ type grpcUpstream struct {
 	next    uint32
 	mu      sync.RWMutex
 	logger  *zap.Logger
+	nodes   *nodeMonitor
 }
 
+// get picks the next node round robin, ejected nodes and open breakers are skipped
 func (u *grpcUpstream) get() (*grpc.ClientConn, error) {
 	u.mu.RLock()
 	defer u.mu.RUnlock()
 	if len(u.rpc) == 0 {
 		return nil, errors.New("zero endpoints")
 	}
-	idx := int(atomic.AddUint32(&u.next, 1)) % len(u.rpc)
-	url := u.rpc[idx]
+	for range len(u.rpc) {
+		idx := int(atomic.AddUint32(&u.next, 1)) % len(u.rpc)
+		url := u.rpc[idx]
+		if !u.nodes.available(url) {
+			continue
+		}
+		conn, ok := u.clis[url]
+		if !ok || conn == nil {
+			return nil, fmt.Errorf("no client for url: %s", url)
+		}
+		return conn, nil
+	}
+	return nil, errors.New("all endpoints unavailable")
+}
+
+// getByKey picks the session's node by rendezvous hashing over the available nodes
+func (u *grpcUpstream) getByKey(key string) (*grpc.ClientConn, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	urls := u.nodes.filter(u.rpc)
+	if len(urls) == 0 {
+		return nil, errors.New("all endpoints unavailable")
+	}
+	url := rendezvousOrder(key, urls)[0]
 	conn, ok := u.clis[url]
 	if !ok || conn == nil {
 		return nil, fmt.Errorf("no client for url: %s", url)
@@ -168,6 +240,23 @@ func (u *grpcUpstream) get() (*grpc.ClientConn, error) {
 	return conn, nil
 }
 
+// getExcept picks the next client that is not cc, used to hedge a call on another node
+func (u *grpcUpstream) getExcept(cc *grpc.ClientConn) (*grpc.ClientConn, error) {
+	u.mu.RLock()
+	n := len(u.rpc)
+	u.mu.RUnlock()
+	for range n {
+		conn, err := u.get()
+		if err != nil {
+			return nil, err
+		}
+		if conn != cc {
+			return conn, nil
+		}
+	}
+	return nil, errors.New("no other endpoints")
+}
+
 func (u *grpcUpstream) refresh(rpc []string, loggingStreamInterceptor grpc.StreamClientInterceptor) {
 	u.mu.Lock()
 	newSet := make(map[string]bool, len(rpc))
@@ -255,6 +344,30 @@ func (guc grpcUpstreamCaches) get(chainId string) (*grpcUpstream, error) {
 	return nil, errors.New("no upstream found")
 }
 
+// pools lists every node with its connection state
+func (guc grpcUpstreamCaches) pools() []adminPool {
+	grpcUpstreamCachesMu.RLock()
+	upstreams := make([]*grpcUpstream, 0, len(guc))
+	for _, upstream := range guc {
+		upstreams = append(upstreams, upstream)
+	}
+	grpcUpstreamCachesMu.RUnlock()
+
+	var pools []adminPool
+	for _, upstream := range upstreams {
+		upstream.mu.RLock()
+		for _, url := range upstream.rpc {
+			pool := adminPool{chainId: upstream.chainId, url: url}
+			if conn, ok := upstream.clis[url]; ok && conn != nil {
+				pool.state = conn.GetState().String()
+			}
+			pools = append(pools, pool)
+		}
+		upstream.mu.RUnlock()
+	}
+	return pools
+}
+
 func (guc grpcUpstreamCaches) put(chainId string, value *grpcUpstream, loggingStreamInterceptor grpc.StreamClientInterceptor) {
 	grpcUpstreamCachesMu.Lock()
 	upstream, ok := guc[chainId]
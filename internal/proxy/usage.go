@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pundix/chain-gateway/internal/client"
+	"github.com/pundix/chain-gateway/pkg/pocketbase"
+	"go.uber.org/zap"
+)
+
+const (
+	usageCollection = "usage"
+	// usageDateLayout is the pocketbase date layout, filters compare it as text
+	usageDateLayout = "2006-01-02 15:04:05.000Z"
+)
+
+var (
+	// usageLabelRegexp keeps labels safe to embed in pocketbase filters
+	usageLabelRegexp = regexp.MustCompile(`^[A-Za-z0-9_\-./:{} ]{0,128}$`)
+	// usageIdSegmentRegexp matches path segments that identify a resource, e.g. heights, hashes and addresses
+	usageIdSegmentRegexp = regexp.MustCompile(`^(\d+|0x[0-9a-fA-F]+|[A-Za-z0-9]{33,})$`)
+)
+
+type usageKey struct {
+	hour      time.Time
+	protocol  string
+	accessKey string
+	group     string
+	service   string
+	chainId   string
+	method    string
+	status    string
+}
+
+// UsageRecorder counts requests in hourly buckets per key, group, service, chain, method and status,
+// the buckets are added to the usage collection every Interval.
+type UsageRecorder struct {
+	Interval time.Duration
+	logger   *zap.Logger
+	cli      *pocketbase.Client
+	mu       sync.Mutex
+	buckets  map[usageKey]int64
+	start    sync.Once
+}
+
+func NewUsageRecorder(cli *pocketbase.Client, logger *zap.Logger) *UsageRecorder {
+	return &UsageRecorder{
+		Interval: time.Minute,
+		logger:   logger,
+		cli:      cli,
+		buckets:  make(map[usageKey]int64),
+	}
+}
+
+func (u *UsageRecorder) enabled() bool {
+	return u != nil && u.Interval > 0
+}
+
+// Run flushes the buckets every Interval until the process exits.
+func (u *UsageRecorder) Run() {
+	if !u.enabled() {
+		return
+	}
+	u.start.Do(func() {
+		go func() {
+			ticker := time.NewTicker(u.Interval)
+			defer ticker.Stop()
+			for {
+				<-ticker.C
+				u.Flush()
+			}
+		}()
+	})
+}
+
+// record counts one call, batched jsonrpc calls ("a&b" methods and statuses) are counted per call
+func (u *UsageRecorder) record(protocol, accessKey, group, service, chainId, method, status string) {
+	if !u.enabled() {
+		return
+	}
+	methods := strings.Split(method, "&")
+	statuses := strings.Split(status, "&")
+	if len(methods) != len(statuses) {
+		methods, statuses = []string{method}, []string{status}
+	}
+
+	hour := time.Now().UTC().Truncate(time.Hour)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i := range methods {
+		key := usageKey{
+			hour:      hour,
+			protocol:  usageLabel(protocol),
+			accessKey: client.AccessKeyHash(accessKey),
+			group:     usageLabel(group),
+			service:   usageLabel(service),
+			chainId:   usageLabel(chainId),
+			method:    usageLabel(usageMethod(protocol, methods[i])),
+			status:    usageLabel(statuses[i]),
+		}
+		u.buckets[key]++
+	}
+}
+
+func (u *UsageRecorder) recordJsonRpc(rt *JsonRpcRequestTrace) {
+	u.record(rt.Protocol, rt.accessKey, rt.Group, rt.Service, rt.ChainId, rt.Method, rt.Status)
+}
+
+func (u *UsageRecorder) recordGrpc(rt *RequestTrace) {
+	u.record(rt.Protocol, rt.accessKey, rt.Group, rt.Service, rt.ChainId, rt.Method, rt.Status.String())
+}
+
+// Flush adds the pending buckets to the usage collection, failed buckets are kept for the next flush.
+func (u *UsageRecorder) Flush() {
+	if !u.enabled() {
+		return
+	}
+	u.mu.Lock()
+	buckets := u.buckets
+	u.buckets = make(map[usageKey]int64)
+	u.mu.Unlock()
+
+	var failed int
+	for key, count := range buckets {
+		if err := u.save(key, count); err != nil {
+			failed++
+			u.logger.Warn("save usage failed", zap.Error(err), zap.String("group", key.group), zap.String("method", key.method))
+			u.mu.Lock()
+			u.buckets[key] += count
+			u.mu.Unlock()
+		}
+	}
+	if len(buckets) > 0 {
+		u.logger.Info("flush usage", zap.Int("buckets", len(buckets)), zap.Int("failed", failed))
+	}
+}
+
+func (u *UsageRecorder) save(key usageKey, count int64) error {
+	id, err := u.find(key)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = u.cli.CreateRecord(usageCollection, map[string]any{
+			"hour":       key.hour.Format(usageDateLayout),
+			"protocol":   key.protocol,
+			"access_key": key.accessKey,
+			"group":      key.group,
+			"service":    key.service,
+			"chain_id":   key.chainId,
+			"method":     key.method,
+			"status":     key.status,
+			"count":      count,
+		})
+		if err == nil {
+			return nil
+		}
+		// another proxy may have created the bucket in the meantime
+		id, err = u.find(key)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = u.cli.UpdateRecord(usageCollection, id, map[string]any{
+		"count+": count,
+	})
+	return err
+}
+
+func (u *UsageRecorder) find(key usageKey) (string, error) {
+	filter := fmt.Sprintf("hour='%s' && protocol='%s' && access_key='%s' && group='%s' && service='%s' && chain_id='%s' && method='%s' && status='%s'",
+		key.hour.Format(usageDateLayout), key.protocol, key.accessKey, key.group, key.service, key.chainId, key.method, key.status)
+	item, err := u.cli.GetFirstListItem(usageCollection, pocketbase.ListOptions{
+		Filter:    filter,
+		Fields:    "id",
+		SkipTotal: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	id, _ := item["id"].(string)
+	return id, nil
+}
+
+func usageLabel(v string) string {
+	if !usageLabelRegexp.MatchString(v) {
+		return "invalid"
+	}
+	return v
+}
+
+// usageMethod collapses resource ids in http paths, e.g. "GET /cosmos/bank/v1beta1/balances/{}",
+// so every address or height doesn't get its own bucket
+func usageMethod(protocol, method string) string {
+	if protocol != string(client.PROTOCOL_REST) && protocol != string(client.PROTOCOL_COMETBFT) {
+		return method
+	}
+	segments := strings.Split(method, "/")
+	for i, segment := range segments {
+		if i > 0 && usageIdSegmentRegexp.MatchString(segment) {
+			segments[i] = "{}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,697 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pundix/chain-gateway/internal/client"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	wsCallTimeout = 10 * time.Second
+	// wsSendQueue is how many frames a client may fall behind before it is disconnected
+	wsSendQueue = 256
+	// wsWriteTimeout bounds a single frame write to a client
+	wsWriteTimeout = 10 * time.Second
+	// wsMaxInFlight caps the requests of a client handled at once, reading waits for a free slot
+	wsMaxInFlight = 32
+)
+
+func isWebSocketUpgrade(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// wsUrl turns a ready http endpoint into its websocket counterpart
+func wsUrl(url string) string {
+	switch {
+	case strings.HasPrefix(url, "https://"):
+		return "wss://" + strings.TrimPrefix(url, "https://")
+	case strings.HasPrefix(url, "http://"):
+		return "ws://" + strings.TrimPrefix(url, "http://")
+	}
+	return url
+}
+
+func newSubscriptionId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "0x" + hex.EncodeToString(b)
+}
+
+// wsLimiter counts websocket connections and subscriptions per access key
+type wsLimiter struct {
+	mu    sync.Mutex
+	conns map[string]int
+	subs  map[string]int
+}
+
+func newWsLimiter() *wsLimiter {
+	return &wsLimiter{
+		conns: make(map[string]int),
+		subs:  make(map[string]int),
+	}
+}
+
+func (l *wsLimiter) acquire(counter map[string]int, accessKey string, max int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if max > 0 && counter[accessKey] >= max {
+		return false
+	}
+	counter[accessKey]++
+	return true
+}
+
+func (l *wsLimiter) release(counter map[string]int, accessKey string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if counter[accessKey] <= 1 {
+		delete(counter, accessKey)
+		return
+	}
+	counter[accessKey]--
+}
+
+type wsSubscription struct {
+	session    *wsSession
+	clientId   string
+	params     json.RawMessage
+	mu         sync.Mutex
+	upstream   *wsUpstreamConn
+	upstreamId string
+}
+
+func (sub *wsSubscription) bind(upstream *wsUpstreamConn, upstreamId string) {
+	sub.mu.Lock()
+	sub.upstream = upstream
+	sub.upstreamId = upstreamId
+	sub.mu.Unlock()
+}
+
+func (sub *wsSubscription) current() (*wsUpstreamConn, string) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.upstream, sub.upstreamId
+}
+
+type wsNotification struct {
+	Jsonrpc string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  wsNotificationBody `json:"params"`
+}
+
+type wsNotificationBody struct {
+	Subscription string          `json:"subscription"`
+	Result       json.RawMessage `json:"result"`
+}
+
+type wsUpstreamMessage struct {
+	ID     json.RawMessage    `json:"id"`
+	Method string             `json:"method"`
+	Result json.RawMessage    `json:"result"`
+	Error  *jsonRpcError      `json:"error"`
+	Params wsNotificationBody `json:"params"`
+}
+
+// wsUpstreamConn is a single upstream websocket shared by the subscriptions of every client
+type wsUpstreamConn struct {
+	url     string
+	ws      *websocket.Conn
+	pool    *wsUpstreamPool
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextId  uint64
+	pending map[uint64]chan *wsUpstreamMessage
+	subs    map[string]*wsSubscription
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (c *wsUpstreamConn) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *wsUpstreamConn) call(method string, params any) (json.RawMessage, error) {
+	id := atomic.AddUint64(&c.nextId, 1)
+	ch := make(chan *wsUpstreamMessage, 1)
+	c.mu.Lock()
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	msg, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.writeMu.Lock()
+	err = websocket.Message.Send(c.ws, string(msg))
+	c.writeMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return nil, fmt.Errorf("code: %d, message: %s", resp.Error.Code, resp.Error.Message)
+		}
+		return resp.Result, nil
+	case <-c.done:
+		return nil, fmt.Errorf("upstream %s closed", c.url)
+	case <-time.After(wsCallTimeout):
+		return nil, fmt.Errorf("upstream %s timeout", c.url)
+	}
+}
+
+func (c *wsUpstreamConn) subscribe(sub *wsSubscription) error {
+	result, err := c.call("eth_subscribe", sub.params)
+	if err != nil {
+		return err
+	}
+	var upstreamId string
+	if err = json.Unmarshal(result, &upstreamId); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.subs[upstreamId] = sub
+	c.mu.Unlock()
+	sub.bind(c, upstreamId)
+	return nil
+}
+
+func (c *wsUpstreamConn) unsubscribe(upstreamId string) {
+	c.mu.Lock()
+	delete(c.subs, upstreamId)
+	c.mu.Unlock()
+	if c.closed() {
+		return
+	}
+	if _, err := c.call("eth_unsubscribe", []string{upstreamId}); err != nil {
+		c.pool.logger.Warn("websocket unsubscribe failed", zap.String("url", c.url), zap.Error(err))
+	}
+}
+
+func (c *wsUpstreamConn) idle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.subs) == 0 && len(c.pending) == 0
+}
+
+func (c *wsUpstreamConn) read() {
+	defer c.close()
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(c.ws, &msg); err != nil {
+			if !errors.Is(err, io.EOF) && !c.closed() {
+				c.pool.logger.Warn("websocket upstream dropped", zap.String("url", c.url), zap.Error(err))
+			}
+			return
+		}
+		var um wsUpstreamMessage
+		if err := json.Unmarshal(msg, &um); err != nil {
+			continue
+		}
+		if um.Method == "eth_subscription" {
+			c.mu.Lock()
+			sub, ok := c.subs[um.Params.Subscription]
+			c.mu.Unlock()
+			if ok {
+				sub.session.notify(sub, um.Params.Result)
+			}
+			continue
+		}
+		id, err := strconv.ParseUint(string(um.ID), 10, 64)
+		if err != nil {
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		c.mu.Unlock()
+		if ok {
+			ch <- &um
+		}
+	}
+}
+
+// close drops the connection and moves its subscriptions to other nodes
+func (c *wsUpstreamConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.ws.Close()
+		c.pool.remove(c)
+
+		c.mu.Lock()
+		subs := lo.Values(c.subs)
+		c.subs = make(map[string]*wsSubscription)
+		c.mu.Unlock()
+		for _, sub := range subs {
+			go sub.session.resubscribe(sub, c.url)
+		}
+	})
+}
+
+type wsUpstreamPool struct {
+	logger *zap.Logger
+	mu     sync.Mutex
+	conns  map[string]*wsUpstreamConn
+}
+
+func newWsUpstreamPool(logger *zap.Logger) *wsUpstreamPool {
+	return &wsUpstreamPool{
+		logger: logger,
+		conns:  make(map[string]*wsUpstreamConn),
+	}
+}
+
+func (pool *wsUpstreamPool) conn(url string) (*wsUpstreamConn, error) {
+	pool.mu.Lock()
+	c, ok := pool.conns[url]
+	pool.mu.Unlock()
+	if ok && !c.closed() {
+		return c, nil
+	}
+
+	config, err := websocket.NewConfig(wsUrl(url), "http://localhost")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wsCallTimeout)
+	defer cancel()
+	ws, err := config.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c = &wsUpstreamConn{
+		url:     url,
+		ws:      ws,
+		pool:    pool,
+		pending: make(map[uint64]chan *wsUpstreamMessage),
+		subs:    make(map[string]*wsSubscription),
+		done:    make(chan struct{}),
+	}
+
+	pool.mu.Lock()
+	if existing, ok := pool.conns[url]; ok && !existing.closed() {
+		pool.mu.Unlock()
+		ws.Close()
+		return existing, nil
+	}
+	pool.conns[url] = c
+	pool.mu.Unlock()
+	go c.read()
+	return c, nil
+}
+
+func (pool *wsUpstreamPool) remove(c *wsUpstreamConn) {
+	pool.mu.Lock()
+	if pool.conns[c.url] == c {
+		delete(pool.conns, c.url)
+	}
+	pool.mu.Unlock()
+}
+
+// subscribe tries the urls in order until one of them accepts the subscription
+func (pool *wsUpstreamPool) subscribe(urls []string, sub *wsSubscription) error {
+	lastErr := errors.New("no available nodes")
+	for _, url := range urls {
+		c, err := pool.conn(url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if err = c.subscribe(sub); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// prune closes connections of nodes that left the ready pool, their subscriptions move elsewhere
+func (pool *wsUpstreamPool) prune(ready map[string]bool) {
+	pool.mu.Lock()
+	var stale []*wsUpstreamConn
+	for url, c := range pool.conns {
+		if !ready[url] || c.idle() {
+			stale = append(stale, c)
+		}
+	}
+	pool.mu.Unlock()
+	for _, c := range stale {
+		c.close()
+	}
+}
+
+// wsSession serves one client websocket connection
+type wsSession struct {
+	p         *JsonRpcProxier
+	ws        *websocket.Conn
+	req       *http.Request
+	sk        *client.SecretKey
+	service   string
+	reqParams *requestParams
+	ctx       context.Context
+	cancel    context.CancelFunc
+	// out queues frames for writeLoop, so a slow client never blocks the shared upstream readers
+	out      chan []byte
+	inflight chan struct{}
+	mu       sync.Mutex
+	subs     map[string]*wsSubscription
+}
+
+func (p *JsonRpcProxier) handleWebSocket(reqParams *requestParams, sk *client.SecretKey, service string, w http.ResponseWriter, req *http.Request) {
+	if !p.wsLimiter.acquire(p.wsLimiter.conns, sk.AccessKey, p.WsMaxConnections) {
+		http.Error(w, "too many websocket connections", http.StatusTooManyRequests)
+		return
+	}
+	defer p.wsLimiter.release(p.wsLimiter.conns, sk.AccessKey)
+
+	websocket.Server{
+		// origin is verified by allowOrigin before the upgrade
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			ctx, cancel := context.WithCancel(req.Context())
+			s := &wsSession{
+				p:         p,
+				ws:        ws,
+				req:       req,
+				sk:        sk,
+				service:   service,
+				reqParams: reqParams,
+				ctx:       ctx,
+				cancel:    cancel,
+				out:       make(chan []byte, wsSendQueue),
+				inflight:  make(chan struct{}, wsMaxInFlight),
+				subs:      make(map[string]*wsSubscription),
+			}
+			defer s.close()
+			go s.writeLoop()
+			s.serve()
+		},
+	}.ServeHTTP(w, req)
+}
+
+func (s *wsSession) serve() {
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(s.ws, &msg); err != nil {
+			return
+		}
+		select {
+		case s.inflight <- struct{}{}:
+		case <-s.ctx.Done():
+			return
+		}
+		go func() {
+			defer func() { <-s.inflight }()
+			s.handleMessage(msg)
+		}()
+	}
+}
+
+func (s *wsSession) close() {
+	s.cancel()
+	s.ws.Close()
+	s.mu.Lock()
+	subs := lo.Values(s.subs)
+	s.subs = make(map[string]*wsSubscription)
+	s.mu.Unlock()
+	for _, sub := range subs {
+		if upstream, upstreamId := sub.current(); upstream != nil {
+			upstream.unsubscribe(upstreamId)
+		}
+		s.p.wsLimiter.release(s.p.wsLimiter.subs, s.sk.AccessKey)
+	}
+}
+
+// write queues a frame without blocking, a client whose queue is full has fallen behind and is disconnected
+func (s *wsSession) write(msg []byte) {
+	select {
+	case s.out <- msg:
+	case <-s.ctx.Done():
+	default:
+		s.p.logger.Warn("websocket client too slow, closing", zap.String("chainId", s.reqParams.chainId))
+		s.ws.Close()
+	}
+}
+
+func (s *wsSession) writeLoop() {
+	for {
+		select {
+		case msg := <-s.out:
+			s.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := websocket.Message.Send(s.ws, string(msg)); err != nil {
+				s.ws.Close()
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *wsSession) reply(id any, result any) {
+	msg, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": id, "result": result})
+	s.write(msg)
+}
+
+func (s *wsSession) replyError(id any, code int, message string) {
+	msg, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": id, "error": jsonRpcError{Code: code, Message: message}})
+	s.write(msg)
+}
+
+func (s *wsSession) notify(sub *wsSubscription, result json.RawMessage) {
+	msg, err := json.Marshal(&wsNotification{
+		Jsonrpc: "2.0",
+		Method:  "eth_subscription",
+		Params: wsNotificationBody{
+			Subscription: sub.clientId,
+			Result:       result,
+		},
+	})
+	if err != nil {
+		return
+	}
+	s.write(msg)
+}
+
+func (s *wsSession) handleMessage(msg []byte) {
+	var jr jsonRpcRequest
+	if err := json.Unmarshal(msg, &jr); err != nil {
+		// batch requests never carry subscriptions
+		s.forward(msg, nil)
+		return
+	}
+	switch jr.Method {
+	case "eth_subscribe":
+		s.subscribe(&jr)
+	case "eth_unsubscribe":
+		s.unsubscribe(&jr)
+	default:
+		s.forward(msg, jr.ID)
+	}
+}
+
+// candidates lists the ready nodes able to take a subscription, following the http routing
+func (s *wsSession) candidates() []string {
+	endpointMap := s.p.getChainEndpoints(s.reqParams)
+	var urls []string
+	if s.reqParams.isPaidMode() {
+		urls = endpointMap["paid"]
+	} else {
+		urls = endpointMap["free"]
+		if !s.reqParams.isMevMode() {
+			urls = append(urls, endpointMap["paid"]...)
+		}
+	}
+	return lo.Shuffle(lo.Uniq(urls))
+}
+
+func (s *wsSession) subscribe(jr *jsonRpcRequest) {
+	rtb := NewJsonRpcRequestTraceBuilder(s.service, s.sk.Group).
+		WithChainIdAndSource(s.reqParams.chainId, s.reqParams.source).
+		WithAccessKey(s.reqParams.accessKey).
+		WithMode("subscribe")
+	rtb.rt.ID = jr.ID
+	rtb.rt.Method = jr.Method
+	rtb.rt.VisitorIp = visitorIp(s.req)
+	start := time.Now()
+
+	if !s.p.wsLimiter.acquire(s.p.wsLimiter.subs, s.sk.AccessKey, s.p.WsMaxSubscriptions) {
+		s.replyError(jr.ID, -32005, "subscription limit exceeded")
+		s.p.trace(rtb.WithError(http.StatusTooManyRequests, "subscription limit exceeded").Build())
+		return
+	}
+	sub := &wsSubscription{
+		session:  s,
+		clientId: newSubscriptionId(),
+		params:   jr.Params,
+	}
+	if err := s.p.wsUpstreams.subscribe(s.candidates(), sub); err != nil {
+		s.p.wsLimiter.release(s.p.wsLimiter.subs, s.sk.AccessKey)
+		s.replyError(jr.ID, -32000, err.Error())
+		s.p.trace(rtb.WithError(http.StatusBadGateway, err.Error()).Build())
+		return
+	}
+
+	s.mu.Lock()
+	if s.ctx.Err() != nil {
+		// the session closed while subscribing, close already released its other subscriptions
+		s.mu.Unlock()
+		if upstream, upstreamId := sub.current(); upstream != nil {
+			upstream.unsubscribe(upstreamId)
+		}
+		s.p.wsLimiter.release(s.p.wsLimiter.subs, s.sk.AccessKey)
+		return
+	}
+	s.subs[sub.clientId] = sub
+	s.mu.Unlock()
+	s.reply(jr.ID, sub.clientId)
+
+	upstream, _ := sub.current()
+	rtb.WithUpstreamNode(upstream.url).rt.Latency = time.Since(start).Milliseconds()
+	s.p.trace(rtb.WithError(http.StatusOK, "OK").Build())
+}
+
+func (s *wsSession) unsubscribe(jr *jsonRpcRequest) {
+	var ids []string
+	if err := json.Unmarshal(jr.Params, &ids); err != nil || len(ids) == 0 {
+		s.replyError(jr.ID, -32602, "invalid params")
+		return
+	}
+	s.mu.Lock()
+	sub, ok := s.subs[ids[0]]
+	delete(s.subs, ids[0])
+	s.mu.Unlock()
+	if !ok {
+		s.reply(jr.ID, false)
+		return
+	}
+	if upstream, upstreamId := sub.current(); upstream != nil {
+		upstream.unsubscribe(upstreamId)
+	}
+	s.p.wsLimiter.release(s.p.wsLimiter.subs, s.sk.AccessKey)
+	s.reply(jr.ID, true)
+}
+
+// resubscribe moves a subscription away from a dropped upstream, the client keeps its subscription id
+func (s *wsSession) resubscribe(sub *wsSubscription, dropped string) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	s.mu.Lock()
+	_, ok := s.subs[sub.clientId]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	urls := lo.Without(s.candidates(), dropped)
+	if err := s.p.wsUpstreams.subscribe(urls, sub); err != nil {
+		s.p.logger.Warn("websocket resubscribe failed, closing client",
+			zap.String("chainId", s.reqParams.chainId), zap.String("dropped", dropped), zap.Error(err))
+		// let the client reconnect and subscribe again
+		s.ws.Close()
+		return
+	}
+	s.mu.Lock()
+	_, ok = s.subs[sub.clientId]
+	if s.ctx.Err() != nil || !ok {
+		// the session closed or the client unsubscribed while resubscribing, nothing else releases the new subscription
+		s.mu.Unlock()
+		if upstream, upstreamId := sub.current(); upstream != nil {
+			upstream.unsubscribe(upstreamId)
+		}
+		return
+	}
+	s.mu.Unlock()
+	upstream, _ := sub.current()
+	s.p.logger.Info("websocket resubscribed",
+		zap.String("chainId", s.reqParams.chainId), zap.String("from", dropped), zap.String("to", upstream.url))
+}
+
+// forward sends a plain request through the http routing and writes the response back as a frame
+func (s *wsSession) forward(msg []byte, id any) {
+	rp := *s.reqParams
+	rp.startTime = time.Now()
+	rp.httpMethod = http.MethodPost
+	rp.body = msg
+	rp.headers = http.Header{"Content-Type": []string{"application/json"}}
+
+	rtb := NewJsonRpcRequestTraceBuilder(s.service, s.sk.Group)
+	if err := rtb.WithRequest(msg, s.req); err != nil {
+		s.replyError(nil, -32700, "parse error")
+		return
+	}
+	rp.rpcMethod = rtb.rt.Method
+	if s.p.Heights.enabled() {
+		rp.block = requestedBlock(msg)
+	}
+	if err := s.p.applyRouteRules(&rp, s.sk); err != nil {
+		s.replyError(id, -32603, err.Error())
+		return
+	}
+	rtb.WithChainIdAndSource(rp.chainId, rp.source).
+		WithAccessKey(rp.accessKey)
+	targetUrls, code, err := s.p.selectTargets(&rp, rtb)
+	if err != nil {
+		s.replyError(id, -32000, err.Error())
+		s.p.trace(rtb.WithError(code, err.Error()).Build())
+		return
+	}
+
+	ctx, cancel := s.p.Timeout.apply(s.ctx, rp.chainId, rp.rpcMethod)
+	defer cancel()
+	resp, respBodyBytes, err := s.p.hedgedForward(ctx, rtb, &rp, targetUrls)
+	if err != nil {
+		if _, ok := err.(*RetryableError); !ok {
+			s.replyError(id, -32603, "upstream unavailable")
+			s.p.trace(rtb.WithError(http.StatusBadGateway, err.Error()).Build())
+			return
+		}
+	}
+	rtb.WithVersion(jsonRpcVersion)
+	if rtb.rt.Status == strconv.Itoa(http.StatusMultiStatus) {
+		respBodyBytes, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			s.replyError(id, -32603, err.Error())
+			return
+		}
+	}
+	if len(respBodyBytes) == 0 {
+		s.replyError(id, -32603, fmt.Sprintf("upstream status %d", resp.StatusCode))
+	} else {
+		s.write(respBodyBytes)
+	}
+	s.p.trace(rtb.Build())
+}
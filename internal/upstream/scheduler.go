@@ -0,0 +1,200 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pundix/chain-gateway/internal/client"
+)
+
+const (
+	// checkSchedulerTick is how often the scheduler looks for rules that are due
+	checkSchedulerTick = 5 * time.Second
+	checkWorkers       = 4
+	checkInterval      = time.Minute
+	checkTimeout       = 2 * time.Minute
+)
+
+// checkScheduler runs every check rule on its own interval, a few at a time.
+// A rule that fails or times out records its error and holds up no other rule,
+// a rule still running when it is due again is skipped
+type checkScheduler struct {
+	app core.App
+	col *UpstreamCol
+	cli *client.ChainGatewayClient
+
+	workers chan struct{}
+	done    chan struct{}
+
+	mu      sync.Mutex
+	running map[string]bool
+	lastRun map[string]time.Time
+}
+
+func newCheckScheduler(app core.App, col *UpstreamCol, cli *client.ChainGatewayClient) *checkScheduler {
+	return &checkScheduler{
+		app:     app,
+		col:     col,
+		cli:     cli,
+		done:    make(chan struct{}),
+		running: make(map[string]bool),
+		lastRun: make(map[string]time.Time),
+	}
+}
+
+func (s *checkScheduler) start() {
+	workers := checkWorkers
+	conf, err := s.col.getHealthCheckConfig(s.app)
+	if err != nil {
+		s.app.Logger().Error("get health check config fail", "error", err.Error())
+	} else if conf.Workers > 0 {
+		workers = conf.Workers
+	}
+	s.workers = make(chan struct{}, workers)
+
+	go func() {
+		ticker := time.NewTicker(checkSchedulerTick)
+		defer ticker.Stop()
+		for {
+			s.tick()
+			select {
+			case <-ticker.C:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+func (s *checkScheduler) stop() {
+	close(s.done)
+}
+
+// tick starts the enabled rules that are due, with the chain's current pool
+func (s *checkScheduler) tick() {
+	rules, invalid, err := s.col.getCheckRules(s.app)
+	if err != nil {
+		s.app.Logger().Error("get check rules fail", "error", err.Error())
+		return
+	}
+	pools := make(map[client.Protocol]map[string][]string)
+	now := time.Now()
+	for _, rule := range rules {
+		if rule.Disabled {
+			continue
+		}
+		interval, timeout, err := ruleSchedule(rule)
+		if invalid[rule.Id] != nil {
+			err = invalid[rule.Id]
+		}
+		if err != nil {
+			// a broken rule reports its error once per default interval
+			if s.due(rule.Id, checkInterval, now) {
+				s.mark(rule.Id, now, false)
+				s.finish(rule, err)
+			}
+			continue
+		}
+		if !s.due(rule.Id, interval, now) {
+			continue
+		}
+		// every protocol has its own pool of candidate urls
+		pool, ok := pools[rule.Protocol]
+		if !ok {
+			if pool, err = s.col.getRpcsGroupByChainId(s.app, rule.Protocol); err != nil {
+				s.app.Logger().Error("get available rpc fail", "protocol", rule.Protocol, "error", err.Error())
+				continue
+			}
+			pools[rule.Protocol] = pool
+		}
+		urls, ok := pool[rule.ChainId]
+		if !ok {
+			continue
+		}
+		s.mark(rule.Id, now, true)
+		go s.run(rule, urls, timeout)
+	}
+}
+
+func (s *checkScheduler) due(id string, interval time.Duration, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[id] {
+		s.app.Logger().Debug("check rule is running", "id", id)
+		return false
+	}
+	return now.Sub(s.lastRun[id]) >= interval
+}
+
+func (s *checkScheduler) mark(id string, now time.Time, running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRun[id] = now
+	if running {
+		s.running[id] = true
+	}
+}
+
+// run checks a rule once a worker is free. A run that times out is reported and frees its worker at its deadline,
+// its requests are canceled with it and the rule's next run waits until it has returned
+func (s *checkScheduler) run(rule *client.CheckRule, urls []string, timeout time.Duration) {
+	s.workers <- struct{}{}
+	var release sync.Once
+	free := func() {
+		release.Do(func() { <-s.workers })
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			free()
+			cancel()
+			s.mu.Lock()
+			delete(s.running, rule.Id)
+			s.mu.Unlock()
+		}()
+		result <- s.col.checkRule(ctx, s.app, s.cli, rule, urls)
+	}()
+	select {
+	case err := <-result:
+		s.finish(rule, err)
+	case <-ctx.Done():
+		select {
+		case err := <-result:
+			// finished as the deadline fired
+			s.finish(rule, err)
+		default:
+			free()
+			s.finish(rule, fmt.Errorf("check timed out after %s", timeout))
+		}
+	}
+}
+
+func (s *checkScheduler) finish(rule *client.CheckRule, err error) {
+	if err != nil {
+		s.app.Logger().Error("check upstream fail", "source", rule.Source, "chainId", rule.ChainId, "error", err.Error())
+	}
+	if err = s.col.saveRuleRun(s.app, rule.Id, err); err != nil {
+		s.app.Logger().Error("save check rule run fail", "id", rule.Id, "error", err.Error())
+	}
+}
+
+// ruleSchedule returns the rule's interval and timeout
+func ruleSchedule(rule *client.CheckRule) (time.Duration, time.Duration, error) {
+	interval, timeout := checkInterval, checkTimeout
+	var err error
+	if rule.Interval != "" {
+		if interval, err = time.ParseDuration(rule.Interval); err != nil || interval <= 0 {
+			return 0, 0, fmt.Errorf("invalid interval %q", rule.Interval)
+		}
+	}
+	if rule.Timeout != "" {
+		if timeout, err = time.ParseDuration(rule.Timeout); err != nil || timeout <= 0 {
+			return 0, 0, fmt.Errorf("invalid timeout %q", rule.Timeout)
+		}
+	}
+	return interval, timeout, nil
+}
@@ -0,0 +1,233 @@
+// pocketbase v0.32 overflows the stack under encoding/json v2, run these with GOEXPERIMENT=nojsonv2 on newer toolchains
+//go:build !goexperiment.jsonv2
+
+package upstream
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pundix/chain-gateway/internal/checker"
+	"github.com/pundix/chain-gateway/internal/client"
+	_ "github.com/pundix/chain-gateway/migrations"
+)
+
+func newTestApp(t *testing.T) *tests.TestApp {
+	t.Helper()
+	app, err := tests.NewTestApp(t.TempDir())
+	if err != nil {
+		t.Fatalf("new test app: %v", err)
+	}
+	t.Cleanup(app.Cleanup)
+	if err = app.RunAllMigrations(); err != nil {
+		t.Fatalf("run migrations: %v", err)
+	}
+	return app
+}
+
+func findStatus(t *testing.T, app core.App, source, url string) *core.Record {
+	t.Helper()
+	records, err := app.FindAllRecords("node_status", dbx.HashExp{"source": source, "url": url})
+	if err != nil {
+		t.Fatalf("find node status: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d node_status records of %s for %s, want 1", len(records), url, source)
+	}
+	return records[0]
+}
+
+func TestSaveCheckResults_statusPerSource(t *testing.T) {
+	app := newTestApp(t)
+	c := &UpstreamCol{}
+	url := "https://node.example.com"
+	save := func(source string, passed bool) {
+		t.Helper()
+		results := map[string]checker.NodeResult{url: {Strategy: checker.CHECK_STRATEGY_SIMPLE, Passed: passed}}
+		if err := c.saveCheckResults(app, source, client.PROTOCOL_JSONRPC, "1", results); err != nil {
+			t.Fatalf("save check results: %v", err)
+		}
+	}
+
+	save("manual", true)
+	save("chainlist", false)
+	manual := findStatus(t, app, "manual", url)
+	changed := manual.GetDateTime("status_changed")
+
+	// the other source judging the node differently doesn't flip this source's state
+	save("manual", true)
+	save("chainlist", false)
+	manual = findStatus(t, app, "manual", url)
+	if !manual.GetBool("passed") {
+		t.Errorf("manual passed = false, want true")
+	}
+	if got := manual.GetDateTime("status_changed"); !got.Equal(changed) {
+		t.Errorf("manual status_changed moved from %s to %s", changed, got)
+	}
+	if findStatus(t, app, "chainlist", url).GetBool("passed") {
+		t.Errorf("chainlist passed = true, want false")
+	}
+
+	save("manual", false)
+	manual = findStatus(t, app, "manual", url)
+	if manual.GetBool("passed") || !manual.GetDateTime("status_changed").After(changed) {
+		t.Errorf("manual failure not recorded: passed %v, status_changed %s", manual.GetBool("passed"), manual.GetDateTime("status_changed"))
+	}
+}
+
+func TestUpsertRecord_concurrentInsert(t *testing.T) {
+	app := newTestApp(t)
+	collection, err := app.FindCollectionByNameOrId("upstream_node")
+	if err != nil {
+		t.Fatalf("find collection: %v", err)
+	}
+	key := dbx.HashExp{"protocol": "jsonrpc", "chain_id": "1", "url": "https://node.example.com"}
+
+	calls := 0
+	err = upsertRecord(app, collection, key, func(record *core.Record) {
+		calls++
+		if calls > 1 {
+			record.Set("latency_p50", 20)
+			return
+		}
+		// another rule creates the node between our lookup and our insert
+		other := core.NewRecord(collection)
+		for k, v := range key {
+			other.Set(k, v)
+		}
+		other.Set("latency_p95", 90)
+		if err := app.Save(other); err != nil {
+			t.Fatalf("save competing record: %v", err)
+		}
+		record.Set("latency_p50", 20)
+	})
+	if err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("apply ran %d times, want 2", calls)
+	}
+
+	records, err := app.FindAllRecords(collection, key)
+	if err != nil {
+		t.Fatalf("find records: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	if p50, p95 := records[0].GetInt("latency_p50"), records[0].GetInt("latency_p95"); p50 != 20 || p95 != 90 {
+		t.Errorf("latency = %d/%d, want 20/90", p50, p95)
+	}
+}
+
+func TestSaveNodeReports_height(t *testing.T) {
+	app := newTestApp(t)
+	c := &UpstreamCol{}
+	a, b := "https://a.example.com", "https://b.example.com"
+	checked := map[nodeKey]bool{
+		{protocol: client.PROTOCOL_JSONRPC, chainId: "1", url: a}: true,
+		{protocol: client.PROTOCOL_JSONRPC, chainId: "1", url: b}: true,
+	}
+	results := map[string]checker.NodeResult{a: {Passed: true, Height: 100}, b: {Passed: false}}
+	if err := c.saveNodeReports(app, checked, nil, results); err != nil {
+		t.Fatalf("save node reports: %v", err)
+	}
+	records, err := app.FindAllRecords("upstream_node")
+	if err != nil {
+		t.Fatalf("find nodes: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d nodes, want only the one with a height", len(records))
+	}
+	if records[0].GetString("url") != a || records[0].GetInt("height") != 100 || records[0].GetDateTime("height_checked").IsZero() {
+		t.Errorf("node %s height %d checked %s, want %s at 100", records[0].GetString("url"), records[0].GetInt("height"), records[0].GetDateTime("height_checked"), a)
+	}
+}
+
+func TestDampResults(t *testing.T) {
+	app := newTestApp(t)
+	col := &UpstreamCol{}
+	rule := &client.CheckRule{ChainId: "1", FailThreshold: 2, PassThreshold: 3}
+	a, b, c := "https://a.example.com", "https://b.example.com", "https://c.example.com"
+	pool := []string{a, b, c}
+	run := func(urls []string, passed map[string]bool) []string {
+		t.Helper()
+		results := make(map[string]checker.NodeResult, len(passed))
+		for url, ok := range passed {
+			results[url] = checker.NodeResult{Passed: ok}
+		}
+		ready, err := col.dampResults(app, "manual", client.PROTOCOL_JSONRPC, rule, urls, results)
+		if err != nil {
+			t.Fatalf("damp results: %v", err)
+		}
+		return ready
+	}
+	tests := []struct {
+		name   string
+		urls   []string
+		passed map[string]bool
+		want   []string
+	}{
+		{name: "first sighting follows the result", urls: pool, passed: map[string]bool{a: true, b: true, c: false}, want: []string{a, b}},
+		{name: "one failure keeps a ready node", urls: pool, passed: map[string]bool{a: false, b: true, c: true}, want: []string{a, b}},
+		{name: "a pass resets the failure streak", urls: pool, passed: map[string]bool{a: true, b: true, c: true}, want: []string{a, b}},
+		{name: "pass threshold restores a node", urls: pool, passed: map[string]bool{a: false, b: true, c: true}, want: pool},
+		{name: "fail threshold removes a node", urls: pool, passed: map[string]bool{a: false, b: true, c: true}, want: []string{b, c}},
+		{name: "one pass doesn't restore it", urls: pool, passed: map[string]bool{a: true, b: true, c: true}, want: []string{b, c}},
+		{name: "node leaves the pool", urls: []string{b, c}, passed: map[string]bool{b: true, c: true}, want: []string{b, c}},
+		{name: "returning node starts over", urls: pool, passed: map[string]bool{a: true, b: true, c: true}, want: pool},
+	}
+	for _, tt := range tests {
+		ready := run(tt.urls, tt.passed)
+		if !slices.Equal(ready, tt.want) {
+			t.Fatalf("%s: ready = %v, want %v", tt.name, ready, tt.want)
+		}
+		if tt.name == "node leaves the pool" {
+			if records, _ := app.FindAllRecords("check_state", dbx.HashExp{"url": a}); len(records) != 0 {
+				t.Fatalf("%s: state of %s kept", tt.name, a)
+			}
+		}
+	}
+}
+
+func TestSaveSafeguardEvent(t *testing.T) {
+	app := newTestApp(t)
+	c := &UpstreamCol{}
+	rule := &client.CheckRule{ChainId: "1", Source: "manual", Protocol: client.PROTOCOL_JSONRPC}
+	err := c.saveSafeguardEvent(app, rule, []string{"min_ready", "max_shrink"}, 4, []string{"https://a.example.com"}, []string{"https://b.example.com"})
+	if err != nil {
+		t.Fatalf("save safeguard event: %v", err)
+	}
+	records, err := app.FindAllRecords("safeguard_event", dbx.HashExp{"chain_id": "1"})
+	if err != nil {
+		t.Fatalf("find safeguard events: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d safeguard events, want 1", len(records))
+	}
+	event := records[0]
+	if got := event.GetStringSlice("safeguards"); !slices.Equal(got, []string{"min_ready", "max_shrink"}) {
+		t.Errorf("safeguards = %v", got)
+	}
+	if event.GetInt("previous") != 4 || event.GetInt("passed") != 1 || event.GetInt("ready") != 2 {
+		t.Errorf("previous/passed/ready = %d/%d/%d, want 4/1/2", event.GetInt("previous"), event.GetInt("passed"), event.GetInt("ready"))
+	}
+	var restored []string
+	if err = event.UnmarshalJSONField("restored", &restored); err != nil || !slices.Equal(restored, []string{"https://b.example.com"}) {
+		t.Errorf("restored = %v, %v", restored, err)
+	}
+
+	// events are pruned with the check results
+	if _, err = app.DB().NewQuery("UPDATE {{safeguard_event}} SET [[created]] = '2020-01-01 00:00:00.000Z'").Execute(); err != nil {
+		t.Fatalf("age safeguard event: %v", err)
+	}
+	if err = c.pruneCheckResults(app); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if records, _ = app.FindAllRecords("safeguard_event"); len(records) != 0 {
+		t.Errorf("%d safeguard events left after pruning", len(records))
+	}
+}
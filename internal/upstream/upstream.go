@@ -1,15 +1,21 @@
 package upstream
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
 	collection "github.com/pundix/chain-gateway/internal"
 	"github.com/pundix/chain-gateway/internal/checker"
 	"github.com/pundix/chain-gateway/internal/client"
@@ -79,6 +85,12 @@ func (c *UpstreamCol) Apply(app core.App, cli *client.ChainGatewayClient) {
 		return e.Next()
 	})
 
+	app.Cron().MustAdd("prune-check-result", "0 * * * *", func() {
+		if err := c.pruneCheckResults(app); err != nil {
+			app.Logger().Error("prune check results fail", "error", err.Error())
+		}
+	})
+
 	app.Cron().MustAdd("fetch-upstream", "*/5 * * * *", func() {
 		upstreamFetchers := []UpstreamFetcher{}
 		for _, fetcher := range upstreamFetchers {
@@ -86,87 +98,421 @@ func (c *UpstreamCol) Apply(app core.App, cli *client.ChainGatewayClient) {
 		}
 	})
 
-	upstreamChecking := false
-	app.Cron().MustAdd("check-upstream", "* * * * *", func() {
-		if upstreamChecking {
-			app.Logger().Debug("check upstream is running")
-			return
+	scheduler := newCheckScheduler(app, c, cli)
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		scheduler.start()
+		return se.Next()
+	})
+	app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+		scheduler.stop()
+		return e.Next()
+	})
+}
+
+// checkRule runs a rule against the chain's pool and saves the urls that stay ready.
+// The checks' requests are bound to ctx and nothing is saved once it is done, a late run must not overwrite a newer one.
+// Responses are cached for the run only, a node is never judged on an earlier run's answer
+func (c *UpstreamCol) checkRule(ctx context.Context, app core.App, cli *client.ChainGatewayClient, rule *client.CheckRule, urls []string) error {
+	source, protocol := rule.Source, rule.Protocol
+	mainChecker := checker.New(cli.Cli, time.Minute)
+	if common, ok := mainChecker.(*checker.CommonChecker); ok {
+		common.LoadProtoset = c.protosetLoader(app)
+	}
+	results, err := rule.Rules.CheckResults(ctx, mainChecker, rule.ChainId, urls, checker.CheckCaches{})
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = c.saveCheckResults(app, source, protocol, rule.ChainId, results); err != nil {
+		app.Logger().Error("save check results fail", "source", source, "chainId", rule.ChainId, "error", err.Error())
+	}
+	ready, err := c.dampResults(app, source, protocol, rule, urls, results)
+	if err != nil {
+		return err
+	}
+	previous, err := c.getReadyUrls(app, source, protocol, rule.ChainId)
+	if err != nil {
+		return err
+	}
+	passed := len(ready)
+	ready, safeguards := guardReady(rule, urls, ready, previous, results)
+	if len(safeguards) > 0 {
+		app.Logger().Warn("ready upstream safeguard", "source", source, "chainId", rule.ChainId, "safeguards", safeguards,
+			"previous", len(previous), "passed", passed, "ready", len(ready))
+		if err = c.saveSafeguardEvent(app, rule, safeguards, len(previous), ready[:passed], ready[passed:]); err != nil {
+			app.Logger().Error("save safeguard event fail", "source", source, "chainId", rule.ChainId, "error", err.Error())
 		}
-		upstreamChecking = true
-		defer func() {
-			upstreamChecking = false
-		}()
+	}
+	updateLen, err := c.saveReadyUpsteam(app, &client.Upstream{
+		ChainId:  rule.ChainId,
+		Source:   source,
+		RPC:      strings.Join(ready, ","),
+		Protocol: protocol,
+	})
+	if err != nil {
+		return err
+	}
+	if updateLen != 0 {
+		app.Logger().Info("save ready upstream success", "source", source, "chainId", rule.ChainId, "count", updateLen)
+	}
+
+	var reports map[string]checker.NodeReport
+	if reporter, ok := mainChecker.(checker.Reporter); ok {
+		reports = reporter.Reports()
+	}
+	checked := make(map[nodeKey]bool, len(urls))
+	for _, u := range urls {
+		checked[nodeKey{protocol: protocol, chainId: rule.ChainId, url: u}] = true
+	}
+	if err := c.saveNodeReports(app, checked, reports, results); err != nil {
+		app.Logger().Error("save node reports fail", "source", source, "chainId", rule.ChainId, "error", err.Error())
+	}
+	return nil
+}
 
-		jsonrpcs, err := c.getRpcsGroupByChainId(app, client.PROTOCOL_JSONRPC)
+// protosetLoader reads descriptor sets uploaded to the protoset collection, gRPC checks refer to them by name
+func (c *UpstreamCol) protosetLoader(app core.App) func(name string) ([]byte, error) {
+	return func(name string) ([]byte, error) {
+		record, err := app.FindFirstRecordByData("protoset", "name", name)
+		if err != nil {
+			return nil, err
+		}
+		fsys, err := app.NewFilesystem()
 		if err != nil {
-			app.Logger().Error("get available rpc fail", "error", err.Error())
-			return
+			return nil, err
+		}
+		defer fsys.Close()
+		r, err := fsys.GetReader(record.BaseFilesPath() + "/" + record.GetString("file"))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+}
+
+type nodeKey struct {
+	protocol client.Protocol
+	chainId  string
+	url      string
+}
+
+// saveNodeReports stores what the checks measured on each node in upstream_node,
+// so balancers can weight nodes by latency, route by capability tags and by block height
+func (c *UpstreamCol) saveNodeReports(app core.App, checked map[nodeKey]bool, reports map[string]checker.NodeReport, results map[string]checker.NodeResult) error {
+	collection, err := app.FindCollectionByNameOrId("upstream_node")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for node := range checked {
+		report := reports[node.url]
+		height := results[node.url].Height
+		if report.LatencyP50 == 0 && report.Tags == nil && height <= 0 {
+			continue
 		}
-		grpcs, err := c.getRpcsGroupByChainId(app, client.PROTOCOL_GRPC)
+		key := dbx.HashExp{"protocol": string(node.protocol), "chain_id": node.chainId, "url": node.url}
+		err = upsertRecord(app, collection, key, func(record *core.Record) {
+			if report.LatencyP50 > 0 {
+				record.Set("latency_p50", report.LatencyP50.Milliseconds())
+				record.Set("latency_p95", report.LatencyP95.Milliseconds())
+				record.Set("latency_checked", now)
+			}
+			if report.Tags != nil {
+				record.Set("tags", report.Tags)
+				record.Set("tags_checked", now)
+			}
+			if height > 0 {
+				record.Set("height", height)
+				record.Set("height_checked", now)
+			}
+		})
 		if err != nil {
-			app.Logger().Error("get available grpc fail", "error", err.Error())
-			return
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertRecord updates the record matching key, or creates it with the key's values.
+// Rules run concurrently, when another one created the record first its record is updated instead
+func upsertRecord(app core.App, collection *core.Collection, key dbx.HashExp, apply func(record *core.Record)) error {
+	record, err := findRecord(app, collection, key)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		record = core.NewRecord(collection)
+		for k, v := range key {
+			record.Set(k, v)
 		}
+	}
+	apply(record)
+	if err = app.Save(record); err == nil || !record.IsNew() {
+		return err
+	}
+	existing, findErr := findRecord(app, collection, key)
+	if findErr != nil || existing == nil {
+		return err
+	}
+	apply(existing)
+	return app.Save(existing)
+}
 
-		checkRules, err := c.getCheckRulesGroupBySource(app)
+// findRecord returns the record matching key, nil when there is none
+func findRecord(app core.App, collection *core.Collection, key dbx.HashExp) (*core.Record, error) {
+	records, err := app.FindAllRecords(collection, key)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+// saveCheckResults keeps the outcome of a rule on each node in check_result, and the node's current state
+// for the rule's source, with when it last changed, in node_status
+func (c *UpstreamCol) saveCheckResults(app core.App, source string, protocol client.Protocol, chainId string, results map[string]checker.NodeResult) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		resultCollection, err := txApp.FindCollectionByNameOrId("check_result")
+		if err != nil {
+			return err
+		}
+		statusCollection, err := txApp.FindCollectionByNameOrId("node_status")
 		if err != nil {
-			app.Logger().Error("get check rules fail", "error", err.Error())
-			return
+			return err
 		}
-		caches := checker.CheckCaches{}
-		mainChecker := checker.New(cli.Cli, time.Minute)
+		now := time.Now()
+		for url, result := range results {
+			record := core.NewRecord(resultCollection)
+			record.Set("source", source)
+			record.Set("protocol", protocol)
+			record.Set("chain_id", chainId)
+			record.Set("url", url)
+			record.Set("strategy", string(result.Strategy))
+			record.Set("passed", result.Passed)
+			record.Set("reason", result.Reason)
+			record.Set("height", result.Height)
+			record.Set("latency", result.Latency.Milliseconds())
+			if err = txApp.Save(record); err != nil {
+				return err
+			}
 
-		for source, rules := range checkRules {
-			for _, rule := range rules {
-				if rule.Disabled {
-					continue
-				}
-				var urls []string
-				var ok bool
-				if rule.Protocol == client.PROTOCOL_GRPC {
-					urls, ok = grpcs[rule.ChainId]
-				} else {
-					urls, ok = jsonrpcs[rule.ChainId]
+			key := dbx.HashExp{"source": source, "protocol": string(protocol), "chain_id": chainId, "url": url}
+			err = upsertRecord(txApp, statusCollection, key, func(status *core.Record) {
+				if status.IsNew() || status.GetBool("passed") != result.Passed {
+					status.Set("status_changed", now)
 				}
-				if !ok {
-					continue
-				}
-				// go func(checker checker.HealthChecker, urls []string, caches checker.CheckCaches) {
-				ret, err := rule.Rules.Check(mainChecker, rule.ChainId, urls, caches)
-				if err != nil {
-					app.Logger().Error("check upstream fail", "source", source, "chainId", rule.ChainId, "error", err.Error())
-					return
-				}
-				urls = lo.Filter(urls, func(u string, _ int) bool {
-					return ret[u]
-				})
-				updateLen, err := c.saveReadyUpsteam(app, &client.Upstream{
-					ChainId:  rule.ChainId,
-					Source:   source,
-					RPC:      strings.Join(urls, ","),
-					Protocol: rule.Protocol,
-				})
-				if err != nil {
-					app.Logger().Error("save ready upstream fail", "source", source, "chainId", rule.ChainId, "error", err.Error())
-					return
-				}
-				if updateLen != 0 {
-					app.Logger().Info("save ready upstream success", "source", source, "chainId", rule.ChainId, "count", updateLen)
-				}
-				// }(mainChecker, urls, caches)
+				status.Set("passed", result.Passed)
+				status.Set("reason", result.Reason)
+				status.Set("checked", now)
+			})
+			if err != nil {
+				return err
 			}
 		}
+		return nil
 	})
 }
 
-func (c *UpstreamCol) saveReadyUpsteam(app core.App, upstream *client.Upstream) (int, error) {
-	updateLen := len(strings.Split(upstream.RPC, ","))
+// saveSafeguardEvent records a run whose ready list was held up by a safeguard in safeguard_event,
+// passed are the urls that passed and restored the failed ones kept ready
+func (c *UpstreamCol) saveSafeguardEvent(app core.App, rule *client.CheckRule, safeguards []string, previous int, passed, restored []string) error {
+	collection, err := app.FindCollectionByNameOrId("safeguard_event")
+	if err != nil {
+		return err
+	}
+	record := core.NewRecord(collection)
+	record.Set("source", rule.Source)
+	record.Set("protocol", rule.Protocol)
+	record.Set("chain_id", rule.ChainId)
+	record.Set("safeguards", safeguards)
+	record.Set("previous", previous)
+	record.Set("passed", len(passed))
+	record.Set("ready", len(passed)+len(restored))
+	record.Set("restored", restored)
+	return app.Save(record)
+}
+
+// pruneCheckResults deletes check results and safeguard events older than the resultRetention
+// of the health_check config, 7 days by default
+func (c *UpstreamCol) pruneCheckResults(app core.App) error {
+	retention := 7 * 24 * time.Hour
+	conf, err := c.getHealthCheckConfig(app)
+	if err != nil {
+		return err
+	}
+	if conf.ResultRetention != "" {
+		if retention, err = time.ParseDuration(conf.ResultRetention); err != nil {
+			return err
+		}
+	}
+	before, err := types.ParseDateTime(time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"check_result", "safeguard_event"} {
+		_, err = app.DB().NewQuery("DELETE FROM {{" + table + "}} WHERE [[created]] < {:before}").
+			Bind(dbx.Params{"before": before.String()}).
+			Execute()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dampResults returns the urls that stay ready under the rule's hysteresis: a ready node is removed after
+// FailThreshold failures in a row and restored after PassThreshold passes in a row.
+// The streaks are kept in check_state, a node seen for the first time is ready when it passes
+func (c *UpstreamCol) dampResults(app core.App, source string, protocol client.Protocol, rule *client.CheckRule, urls []string, results map[string]checker.NodeResult) ([]string, error) {
+	failThreshold := max(rule.FailThreshold, 1)
+	passThreshold := max(rule.PassThreshold, 1)
+	var ready []string
+	err := app.RunInTransaction(func(txApp core.App) error {
+		collection, err := txApp.FindCollectionByNameOrId("check_state")
+		if err != nil {
+			return err
+		}
+		records, err := txApp.FindAllRecords(collection,
+			dbx.HashExp{"source": source, "protocol": protocol, "chain_id": rule.ChainId},
+		)
+		if err != nil {
+			return err
+		}
+		states := lo.KeyBy(records, func(record *core.Record) string {
+			return record.GetString("url")
+		})
+		for _, u := range urls {
+			state, ok := states[u]
+			delete(states, u)
+			if !ok {
+				state = core.NewRecord(collection)
+				state.Set("source", source)
+				state.Set("protocol", protocol)
+				state.Set("chain_id", rule.ChainId)
+				state.Set("url", u)
+				state.Set("ready", results[u].Passed)
+			}
+			fails, passes := state.GetInt("fails"), state.GetInt("passes")
+			if results[u].Passed {
+				fails, passes = 0, passes+1
+			} else {
+				fails, passes = fails+1, 0
+			}
+			wasReady := state.GetBool("ready")
+			isReady := wasReady
+			if wasReady && fails >= failThreshold {
+				isReady = false
+			} else if !wasReady && passes >= passThreshold {
+				isReady = true
+			}
+			if !state.IsNew() && isReady != results[u].Passed {
+				app.Logger().Debug("node state damped", "source", source, "chainId", rule.ChainId, "url", u, "ready", isReady, "fails", fails, "passes", passes)
+			}
+			if isReady {
+				ready = append(ready, u)
+			}
+			state.Set("ready", isReady)
+			state.Set("fails", fails)
+			state.Set("passes", passes)
+			if err = txApp.Save(state); err != nil {
+				return err
+			}
+		}
+		// nodes that left the pool start over when they come back
+		for _, state := range states {
+			if err = txApp.Delete(state); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return ready, err
+}
+
+// guardReady keeps the ready list from collapsing when checks fail en masse: it never drops below the rule's
+// MinReady nodes and never loses more than MaxShrink percent of the previous list in one run.
+// Failed nodes are put back best first, previously ready ones, then the highest and the fastest.
+// It returns the guarded list and the safeguards that kicked in
+func guardReady(rule *client.CheckRule, pool, ready, previous []string, results map[string]checker.NodeResult) ([]string, []string) {
+	wasReady := lo.SliceToMap(previous, func(u string) (string, bool) { return u, true })
+	isReady := lo.SliceToMap(ready, func(u string) (string, bool) { return u, true })
+	candidates := lo.Filter(pool, func(u string, _ int) bool { return !isReady[u] })
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return betterNode(candidates[i], candidates[j], wasReady, results)
+	})
+
+	var safeguards []string
+	restore := 0
+	if need := max(rule.MinReady, 1) - len(ready); need > 0 {
+		restore = need
+		safeguards = append(safeguards, "min_ready")
+	}
+	if rule.MaxShrink > 0 && len(previous) > 0 {
+		keep := int(math.Ceil(float64(len(previous)) * (1 - rule.MaxShrink/100)))
+		kept := lo.CountBy(ready, func(u string) bool { return wasReady[u] })
+		restorable := lo.CountBy(candidates, func(u string) bool { return wasReady[u] })
+		if need := min(keep-kept, restorable); need > 0 {
+			restore = max(restore, need)
+			safeguards = append(safeguards, "max_shrink")
+		}
+	}
+	restore = min(restore, len(candidates))
+	if restore == 0 {
+		return ready, nil
+	}
+	return append(ready, candidates[:restore]...), safeguards
+}
+
+func betterNode(a, b string, wasReady map[string]bool, results map[string]checker.NodeResult) bool {
+	if wasReady[a] != wasReady[b] {
+		return wasReady[a]
+	}
+	ra, rb := results[a], results[b]
+	if ra.Height != rb.Height {
+		return ra.Height > rb.Height
+	}
+	// an unmeasured latency ranks last
+	la, lb := ra.Latency, rb.Latency
+	if la == 0 {
+		la = math.MaxInt64
+	}
+	if lb == 0 {
+		lb = math.MaxInt64
+	}
+	return la < lb
+}
+
+func (c *UpstreamCol) findReadyUpstream(app core.App, source string, protocol client.Protocol, chainId string) (*core.Record, error) {
 	record, err := app.FindFirstRecordByFilter(
 		"upstream",
 		"protocol = {:protocol} && ready = true && source = {:source} && chain_id = {:chain_id}",
-		dbx.Params{"protocol": upstream.Protocol, "source": upstream.Source, "chain_id": upstream.ChainId},
+		dbx.Params{"protocol": protocol, "source": source, "chain_id": chainId},
 	)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return record, nil
+}
+
+// getReadyUrls returns the current ready list of a source and chain
+func (c *UpstreamCol) getReadyUrls(app core.App, source string, protocol client.Protocol, chainId string) ([]string, error) {
+	record, err := c.findReadyUpstream(app, source, protocol, chainId)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	var urls []string
+	if err = record.UnmarshalJSONField("rpc", &urls); err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+func (c *UpstreamCol) saveReadyUpsteam(app core.App, upstream *client.Upstream) (int, error) {
+	updateLen := len(strings.Split(upstream.RPC, ","))
+	record, err := c.findReadyUpstream(app, upstream.Source, upstream.Protocol, upstream.ChainId)
+	if err != nil {
 		return -1, err
 	}
 	if record != nil && record.Get("rpc") == upstream.JsonStr() {
@@ -219,27 +565,69 @@ func (c *UpstreamCol) getRpcsGroupByChainId(app core.App, protocol client.Protoc
 	return ret, nil
 }
 
-func (c *UpstreamCol) getCheckRulesGroupBySource(app core.App) (map[string][]*client.CheckRule, error) {
+// getCheckRules returns every check rule, and the errors of rules whose conditions can't be read by rule id.
+// Such rules come without conditions
+func (c *UpstreamCol) getCheckRules(app core.App) ([]*client.CheckRule, map[string]error, error) {
 	records, err := app.FindAllRecords("check_rule")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	checkRules := lo.Map(records, func(record *core.Record, index int) *client.CheckRule {
+	checkRules := make([]*client.CheckRule, 0, len(records))
+	invalid := make(map[string]error)
+	for _, record := range records {
 		var rules checker.HealthCheckConditionList
-		if err = record.UnmarshalJSONField("rules", &rules); err != nil {
-			return nil
+		if err := record.UnmarshalJSONField("rules", &rules); err != nil {
+			invalid[record.Id] = fmt.Errorf("invalid rules: %w", err)
 		}
-		return &client.CheckRule{
-			ChainId:  record.GetString("chain_id"),
-			Source:   record.GetString("source"),
-			Protocol: client.Protocol(record.GetString("protocol")),
-			Rules:    rules,
-			Disabled: record.GetBool("disabled"),
+		protocol := client.Protocol(record.GetString("protocol"))
+		if protocol == "" {
+			protocol = client.PROTOCOL_JSONRPC
 		}
-	})
-	return lo.GroupBy(checkRules, func(item *client.CheckRule) string {
-		return item.Source
-	}), nil
+		checkRules = append(checkRules, &client.CheckRule{
+			Id:            record.Id,
+			ChainId:       record.GetString("chain_id"),
+			Source:        record.GetString("source"),
+			Protocol:      protocol,
+			Rules:         rules,
+			Disabled:      record.GetBool("disabled"),
+			FailThreshold: record.GetInt("fail_threshold"),
+			PassThreshold: record.GetInt("pass_threshold"),
+			MinReady:      record.GetInt("min_ready"),
+			MaxShrink:     record.GetFloat("max_shrink"),
+			Interval:      record.GetString("interval"),
+			Timeout:       record.GetString("timeout"),
+		})
+	}
+	return checkRules, invalid, nil
+}
+
+// saveRuleRun records when a rule last ran and its error, empty on success.
+// Only these fields are written, so edits made to the rule meanwhile are kept
+func (c *UpstreamCol) saveRuleRun(app core.App, id string, runErr error) error {
+	lastError := ""
+	if runErr != nil {
+		lastError = runErr.Error()
+	}
+	_, err := app.DB().Update("check_rule", dbx.Params{
+		"last_run":   types.NowDateTime().String(),
+		"last_error": lastError,
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// getHealthCheckConfig returns the health_check config of the upstream module, empty when there is none
+func (c *UpstreamCol) getHealthCheckConfig(app core.App) (*config.HealthCheckConfig, error) {
+	var conf config.HealthCheckConfig
+	record, err := app.FindFirstRecordByFilter("config", "module = 'upstream' && key = 'health_check'")
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if record != nil {
+		if err = record.UnmarshalJSONField("value", &conf); err != nil {
+			return nil, err
+		}
+	}
+	return &conf, nil
 }
 
 func (c *UpstreamCol) getCloudflareWorkerConfig(app core.App) (*config.CloudflareWorkerConfig, error) {
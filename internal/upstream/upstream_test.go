@@ -0,0 +1,76 @@
+package upstream
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pundix/chain-gateway/internal/checker"
+	"github.com/pundix/chain-gateway/internal/client"
+)
+
+func TestBetterNode(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     checker.NodeResult
+		wasReady map[string]bool
+		want     bool
+	}{
+		{name: "previously ready first", a: checker.NodeResult{Height: 10}, b: checker.NodeResult{Height: 20}, wasReady: map[string]bool{"a": true}, want: true},
+		{name: "higher first", a: checker.NodeResult{Height: 20}, b: checker.NodeResult{Height: 10}, want: true},
+		{name: "lower last", a: checker.NodeResult{Height: 10}, b: checker.NodeResult{Height: 20}, want: false},
+		{name: "faster first", a: checker.NodeResult{Height: 10, Latency: time.Millisecond}, b: checker.NodeResult{Height: 10, Latency: time.Second}, want: true},
+		{name: "unmeasured latency last", a: checker.NodeResult{Height: 10}, b: checker.NodeResult{Height: 10, Latency: time.Second}, want: false},
+		{name: "measured before unmeasured", a: checker.NodeResult{Height: 10, Latency: time.Second}, b: checker.NodeResult{Height: 10}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := map[string]checker.NodeResult{"a": tt.a, "b": tt.b}
+			if got := betterNode("a", "b", tt.wasReady, results); got != tt.want {
+				t.Errorf("betterNode(a, b) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuardReady(t *testing.T) {
+	pool := []string{"a", "b", "c", "d"}
+	// d is the highest node, then c and b
+	results := map[string]checker.NodeResult{
+		"a": {Passed: true, Height: 100},
+		"b": {Height: 101},
+		"c": {Height: 102},
+		"d": {Height: 103},
+	}
+	tests := []struct {
+		name           string
+		rule           client.CheckRule
+		pool           []string
+		ready          []string
+		previous       []string
+		want           []string
+		wantSafeguards []string
+	}{
+		{name: "nothing to guard", pool: pool, ready: []string{"a"}, previous: []string{"a", "b"}, want: []string{"a"}},
+		{name: "min ready defaults to one", pool: pool, want: []string{"d"}, wantSafeguards: []string{"min_ready"}},
+		{name: "min ready restores the highest", rule: client.CheckRule{MinReady: 3}, pool: pool, ready: []string{"a"}, want: []string{"a", "d", "c"}, wantSafeguards: []string{"min_ready"}},
+		{name: "min ready prefers previously ready", rule: client.CheckRule{MinReady: 2}, pool: pool, ready: []string{"a"}, previous: []string{"a", "b"}, want: []string{"a", "b"}, wantSafeguards: []string{"min_ready"}},
+		{name: "min ready capped by the pool", rule: client.CheckRule{MinReady: 3}, pool: []string{"b"}, want: []string{"b"}, wantSafeguards: []string{"min_ready"}},
+		{name: "max shrink keeps half", rule: client.CheckRule{MaxShrink: 50}, pool: pool, ready: []string{"a"}, previous: pool, want: []string{"a", "d"}, wantSafeguards: []string{"max_shrink"}},
+		{name: "max shrink rounds kept nodes up", rule: client.CheckRule{MaxShrink: 60}, pool: pool, ready: []string{"a"}, previous: []string{"a", "b", "c"}, want: []string{"a", "c"}, wantSafeguards: []string{"max_shrink"}},
+		{name: "shrink within max shrink", rule: client.CheckRule{MaxShrink: 50}, pool: pool, ready: []string{"a"}, previous: []string{"a", "b"}, want: []string{"a"}},
+		{name: "max shrink without previous list", rule: client.CheckRule{MaxShrink: 50}, pool: pool, ready: []string{"a"}, want: []string{"a"}},
+		{name: "both safeguards", rule: client.CheckRule{MinReady: 3, MaxShrink: 50}, pool: pool, previous: []string{"b", "c"}, want: []string{"c", "b", "d"}, wantSafeguards: []string{"min_ready", "max_shrink"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, safeguards := guardReady(&tt.rule, tt.pool, slices.Clone(tt.ready), tt.previous, results)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ready = %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(safeguards, tt.wantSafeguards) {
+				t.Errorf("safeguards = %v, want %v", safeguards, tt.wantSafeguards)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package usage
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	collection "github.com/pundix/chain-gateway/internal"
+	"github.com/pundix/chain-gateway/internal/client"
+	"github.com/samber/lo"
+)
+
+// dimensions are the usage columns that can be filtered and grouped by
+var dimensions = []string{"protocol", "access_key", "group", "service", "chain_id", "method", "status"}
+
+func Me() collection.Collection {
+	return &UsageCol{}
+}
+
+type UsageCol struct {
+}
+
+// Apply registers GET /api/usage for superusers, it sums the hourly usage buckets in [from, to).
+//
+// Query params:
+//   - from, to: date (2006-01-02) or RFC3339 time, defaults to the last 24 hours
+//   - groupBy: comma separated dimensions, "hour" or "day", defaults to "group,service"
+//   - any dimension as an exact match filter, e.g. group=pundix
+func (c *UsageCol) Apply(app core.App, cli *client.ChainGatewayClient) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		se.Router.GET("/api/usage", handleUsage).Bind(apis.RequireSuperuserAuth())
+		return se.Next()
+	})
+}
+
+func handleUsage(e *core.RequestEvent) error {
+	query := e.Request.URL.Query()
+
+	to := time.Now().UTC()
+	if v := query.Get("to"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return e.BadRequestError("invalid to", err)
+		}
+		to = t
+	}
+	from := to.Add(-24 * time.Hour)
+	if v := query.Get("from"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return e.BadRequestError("invalid from", err)
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return e.BadRequestError("from must be before to", nil)
+	}
+
+	fromDt, _ := types.ParseDateTime(from)
+	toDt, _ := types.ParseDateTime(to)
+
+	groupBy := query.Get("groupBy")
+	if groupBy == "" {
+		groupBy = "group,service"
+	}
+	var selects, columns []string
+	for _, col := range strings.Split(groupBy, ",") {
+		col = strings.TrimSpace(col)
+		switch {
+		case col == "day":
+			selects = append(selects, "substr([[hour]], 1, 10) AS [[day]]")
+		case col == "hour" || lo.Contains(dimensions, col):
+			selects = append(selects, col)
+		default:
+			return e.BadRequestError("invalid groupBy "+col, nil)
+		}
+		columns = append(columns, col)
+	}
+
+	q := e.App.DB().
+		Select(selects...).
+		AndSelect("SUM([[count]]) AS [[total]]").
+		From("usage").
+		Where(dbx.NewExp("[[hour]] >= {:from} AND [[hour]] < {:to}", dbx.Params{
+			"from": fromDt.String(),
+			"to":   toDt.String(),
+		}))
+	for _, col := range dimensions {
+		if v := query.Get(col); v != "" {
+			q.AndWhere(dbx.HashExp{col: v})
+		}
+	}
+
+	var rows []dbx.NullStringMap
+	if err := q.GroupBy(columns...).OrderBy(columns...).All(&rows); err != nil {
+		return e.InternalServerError("query usage failed", err)
+	}
+
+	items := make([]map[string]any, 0, len(rows))
+	for _, row := range rows {
+		item := make(map[string]any, len(columns)+1)
+		for _, col := range columns {
+			item[col] = row[col].String
+		}
+		item["count"], _ = strconv.ParseInt(row["total"].String, 10, 64)
+		items = append(items, item)
+	}
+	return e.JSON(http.StatusOK, map[string]any{
+		"from":  from,
+		"to":    to,
+		"items": items,
+	})
+}
+
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/pundix/chain-gateway/internal/config"
 	secretkey "github.com/pundix/chain-gateway/internal/secret_key"
 	"github.com/pundix/chain-gateway/internal/upstream"
+	"github.com/pundix/chain-gateway/internal/usage"
 
 	_ "github.com/pundix/chain-gateway/migrations"
 )
@@ -52,6 +53,8 @@ func main() {
 				col.Apply(e.App, chainGatewayCli)
 			}
 		}
+		// usage reporting works without the gateway api
+		usage.Me().Apply(e.App, nil)
 
 		return e.Next()
 	})
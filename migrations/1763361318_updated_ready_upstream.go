@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3380222617")
+		if err != nil {
+			return err
+		}
+
+		// refresh the view fields so protocol picks up the rest and cometbft values
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3380222617")
+		if err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("usage")
+		collection.Fields.Add(&core.DateField{
+			Name:     "hour",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "protocol",
+		})
+		// the hex SHA-256 of the access key, never the key itself
+		collection.Fields.Add(&core.TextField{
+			Name: "access_key",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "group",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "service",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "chain_id",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "method",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "status",
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "count",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "created",
+			OnCreate: true,
+		})
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "updated",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+		collection.AddIndex("idx_usage_bucket", true, "`hour`, `protocol`, `access_key`, `group`, `service`, `chain_id`, `method`, `status`", "")
+		collection.AddIndex("idx_usage_group_hour", false, "`group`, `hour`", "")
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("usage")
+		if err != nil {
+			return err
+		}
+		return app.Delete(collection)
+	})
+}
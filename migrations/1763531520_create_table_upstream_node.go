@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("upstream_node")
+		collection.Fields.Add(&core.TextField{
+			Name: "protocol",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "chain_id",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "url",
+			Required: true,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "latency_p50",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "latency_p95",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.DateField{
+			Name: "latency_checked",
+		})
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "created",
+			OnCreate: true,
+		})
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "updated",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+		collection.AddIndex("idx_upstream_node_url", true, "`protocol`, `chain_id`, `url`", "")
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("upstream_node")
+		if err != nil {
+			return err
+		}
+		return app.Delete(collection)
+	})
+}
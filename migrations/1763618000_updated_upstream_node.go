@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("upstream_node")
+		if err != nil {
+			return err
+		}
+
+		// capability tags found by the Capability check strategy
+		collection.Fields.Add(&core.JSONField{
+			Name: "tags",
+		})
+		collection.Fields.Add(&core.DateField{
+			Name: "tags_checked",
+		})
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("upstream_node")
+		if err != nil {
+			return err
+		}
+
+		collection.Fields.RemoveByName("tags")
+		collection.Fields.RemoveByName("tags_checked")
+		return app.Save(collection)
+	})
+}
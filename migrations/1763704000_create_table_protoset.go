@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("protoset")
+		collection.Fields.Add(&core.TextField{
+			Name:     "name",
+			Required: true,
+		})
+		collection.Fields.Add(&core.FileField{
+			Name:      "file",
+			Required:  true,
+			MaxSelect: 1,
+			MaxSize:   5 << 20,
+		})
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "created",
+			OnCreate: true,
+		})
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "updated",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+		collection.AddIndex("idx_protoset_name", true, "`name`", "")
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("protoset")
+		if err != nil {
+			return err
+		}
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("check_result")
+		collection.Fields.Add(&core.TextField{
+			Name: "source",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "protocol",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "chain_id",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "url",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "strategy",
+		})
+		collection.Fields.Add(&core.BoolField{
+			Name: "passed",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "reason",
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "height",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "latency",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "created",
+			OnCreate: true,
+		})
+		collection.AddIndex("idx_check_result_node", false, "`chain_id`, `url`, `created`", "")
+		collection.AddIndex("idx_check_result_created", false, "`created`", "")
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("check_result")
+		if err != nil {
+			return err
+		}
+		return app.Delete(collection)
+	})
+}
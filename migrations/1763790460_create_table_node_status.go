@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// current health of a node per source, each source's rules judge the node on their own
+		collection := core.NewBaseCollection("node_status")
+		collection.Fields.Add(&core.TextField{
+			Name: "source",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "protocol",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "chain_id",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "url",
+			Required: true,
+		})
+		collection.Fields.Add(&core.BoolField{
+			Name: "passed",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "reason",
+		})
+		collection.Fields.Add(&core.DateField{
+			Name: "status_changed",
+		})
+		collection.Fields.Add(&core.DateField{
+			Name: "checked",
+		})
+		collection.AddIndex("idx_node_status_node", true, "`source`, `protocol`, `chain_id`, `url`", "")
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("node_status")
+		if err != nil {
+			return err
+		}
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("upstream_node")
+		if err != nil {
+			return err
+		}
+
+		// latest block height seen by the checks, read by the jsonrpc proxy for block aware routing
+		collection.Fields.Add(&core.NumberField{
+			Name:    "height",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.DateField{
+			Name: "height_checked",
+		})
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("upstream_node")
+		if err != nil {
+			return err
+		}
+
+		collection.Fields.RemoveByName("height")
+		collection.Fields.RemoveByName("height_checked")
+		return app.Save(collection)
+	})
+}
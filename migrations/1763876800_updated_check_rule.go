@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("check_rule")
+		if err != nil {
+			return err
+		}
+
+		// consecutive failures before a ready node is removed, and passes before it is restored
+		collection.Fields.Add(&core.NumberField{
+			Name:    "fail_threshold",
+			OnlyInt: true,
+			Min:     types.Pointer(0.0),
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "pass_threshold",
+			OnlyInt: true,
+			Min:     types.Pointer(0.0),
+		})
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("check_rule")
+		if err != nil {
+			return err
+		}
+
+		collection.Fields.RemoveByName("fail_threshold")
+		collection.Fields.RemoveByName("pass_threshold")
+		return app.Save(collection)
+	})
+}
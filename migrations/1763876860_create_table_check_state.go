@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("check_state")
+		collection.Fields.Add(&core.TextField{
+			Name: "source",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "protocol",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "chain_id",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "url",
+			Required: true,
+		})
+		collection.Fields.Add(&core.BoolField{
+			Name: "ready",
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "fails",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "passes",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "updated",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+		collection.AddIndex("idx_check_state_node", true, "`source`, `protocol`, `chain_id`, `url`", "")
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("check_state")
+		if err != nil {
+			return err
+		}
+		return app.Delete(collection)
+	})
+}
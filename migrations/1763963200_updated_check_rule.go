@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("check_rule")
+		if err != nil {
+			return err
+		}
+
+		// the smallest ready list, and the largest share of it in percent that one run may remove
+		collection.Fields.Add(&core.NumberField{
+			Name:    "min_ready",
+			OnlyInt: true,
+			Min:     types.Pointer(0.0),
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name: "max_shrink",
+			Min:  types.Pointer(0.0),
+			Max:  types.Pointer(100.0),
+		})
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("check_rule")
+		if err != nil {
+			return err
+		}
+
+		collection.Fields.RemoveByName("min_ready")
+		collection.Fields.RemoveByName("max_shrink")
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// runs whose ready list was held up by min_ready or max_shrink
+		collection := core.NewBaseCollection("safeguard_event")
+		collection.Fields.Add(&core.TextField{
+			Name: "source",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "protocol",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "chain_id",
+			Required: true,
+		})
+		collection.Fields.Add(&core.SelectField{
+			Name:      "safeguards",
+			Values:    []string{"min_ready", "max_shrink"},
+			MaxSelect: 2,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "previous",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "passed",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "ready",
+			OnlyInt: true,
+		})
+		// the failed urls kept ready
+		collection.Fields.Add(&core.JSONField{
+			Name: "restored",
+		})
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "created",
+			OnCreate: true,
+		})
+		collection.AddIndex("idx_safeguard_event_chain", false, "`chain_id`, `created`", "")
+		collection.AddIndex("idx_safeguard_event_created", false, "`created`", "")
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("safeguard_event")
+		if err != nil {
+			return err
+		}
+		return app.Delete(collection)
+	})
+}
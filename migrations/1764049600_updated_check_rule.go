@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("check_rule")
+		if err != nil {
+			return err
+		}
+
+		// how often and how long the rule runs, and the outcome of its last run
+		collection.Fields.Add(&core.TextField{
+			Name: "interval",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "timeout",
+		})
+		collection.Fields.Add(&core.DateField{
+			Name: "last_run",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "last_error",
+		})
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("check_rule")
+		if err != nil {
+			return err
+		}
+
+		collection.Fields.RemoveByName("interval")
+		collection.Fields.RemoveByName("timeout")
+		collection.Fields.RemoveByName("last_run")
+		collection.Fields.RemoveByName("last_error")
+		return app.Save(collection)
+	})
+}
@@ -1,12 +1,14 @@
 package pocketbase
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 )
@@ -14,20 +16,17 @@ import (
 type Client struct {
 	BaseURL    string
 	HTTPClient *http.Client
-	// apiToken   string
+	// apiToken is sent as Authorization header when set, writes to superuser only collections need it
+	apiToken string
 }
 
 func New(baseURL string) *Client {
-	// apiToken := os.Getenv("PB_API_TOKEN")
-	// if apiToken == "" {
-	// 	panic("PB_API_TOKEN is required")
-	// }
 	return &Client{
 		BaseURL: strings.TrimRight(baseURL, "/"),
 		HTTPClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		// apiToken: apiToken,
+		apiToken: os.Getenv("PB_API_TOKEN"),
 	}
 }
 
@@ -76,6 +75,27 @@ func (c *Client) GetFirstListItem(collectionIdOrName string, opts ListOptions) (
 	return resp.Items[0], nil
 }
 
+// ListAllRecords walks every page of the list and returns all items at once.
+func (c *Client) ListAllRecords(collectionIdOrName string, opts ListOptions) ([]map[string]any, error) {
+	if opts.PerPage <= 0 {
+		opts.PerPage = 500
+	}
+	opts.SkipTotal = true
+
+	var items []map[string]any
+	for page := 1; ; page++ {
+		opts.Page = page
+		resp, err := c.ListRecords(collectionIdOrName, opts)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, resp.Items...)
+		if len(resp.Items) < opts.PerPage {
+			return items, nil
+		}
+	}
+}
+
 func (c *Client) ListRecords(collectionIdOrName string, opts ListOptions) (*ListResponse, error) {
 	if collectionIdOrName == "" {
 		return nil, fmt.Errorf("collectionIdOrName is required")
@@ -113,37 +133,84 @@ func (c *Client) ListRecords(collectionIdOrName string, opts ListOptions) (*List
 		u += "?" + enc
 	}
 
-	req, err := http.NewRequest("GET", u, nil)
-	if err != nil {
+	var out ListResponse
+	if err := c.send(http.MethodGet, u, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateRecord creates a record and returns it as stored.
+func (c *Client) CreateRecord(collectionIdOrName string, data map[string]any) (map[string]any, error) {
+	if collectionIdOrName == "" {
+		return nil, fmt.Errorf("collectionIdOrName is required")
+	}
+	u := c.BaseURL + "/api/collections/" + url.PathEscape(collectionIdOrName) + "/records"
+
+	var out map[string]any
+	if err := c.send(http.MethodPost, u, data, &out); err != nil {
 		return nil, err
 	}
-	// req.Header.Set("Authorization", "Bearer "+c.apiToken)
+	return out, nil
+}
+
+// UpdateRecord updates a record, number fields also accept the "field+" and "field-" modifiers.
+func (c *Client) UpdateRecord(collectionIdOrName, id string, data map[string]any) (map[string]any, error) {
+	if collectionIdOrName == "" {
+		return nil, fmt.Errorf("collectionIdOrName is required")
+	}
+	if id == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+	u := c.BaseURL + "/api/collections/" + url.PathEscape(collectionIdOrName) + "/records/" + url.PathEscape(id)
+
+	var out map[string]any
+	if err := c.send(http.MethodPatch, u, data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) send(method, u string, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiToken != "" {
+		req.Header.Set("Authorization", c.apiToken)
+	}
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	body, readErr := io.ReadAll(resp.Body)
+	respBody, readErr := io.ReadAll(resp.Body)
 	if readErr != nil {
-		return nil, readErr
+		return readErr
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		var apiErr APIError
-		if err := json.Unmarshal(body, &apiErr); err == nil && (apiErr.Message != "" || apiErr.Status != 0 || apiErr.Data != nil) {
+		if err := json.Unmarshal(respBody, &apiErr); err == nil && (apiErr.Message != "" || apiErr.Status != 0 || apiErr.Data != nil) {
 			if apiErr.Status == 0 {
 				apiErr.Status = resp.StatusCode
 			}
-			return nil, &apiErr
+			return &apiErr
 		}
-		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
 	}
-
-	var out ListResponse
-	if err := json.Unmarshal(body, &out); err != nil {
-		return nil, err
-	}
-	return &out, nil
+	return json.Unmarshal(respBody, out)
 }
@@ -227,3 +227,128 @@ func TestGetFirstListItem_NoRows(t *testing.T) {
 		t.Fatalf("expected sql.ErrNoRows, got %v", err)
 	}
 }
+
+func TestListAllRecords_Pages(t *testing.T) {
+	var pages []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		pages = append(pages, q.Get("page"))
+		if q.Get("perPage") != "2" || q.Get("skipTotal") != "true" {
+			t.Errorf("unexpected query params: %v", q)
+		}
+		items := []map[string]any{{"id": "a"}, {"id": "b"}}
+		if q.Get("page") == "2" {
+			items = []map[string]any{{"id": "c"}}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&ListResponse{Items: items})
+	}))
+	defer ts.Close()
+
+	cli := New(ts.URL)
+	cli.HTTPClient = ts.Client()
+
+	items, err := cli.ListAllRecords("posts", ListOptions{PerPage: 2})
+	if err != nil {
+		t.Fatalf("ListAllRecords error: %v", err)
+	}
+	if len(items) != 3 || items[2]["id"] != "c" {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+	if len(pages) != 2 || pages[0] != "1" || pages[1] != "2" {
+		t.Fatalf("expected pages 1 and 2, got %v", pages)
+	}
+}
+
+func TestCreateRecord_Success(t *testing.T) {
+	var capturedMethod, capturedPath, capturedAuth string
+	var capturedBody map[string]any
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedMethod = r.Method
+		capturedPath = r.URL.Path
+		capturedAuth = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&capturedBody)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": "ae40239d2bc4477", "count": 3})
+	}))
+	defer ts.Close()
+
+	cli := New(ts.URL)
+	cli.HTTPClient = ts.Client()
+	cli.apiToken = "token"
+
+	out, err := cli.CreateRecord("usage", map[string]any{"count": 3})
+	if err != nil {
+		t.Fatalf("CreateRecord error: %v", err)
+	}
+	if capturedMethod != http.MethodPost || capturedPath != "/api/collections/usage/records" {
+		t.Fatalf("unexpected request %s %s", capturedMethod, capturedPath)
+	}
+	if capturedAuth != "token" {
+		t.Fatalf("expected Authorization token, got %q", capturedAuth)
+	}
+	if capturedBody["count"] != float64(3) {
+		t.Fatalf("unexpected body: %+v", capturedBody)
+	}
+	if out["id"] != "ae40239d2bc4477" {
+		t.Fatalf("unexpected record: %+v", out)
+	}
+}
+
+func TestUpdateRecord_Success(t *testing.T) {
+	var capturedMethod, capturedPath, capturedAuth string
+	var capturedBody map[string]any
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedMethod = r.Method
+		capturedPath = r.URL.Path
+		capturedAuth = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&capturedBody)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": "ae40239d2bc4477", "count": 5})
+	}))
+	defer ts.Close()
+
+	cli := New(ts.URL)
+	cli.HTTPClient = ts.Client()
+
+	out, err := cli.UpdateRecord("usage", "ae40239d2bc4477", map[string]any{"count+": 2})
+	if err != nil {
+		t.Fatalf("UpdateRecord error: %v", err)
+	}
+	if capturedMethod != http.MethodPatch || capturedPath != "/api/collections/usage/records/ae40239d2bc4477" {
+		t.Fatalf("unexpected request %s %s", capturedMethod, capturedPath)
+	}
+	if capturedAuth != "" {
+		t.Fatalf("expected no Authorization header, got %q", capturedAuth)
+	}
+	if capturedBody["count+"] != float64(2) {
+		t.Fatalf("unexpected body: %+v", capturedBody)
+	}
+	if out["count"] != float64(5) {
+		t.Fatalf("unexpected record: %+v", out)
+	}
+}
+
+func TestUpdateRecord_APIError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"status": 404, "message": "The requested resource wasn't found.", "data": {}}`))
+	}))
+	defer ts.Close()
+
+	cli := New(ts.URL)
+	cli.HTTPClient = ts.Client()
+
+	_, err := cli.UpdateRecord("usage", "missing", map[string]any{"count+": 1})
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("expected *APIError, got %T: %v", err, err)
+	}
+	if apiErr.Status != 404 {
+		t.Fatalf("expected status=404, got %d", apiErr.Status)
+	}
+}